@@ -0,0 +1,340 @@
+//Package asm assembles CHIP-8 source written with the mnemonics from
+//Cowgod's Chip-8 Technical Reference, the same syntax `chip8 disasm`
+//writes by default.
+//
+//A line holds an optional label, then an instruction or directive, then an
+//optional comment:
+//
+//	loop:   DRW V0, V1, 5       ; draw the digit
+//	        JP loop
+//	speed   EQU 4               ; constants can be used anywhere a number can
+//	digits: DB 0x10, 0x20, "AB" ; strings are stored a byte per character
+//	table:  DW loop, digits+2
+//	        INCLUDE "sprites.s" ; relative to the including file
+//
+//Mnemonics, registers and directives are case insensitive, labels and
+//constants are not. Numbers are decimal, 0x or $ hex, or 0b binary, and
+//operands can be sums, differences, products and quotients of numbers and
+//names, with parentheses.
+package asm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//Where ROMs are loaded
+const origin = 0x200
+
+//A problem found in the source, at the line and column it was found
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+//Every error found in a source file. Assembly carries on past a bad line
+//so they can all be fixed in one go
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+//Assembles the file at path, and any files it includes, into a ROM
+func AssembleFile(path string) ([]byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Assemble(path, src)
+}
+
+//Assembles src into a ROM. name is used in errors and to find included
+//files
+func Assemble(name string, src []byte) ([]byte, error) {
+	a := &assembler{
+		symbols:   map[string]*symbol{},
+		including: map[string]bool{},
+		addr:      origin,
+	}
+	a.parseFile(name, src)
+
+	rom := []byte{}
+	for _, st := range a.statements {
+		code, err := a.encode(st)
+		if err != nil {
+			a.errs = append(a.errs, err)
+			code = make([]byte, st.size)
+		}
+		rom = append(rom, code...)
+	}
+	if len(rom) > 0x10000-origin && len(a.errs) == 0 {
+		a.errs = append(a.errs, &Error{File: name, Line: 1, Column: 1, Msg: fmt.Sprintf("Program is %d bytes, more than fits in memory", len(rom))})
+	}
+
+	if len(a.errs) > 0 {
+		//Encoding errors are found after every line has been read, put
+		//them back in source order
+		sort.SliceStable(a.errs, func(i, j int) bool {
+			ei, ej := a.errs[i], a.errs[j]
+			if ei.File != ej.File {
+				return ei.File < ej.File
+			}
+			if ei.Line != ej.Line {
+				return ei.Line < ej.Line
+			}
+			return ei.Column < ej.Column
+		})
+		return nil, a.errs
+	}
+	return rom, nil
+}
+
+//A position in the source
+type pos struct {
+	file   string
+	line   int
+	column int
+}
+
+func (p pos) errorf(format string, args ...interface{}) *Error {
+	return &Error{File: p.file, Line: p.line, Column: p.column, Msg: fmt.Sprintf(format, args...)}
+}
+
+//One instruction or data directive
+type statement struct {
+	pos      pos
+	mnemonic string //Upper case
+	operands []operand
+	size     int
+}
+
+//A label or constant. Constants are worked out the first time they're used,
+//so they can refer to labels further down
+type symbol struct {
+	pos       pos
+	value     int
+	expr      *operand
+	resolving bool
+}
+
+type assembler struct {
+	statements []*statement
+	symbols    map[string]*symbol
+	errs       ErrorList
+
+	//Files being parsed, to catch files that include themselves
+	including map[string]bool
+
+	//Address of the next statement
+	addr int
+}
+
+//Reads every line of a file, defining labels and working out where each
+//statement goes. Nothing is encoded until all the labels are known
+func (a *assembler) parseFile(name string, src []byte) {
+	a.including[name] = true
+	defer delete(a.including, name)
+
+	for i, text := range strings.Split(string(src), "\n") {
+		p := &lineParser{text: strings.TrimRight(text, "\r"), pos: pos{file: name, line: i + 1}}
+		if err := a.parseLine(p); err != nil {
+			a.errs = append(a.errs, err)
+		}
+	}
+}
+
+func (a *assembler) parseLine(p *lineParser) *Error {
+	p.stripComment()
+
+	p.skipSpace()
+	start := p.col
+	name := p.ident()
+	if name == "" {
+		if p.done() {
+			return nil
+		}
+		return p.at(start).errorf("Expected a label or instruction, found %q", p.rest())
+	}
+
+	//A label, then maybe an instruction on the same line
+	if p.peek() == ':' {
+		p.col++
+		if err := a.define(name, p.at(start), &symbol{value: a.addr}); err != nil {
+			return err
+		}
+		p.skipSpace()
+		if p.done() {
+			return nil
+		}
+		start = p.col
+		name = p.ident()
+		if name == "" {
+			return p.at(start).errorf("Expected an instruction, found %q", p.rest())
+		}
+	}
+
+	//name EQU value
+	p.skipSpace()
+	mark := p.col
+	if strings.EqualFold(p.ident(), "EQU") {
+		p.skipSpace()
+		if p.done() {
+			return p.at(p.col).errorf("Expected a value after EQU")
+		}
+		value := operand{text: p.rest(), pos: p.at(p.col)}
+		return a.define(name, p.at(start), &symbol{expr: &value})
+	}
+	p.col = mark
+
+	st := &statement{pos: p.at(start), mnemonic: strings.ToUpper(name)}
+	operands, err := p.operands()
+	if err != nil {
+		return err
+	}
+	st.operands = operands
+
+	if st.mnemonic == "INCLUDE" {
+		return a.include(st)
+	}
+
+	st.size, err = statementSize(st)
+	if err != nil {
+		return err
+	}
+	a.statements = append(a.statements, st)
+	a.addr += st.size
+	return nil
+}
+
+func (a *assembler) define(name string, at pos, sym *symbol) *Error {
+	if isReserved(name) {
+		return at.errorf("%q is a register or keyword and can't be used as a name", name)
+	}
+	if prev, ok := a.symbols[name]; ok {
+		return at.errorf("%q is already defined at line %d", name, prev.pos.line)
+	}
+	sym.pos = at
+	a.symbols[name] = sym
+	return nil
+}
+
+func (a *assembler) include(st *statement) *Error {
+	if len(st.operands) != 1 || !st.operands[0].isString() {
+		return st.pos.errorf("INCLUDE takes one quoted file name")
+	}
+	op := st.operands[0]
+	path := op.stringValue()
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(st.pos.file), path)
+	}
+	if a.including[path] {
+		return op.pos.errorf("%s includes itself", path)
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return op.pos.errorf("Can't include %s: %v", path, err)
+	}
+	a.parseFile(path, src)
+	return nil
+}
+
+//Looks up a label or constant
+func (a *assembler) lookup(name string, at pos) (int, *Error) {
+	sym, ok := a.symbols[name]
+	if !ok {
+		return 0, at.errorf("Undefined name %q", name)
+	}
+	if sym.expr == nil {
+		return sym.value, nil
+	}
+	if sym.resolving {
+		return 0, at.errorf("%q is defined in terms of itself", name)
+	}
+	sym.resolving = true
+	value, err := a.eval(*sym.expr)
+	sym.resolving = false
+	if err != nil {
+		return 0, err
+	}
+	sym.value, sym.expr = value, nil
+	return value, nil
+}
+
+//Builds the bytes of a statement now that every label is known
+func (a *assembler) encode(st *statement) ([]byte, *Error) {
+	switch st.mnemonic {
+	case "DB":
+		return a.encodeData(st, 1)
+	case "DW":
+		return a.encodeData(st, 2)
+	}
+
+	f, err := findForm(st)
+	if err != nil {
+		return nil, err
+	}
+	ins := chip8.Instruction{Op: f.op}
+	for i, arg := range f.args {
+		if err := a.setField(&ins, arg, st.operands[i]); err != nil {
+			return nil, err
+		}
+	}
+	if f.op == chip8.OpJPV0 && ins.X != 0 {
+		return nil, st.operands[0].pos.errorf("JP can only add V0 to the address")
+	}
+
+	//SHR and SHL shift VX by itself when VY is left out, which does the
+	//same thing whichever way the shift quirk is set
+	if (f.op == chip8.OpSHR || f.op == chip8.OpSHL) && len(f.args) == 1 {
+		ins.Y = ins.X
+	}
+	return ins.Encode(), nil
+}
+
+func (a *assembler) encodeData(st *statement, width int) ([]byte, *Error) {
+	data := []byte{}
+	for _, op := range st.operands {
+		if op.isString() {
+			if width != 1 {
+				return nil, op.pos.errorf("Strings can only be used with DB")
+			}
+			data = append(data, op.stringValue()...)
+			continue
+		}
+		if op.kind != kindExpr {
+			return nil, op.pos.errorf("Expected a value, found %q", op.text)
+		}
+		value, err := a.eval(op)
+		if err != nil {
+			return nil, err
+		}
+		if width == 1 {
+			if value < -0x80 || value > 0xFF {
+				return nil, op.pos.errorf("%d doesn't fit in a byte", value)
+			}
+			data = append(data, byte(value))
+		} else {
+			if value < -0x8000 || value > 0xFFFF {
+				return nil, op.pos.errorf("%d doesn't fit in a word", value)
+			}
+			data = append(data, byte(value>>8), byte(value))
+		}
+	}
+	return data, nil
+}
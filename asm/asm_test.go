@@ -0,0 +1,166 @@
+package asm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chip8 "alex/chip8/emulator"
+)
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []byte
+	}{
+		{"empty", "; nothing\n\n", []byte{}},
+		{"no operands", "CLS\nRET\nscr\nEXIT\nhigh", []byte{0x00, 0xE0, 0x00, 0xEE, 0x00, 0xFB, 0x00, 0xFD, 0x00, 0xFF}},
+		{"registers", "LD VA, V3\nADD v1, v2\nSHR V4\nSHL V4, V5", []byte{0x8A, 0x30, 0x81, 0x24, 0x84, 0x46, 0x84, 0x5E}},
+		{"bytes", "LD V1, 0x12\nSE V2, $FF\nSNE V3, 0b101\nRND V4, -1", []byte{0x61, 0x12, 0x32, 0xFF, 0x43, 0x05, 0xC4, 0xFF}},
+		{"keywords", "LD I, 0x345\nLD DT, V1\nLD V2, K\nLD B, V3\nLD [I], V4\nLD V5, [I]\nLD F, V6\nLD HF, V7\nADD I, V8\nLD R, V9\nLD VA, R",
+			[]byte{0xA3, 0x45, 0xF1, 0x15, 0xF2, 0x0A, 0xF3, 0x33, 0xF4, 0x55, 0xF5, 0x65, 0xF6, 0x29, 0xF7, 0x30, 0xF8, 0x1E, 0xF9, 0x75, 0xFA, 0x85}},
+		{"xo-chip", "LD I, LONG 0x1234\nPLANE 3\nAUDIO\nPITCH V1\nSAVE V1, V4\nLOAD V2, V3",
+			[]byte{0xF0, 0x00, 0x12, 0x34, 0xF3, 0x01, 0xF0, 0x02, 0xF1, 0x3A, 0x51, 0x42, 0x52, 0x33}},
+		{"labels", "start: JP end\n CALL start\nend: JP V0, start+2", []byte{0x12, 0x04, 0x22, 0x00, 0xB2, 0x02}},
+		{"label alone", "start:\n  JP start", []byte{0x12, 0x00}},
+		{"constants", "width EQU 64\nhalf EQU width / 2\nLD V0, half - 1\nLD V1, (half + 2) * 2", []byte{0x60, 0x1F, 0x61, 0x44}},
+		{"forward constant", "LD V0, later\nlater EQU 7", []byte{0x60, 0x07}},
+		{"data", "DB 1, 0xFF, -1, \"AB\"\nDW 0x1234, here\nhere: DB 0", []byte{0x01, 0xFF, 0xFF, 'A', 'B', 0x12, 0x34, 0x02, 0x09, 0x00}},
+		{"comments", "CLS ; clear the screen\n; a line to itself\nDB \";\" ; a string with a semicolon", []byte{0x00, 0xE0, ';'}},
+		{"draw", "DRW V0, V1, 5\nDRW V2, V3, 0", []byte{0xD0, 0x15, 0xD2, 0x30}},
+	}
+	for _, test := range tests {
+		got, err := Assemble("test.s", []byte(test.src))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got % X, want % X", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"JP nowhere", `test.s:1:4: Undefined name "nowhere"`},
+		{"FOO V1", "test.s:1:1: Unknown instruction FOO"},
+		{"LD V1, 0x100", "test.s:1:8: 256 is out of range, expected -128 to 255"},
+		{"DRW V0, V1, 16", "test.s:1:13: 16 is out of range, expected 0 to 15"},
+		{"a: CLS\na: CLS", `test.s:2:1: "a" is already defined at line 1`},
+		{"x EQU x+1\nLD V0, x", `test.s:1:7: "x" is defined in terms of itself`},
+		{"LD V0, 010x", `test.s:1:8: Invalid number "010x"`},
+		{"ADD V0, 4/0", "test.s:1:11: Division by zero"},
+		{"JP V1, 0x300", "test.s:1:4: JP can only add V0 to the address"},
+		{`DW "AB"`, "test.s:1:4: Strings can only be used with DB"},
+		{"LD V1, V2, V3", "test.s:1:1: LD can't take the operands V1, V2, V3"},
+		{"V1: CLS", `test.s:1:1: "V1" is a register or keyword and can't be used as a name`},
+		{"LD V0, (1+2", "test.s:1:12: Expected )"},
+		{"!!", `test.s:1:1: Expected a label or instruction, found "!!"`},
+		{"SE V0,", "test.s:1:7: Missing operand"},
+		{"DB 300", "test.s:1:4: 300 doesn't fit in a byte"},
+
+		//Every bad line is reported, in order
+		{"JP here\nFOO\nJP there", "test.s:1:4: Undefined name \"here\"\ntest.s:2:1: Unknown instruction FOO\ntest.s:3:4: Undefined name \"there\""},
+	}
+	for _, test := range tests {
+		_, err := Assemble("test.s", []byte(test.src))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Assemble(%q) = %v, want %q", test.src, err, test.err)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.s":        "CALL draw\nINCLUDE \"lib/sprites.s\"",
+		"lib/sprites.s": "draw: DRW V0, V1, 5\nRET\nINCLUDE \"digits.s\"",
+		"lib/digits.s":  "DB 0xF0",
+		"loop.s":        "INCLUDE \"loop.s\"",
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := AssembleFile(filepath.Join(dir, "main.s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x22, 0x02, 0xD0, 0x15, 0x00, 0xEE, 0xF0}; !bytes.Equal(got, want) {
+		t.Errorf("got % X, want % X", got, want)
+	}
+
+	if _, err := AssembleFile(filepath.Join(dir, "loop.s")); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("Self include = %v, want an error", err)
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		text string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"42", 42, true},
+		{"010", 10, true},
+		{"0x1F", 0x1F, true},
+		{"0X1f", 0x1F, true},
+		{"$ff", 0xFF, true},
+		{"0b101", 5, true},
+		{"0B11", 3, true},
+		{"0o17", 0, false},
+		{"1_000", 0, false},
+		{"0x", 0, false},
+		{"$", 0, false},
+		{"0b102", 0, false},
+		{"12ab", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseNumber(test.text)
+		if ok := err == nil; ok != test.ok || got != test.want {
+			t.Errorf("parseNumber(%q) = %d, %v, want %d, ok %v", test.text, got, err, test.want, test.ok)
+		}
+	}
+}
+
+//Disassembling the bundled ROMs and assembling the result gives back the
+//same bytes
+func TestDisassemblyRoundTrip(t *testing.T) {
+	roms, err := filepath.Glob("../TestPrograms/*.ch8")
+	if err != nil || len(roms) == 0 {
+		t.Fatalf("No test ROMs found: %v", err)
+	}
+	for _, path := range roms {
+		rom, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var src bytes.Buffer
+		if err := chip8.Disassemble(&src, rom, chip8.SyntaxCowgod); err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		got, err := Assemble(filepath.Base(path)+".s", src.Bytes())
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if !bytes.Equal(got, rom) {
+			t.Errorf("%s: reassembled ROM differs", path)
+		}
+	}
+}
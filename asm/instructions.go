@@ -0,0 +1,207 @@
+package asm
+
+import (
+	"strings"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//Which part of the instruction an operand goes in
+type field int
+
+const (
+	fieldNone field = iota //A keyword like I or DT that only picks the form
+	fieldX
+	fieldY
+	fieldN
+	fieldKK
+	fieldNNN
+	fieldLong
+)
+
+type arg struct {
+	kind  operandKind
+	field field
+}
+
+//One way of writing an instruction, like LD Vx, byte
+type form struct {
+	mnemonic string
+	args     []arg
+	op       chip8.Opcode
+}
+
+var (
+	vx   = arg{kindReg, fieldX}
+	vy   = arg{kindReg, fieldY}
+	n    = arg{kindExpr, fieldN}
+	kk   = arg{kindExpr, fieldKK}
+	nnn  = arg{kindExpr, fieldNNN}
+	long = arg{kindLong, fieldLong}
+)
+
+func keyword(kind operandKind) arg {
+	return arg{kind, fieldNone}
+}
+
+var forms = []form{
+	{"CLS", nil, chip8.OpCLS},
+	{"RET", nil, chip8.OpRET},
+	{"SCD", []arg{n}, chip8.OpSCD},
+	{"SCU", []arg{n}, chip8.OpSCU},
+	{"SCR", nil, chip8.OpSCR},
+	{"SCL", nil, chip8.OpSCL},
+	{"EXIT", nil, chip8.OpEXIT},
+	{"LOW", nil, chip8.OpLOW},
+	{"HIGH", nil, chip8.OpHIGH},
+	{"JP", []arg{nnn}, chip8.OpJP},
+	{"JP", []arg{vx, nnn}, chip8.OpJPV0},
+	{"CALL", []arg{nnn}, chip8.OpCALL},
+	{"SE", []arg{vx, kk}, chip8.OpSEByte},
+	{"SE", []arg{vx, vy}, chip8.OpSEReg},
+	{"SNE", []arg{vx, kk}, chip8.OpSNEByte},
+	{"SNE", []arg{vx, vy}, chip8.OpSNEReg},
+	{"SAVE", []arg{vx, vy}, chip8.OpSaveRange},
+	{"LOAD", []arg{vx, vy}, chip8.OpLoadRange},
+	{"LD", []arg{vx, kk}, chip8.OpLDByte},
+	{"LD", []arg{vx, vy}, chip8.OpLDReg},
+	{"LD", []arg{keyword(kindI), nnn}, chip8.OpLDI},
+	{"LD", []arg{keyword(kindI), long}, chip8.OpLDILong},
+	{"LD", []arg{vx, keyword(kindDT)}, chip8.OpLDVxDT},
+	{"LD", []arg{vx, keyword(kindK)}, chip8.OpLDVxK},
+	{"LD", []arg{keyword(kindDT), vx}, chip8.OpLDDTVx},
+	{"LD", []arg{keyword(kindST), vx}, chip8.OpLDSTVx},
+	{"LD", []arg{keyword(kindF), vx}, chip8.OpLDF},
+	{"LD", []arg{keyword(kindHF), vx}, chip8.OpLDHF},
+	{"LD", []arg{keyword(kindB), vx}, chip8.OpLDB},
+	{"LD", []arg{keyword(kindIndirect), vx}, chip8.OpStore},
+	{"LD", []arg{vx, keyword(kindIndirect)}, chip8.OpLoad},
+	{"LD", []arg{keyword(kindR), vx}, chip8.OpSaveFlags},
+	{"LD", []arg{vx, keyword(kindR)}, chip8.OpLoadFlags},
+	{"ADD", []arg{vx, kk}, chip8.OpADDByte},
+	{"ADD", []arg{vx, vy}, chip8.OpADDReg},
+	{"ADD", []arg{keyword(kindI), vx}, chip8.OpADDI},
+	{"OR", []arg{vx, vy}, chip8.OpOR},
+	{"AND", []arg{vx, vy}, chip8.OpAND},
+	{"XOR", []arg{vx, vy}, chip8.OpXOR},
+	{"SUB", []arg{vx, vy}, chip8.OpSUB},
+	{"SHR", []arg{vx}, chip8.OpSHR},
+	{"SHR", []arg{vx, vy}, chip8.OpSHR},
+	{"SUBN", []arg{vx, vy}, chip8.OpSUBN},
+	{"SHL", []arg{vx}, chip8.OpSHL},
+	{"SHL", []arg{vx, vy}, chip8.OpSHL},
+	{"RND", []arg{vx, kk}, chip8.OpRND},
+	{"DRW", []arg{vx, vy, n}, chip8.OpDRW},
+	{"SKP", []arg{vx}, chip8.OpSKP},
+	{"SKNP", []arg{vx}, chip8.OpSKNP},
+	{"PLANE", []arg{arg{kindExpr, fieldX}}, chip8.OpPLANE},
+	{"AUDIO", nil, chip8.OpAUDIO},
+	{"PITCH", []arg{vx}, chip8.OpPITCH},
+}
+
+//Finds the form of the statement's instruction that its operands match
+func findForm(st *statement) (*form, *Error) {
+	known := false
+	for i := range forms {
+		f := &forms[i]
+		if f.mnemonic != st.mnemonic {
+			continue
+		}
+		known = true
+		if len(f.args) != len(st.operands) {
+			continue
+		}
+		match := true
+		for j, a := range f.args {
+			if a.kind != st.operands[j].kind {
+				match = false
+				break
+			}
+		}
+		if match {
+			return f, nil
+		}
+	}
+
+	if !known {
+		return nil, st.pos.errorf("Unknown instruction %s", st.mnemonic)
+	}
+	texts := make([]string, len(st.operands))
+	for i, op := range st.operands {
+		texts[i] = op.text
+	}
+	return nil, st.pos.errorf("%s can't take the operands %s", st.mnemonic, strings.Join(texts, ", "))
+}
+
+//Number of bytes a statement takes, which has to be known before labels are
+func statementSize(st *statement) (int, *Error) {
+	switch st.mnemonic {
+	case "DB":
+		size := 0
+		for _, op := range st.operands {
+			if op.isString() {
+				size += len(op.stringValue())
+			} else {
+				size++
+			}
+		}
+		return size, nil
+	case "DW":
+		return 2 * len(st.operands), nil
+	}
+
+	f, err := findForm(st)
+	if err != nil {
+		return 0, err
+	}
+	if f.op == chip8.OpLDILong {
+		return 4, nil
+	}
+	return 2, nil
+}
+
+//Largest value each field holds. Bytes can also be written as -128 to -1
+var fieldMax = map[field]int{
+	fieldX:    0xF,
+	fieldN:    0xF,
+	fieldKK:   0xFF,
+	fieldNNN:  0xFFF,
+	fieldLong: 0xFFFF,
+}
+
+func (a *assembler) setField(ins *chip8.Instruction, arg arg, op operand) *Error {
+	switch {
+	case arg.field == fieldNone:
+		return nil
+	case op.kind == kindReg && arg.field == fieldX:
+		ins.X = op.reg
+		return nil
+	case op.kind == kindReg:
+		ins.Y = op.reg
+		return nil
+	}
+
+	value, err := a.eval(op)
+	if err != nil {
+		return err
+	}
+	min := 0
+	if arg.field == fieldKK {
+		min = -0x80
+	}
+	if value < min || value > fieldMax[arg.field] {
+		return op.pos.errorf("%d is out of range, expected %d to %d", value, min, fieldMax[arg.field])
+	}
+
+	switch arg.field {
+	case fieldX:
+		ins.X = byte(value)
+	case fieldN:
+		ins.N = byte(value)
+	case fieldKK:
+		ins.KK = byte(value)
+	default:
+		ins.NNN = uint16(value)
+	}
+	return nil
+}
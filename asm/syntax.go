@@ -0,0 +1,331 @@
+package asm
+
+import (
+	"strconv"
+	"strings"
+)
+
+//Walks through one line of source. col is a byte offset into text
+type lineParser struct {
+	text string
+	col  int
+	pos  pos
+}
+
+//Position of the byte at col, counting columns from 1
+func (p *lineParser) at(col int) pos {
+	at := p.pos
+	at.column = col + 1
+	return at
+}
+
+func (p *lineParser) done() bool {
+	return p.col >= len(p.text)
+}
+
+func (p *lineParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.text[p.col]
+}
+
+func (p *lineParser) rest() string {
+	return strings.TrimSpace(p.text[p.col:])
+}
+
+func (p *lineParser) skipSpace() {
+	for !p.done() && (p.text[p.col] == ' ' || p.text[p.col] == '\t') {
+		p.col++
+	}
+}
+
+//Cuts the line off at the first ; outside a string
+func (p *lineParser) stripComment() {
+	quoted := false
+	for i := 0; i < len(p.text); i++ {
+		switch p.text[i] {
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				p.text = p.text[:i]
+				return
+			}
+		}
+	}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+//Reads a name, or returns "" if there isn't one at col
+func (p *lineParser) ident() string {
+	start := p.col
+	if p.done() || !isIdentStart(p.text[p.col]) {
+		return ""
+	}
+	for !p.done() && isIdentChar(p.text[p.col]) {
+		p.col++
+	}
+	return p.text[start:p.col]
+}
+
+//Splits the rest of the line into comma separated operands
+func (p *lineParser) operands() ([]operand, *Error) {
+	var ops []operand
+	p.skipSpace()
+	if p.done() {
+		return nil, nil
+	}
+
+	start, quoted := p.col, false
+	for ; ; p.col++ {
+		if !p.done() && p.text[p.col] == '"' {
+			quoted = !quoted
+		}
+		if p.done() || (p.text[p.col] == ',' && !quoted) {
+			raw := p.text[start:p.col]
+			text := strings.TrimSpace(raw)
+			at := p.at(start + len(raw) - len(strings.TrimLeft(raw, " \t")))
+			if text == "" {
+				return nil, at.errorf("Missing operand")
+			}
+			if quoted {
+				return nil, at.errorf("Unterminated string")
+			}
+			ops = append(ops, newOperand(text, at))
+			if p.done() {
+				return ops, nil
+			}
+			start = p.col + 1
+		}
+	}
+}
+
+//What an operand is, for picking which form of an instruction is meant
+type operandKind int
+
+const (
+	kindExpr     operandKind = iota //A number, name or sum of them
+	kindReg                         //V0 to VF
+	kindI                           //I
+	kindIndirect                    //[I]
+	kindDT                          //DT, the delay timer
+	kindST                          //ST, the sound timer
+	kindK                           //K, a key press
+	kindF                           //F, the small font
+	kindHF                          //HF, the big font
+	kindB                           //B, binary coded decimal
+	kindR                           //R, the RPL user flags
+	kindLong                        //LONG followed by a 16 bit address
+)
+
+var keywordKinds = map[string]operandKind{
+	"I":   kindI,
+	"[I]": kindIndirect,
+	"DT":  kindDT,
+	"ST":  kindST,
+	"K":   kindK,
+	"F":   kindF,
+	"HF":  kindHF,
+	"B":   kindB,
+	"R":   kindR,
+}
+
+type operand struct {
+	kind operandKind
+	reg  byte
+	text string
+	pos  pos
+}
+
+func newOperand(text string, at pos) operand {
+	op := operand{text: text, pos: at}
+	upper := strings.ToUpper(text)
+	if kind, ok := keywordKinds[upper]; ok {
+		op.kind = kind
+	} else if reg, ok := parseRegister(upper); ok {
+		op.kind, op.reg = kindReg, reg
+	} else if strings.HasPrefix(upper, "LONG ") || strings.HasPrefix(upper, "LONG\t") {
+		rest := strings.TrimLeft(text[4:], " \t")
+		op.kind, op.text = kindLong, rest
+		op.pos.column += len(text) - len(rest)
+	}
+	return op
+}
+
+//Reads a number written in decimal, 0x or $ hex, or 0b binary. Leading
+//zeros don't make it octal
+func parseNumber(text string) (int64, error) {
+	digits, base := text, 10
+	switch lower := strings.ToLower(text); {
+	case strings.HasPrefix(lower, "$"):
+		digits, base = text[1:], 16
+	case strings.HasPrefix(lower, "0x"):
+		digits, base = text[2:], 16
+	case strings.HasPrefix(lower, "0b"):
+		digits, base = text[2:], 2
+	}
+	return strconv.ParseInt(digits, base, 32)
+}
+
+func parseRegister(name string) (byte, bool) {
+	if len(name) != 2 || (name[0] != 'V' && name[0] != 'v') {
+		return 0, false
+	}
+	r, err := strconv.ParseUint(name[1:], 16, 4)
+	return byte(r), err == nil
+}
+
+//Reports whether name can't be a label because it means something already
+func isReserved(name string) bool {
+	upper := strings.ToUpper(name)
+	if _, ok := keywordKinds[upper]; ok {
+		return true
+	}
+	_, ok := parseRegister(upper)
+	return ok || upper == "LONG" || upper == "EQU"
+}
+
+func (op operand) isString() bool {
+	return len(op.text) >= 2 && op.text[0] == '"' && op.text[len(op.text)-1] == '"'
+}
+
+func (op operand) stringValue() string {
+	return op.text[1 : len(op.text)-1]
+}
+
+//Works out the value of an expression operand
+func (a *assembler) eval(op operand) (int, *Error) {
+	e := &exprParser{a: a, p: &lineParser{text: op.text, pos: op.pos}}
+	e.base = op.pos.column - 1
+	value, err := e.sum()
+	if err != nil {
+		return 0, err
+	}
+	e.p.skipSpace()
+	if !e.p.done() {
+		return 0, e.errorf("Unexpected %q", e.p.rest())
+	}
+	return value, nil
+}
+
+type exprParser struct {
+	a    *assembler
+	p    *lineParser
+	base int
+}
+
+func (e *exprParser) errorf(format string, args ...interface{}) *Error {
+	at := e.p.at(e.base + e.p.col)
+	return at.errorf(format, args...)
+}
+
+func (e *exprParser) sum() (int, *Error) {
+	value, err := e.product()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		e.p.skipSpace()
+		switch e.p.peek() {
+		case '+', '-':
+			op := e.p.peek()
+			e.p.col++
+			rhs, err := e.product()
+			if err != nil {
+				return 0, err
+			}
+			if op == '+' {
+				value += rhs
+			} else {
+				value -= rhs
+			}
+		default:
+			return value, nil
+		}
+	}
+}
+
+func (e *exprParser) product() (int, *Error) {
+	value, err := e.unary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		e.p.skipSpace()
+		switch e.p.peek() {
+		case '*', '/':
+			op := e.p.peek()
+			e.p.col++
+			at := e.p.col
+			rhs, err := e.unary()
+			if err != nil {
+				return 0, err
+			}
+			if op == '*' {
+				value *= rhs
+			} else if rhs == 0 {
+				e.p.col = at
+				return 0, e.errorf("Division by zero")
+			} else {
+				value /= rhs
+			}
+		default:
+			return value, nil
+		}
+	}
+}
+
+func (e *exprParser) unary() (int, *Error) {
+	e.p.skipSpace()
+	switch c := e.p.peek(); {
+	case c == '-':
+		e.p.col++
+		value, err := e.unary()
+		return -value, err
+
+	case c == '(':
+		e.p.col++
+		value, err := e.sum()
+		if err != nil {
+			return 0, err
+		}
+		e.p.skipSpace()
+		if e.p.peek() != ')' {
+			return 0, e.errorf("Expected )")
+		}
+		e.p.col++
+		return value, nil
+
+	case c == '$' || (c >= '0' && c <= '9'):
+		start := e.p.col
+		e.p.col++
+		for !e.p.done() && isIdentChar(e.p.peek()) {
+			e.p.col++
+		}
+		text := e.p.text[start:e.p.col]
+		value, err := parseNumber(text)
+		if err != nil {
+			e.p.col = start
+			return 0, e.errorf("Invalid number %q", text)
+		}
+		return int(value), nil
+
+	case isIdentStart(c):
+		start := e.p.col
+		name := e.p.ident()
+		return e.a.lookup(name, e.p.at(e.base+start))
+	}
+
+	if e.p.done() {
+		return 0, e.errorf("Expected a value")
+	}
+	return 0, e.errorf("Unexpected %q", string(e.p.peek()))
+}
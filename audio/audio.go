@@ -0,0 +1,60 @@
+//Package audio has the places the emulator's sound can go. Each one is a
+//chip8.AudioSink to pass to chip8.WithAudio
+package audio
+
+import (
+	"fmt"
+	"strings"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//Sample rate the sinks use unless told otherwise
+const DefaultSampleRate = 44100
+
+//Null throws the sound away. Use it where there's no sound device, or
+//nobody to listen
+type Null struct {
+	//0 means DefaultSampleRate
+	Rate int
+}
+
+func (n Null) SampleRate() int {
+	if n.Rate > 0 {
+		return n.Rate
+	}
+	return DefaultSampleRate
+}
+
+func (n Null) WriteSamples(samples []float64) error {
+	return nil
+}
+
+func (n Null) Close() error {
+	return nil
+}
+
+//Names of the sinks Open takes
+var Sinks = []string{"speaker", "null", "wav"}
+
+//A sink that has to be closed once the machine has stopped
+type Sink interface {
+	chip8.AudioSink
+	Close() error
+}
+
+//Opens a sink by name. The wav sink writes to path
+func Open(name, path string) (Sink, error) {
+	switch strings.ToLower(name) {
+	case "speaker":
+		return NewSpeaker(DefaultSampleRate)
+	case "null":
+		return Null{}, nil
+	case "wav":
+		if path == "" {
+			return nil, fmt.Errorf("The wav audio sink needs a file to write to")
+		}
+		return CreateWAV(path, DefaultSampleRate)
+	}
+	return nil, fmt.Errorf("Unknown audio sink %q, expected one of %s", name, strings.Join(Sinks, ", "))
+}
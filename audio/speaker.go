@@ -0,0 +1,71 @@
+package audio
+
+import (
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/speaker"
+)
+
+//Speaker plays the sound on the default sound device. The machine hands
+//over a frame of sound at a time, which waits in a queue until the device
+//asks for it
+type Speaker struct {
+	rate beep.SampleRate
+
+	mu    sync.Mutex
+	queue []float64
+}
+
+//Opens the sound device. Fails on machines without one
+func NewSpeaker(rate int) (*Speaker, error) {
+	s := &Speaker{rate: beep.SampleRate(rate)}
+	if err := speaker.Init(s.rate, s.rate.N(time.Second/30)); err != nil {
+		return nil, err
+	}
+	speaker.Play(beep.StreamerFunc(s.stream))
+	return s, nil
+}
+
+func (s *Speaker) SampleRate() int {
+	return int(s.rate)
+}
+
+//Queues samples to be played. Anything more than a few frames behind is
+//dropped, so the sound doesn't lag further and further behind the screen
+func (s *Speaker) WriteSamples(samples []float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, samples...)
+	if max := s.rate.N(time.Second / 10); len(s.queue) > max {
+		s.queue = s.queue[len(s.queue)-max:]
+	}
+	return nil
+}
+
+//Fills the device's buffer from the queue, with silence if the machine
+//hasn't made enough sound, like while it's paused
+func (s *Speaker) stream(samples [][2]float64) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range samples {
+		val := 0.0
+		if i < len(s.queue) {
+			val = s.queue[i]
+		}
+		samples[i] = [2]float64{val, val}
+	}
+	if len(samples) < len(s.queue) {
+		s.queue = s.queue[len(samples):]
+	} else {
+		s.queue = s.queue[:0]
+	}
+	return len(samples), true
+}
+
+//Stops the sound
+func (s *Speaker) Close() error {
+	speaker.Clear()
+	return nil
+}
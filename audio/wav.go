@@ -0,0 +1,102 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+)
+
+//WAV writes the sound to a 16 bit mono WAV file, sample for sample as the
+//machine made it. Since the machine makes its sound in emulated time, the
+//file lines up exactly with the cycles the program ran, which makes it
+//useful for checking when sound played
+type WAV struct {
+	w    io.WriteSeeker
+	buf  *bufio.Writer
+	rate int
+
+	//Bytes of sample data written so far
+	size uint32
+
+	//The file, if CreateWAV opened it
+	file *os.File
+}
+
+//Starts a WAV file on w. The header is filled in by Close
+func NewWAV(w io.WriteSeeker, rate int) (*WAV, error) {
+	wav := &WAV{w: w, buf: bufio.NewWriter(w), rate: rate}
+	if err := wav.writeHeader(); err != nil {
+		return nil, err
+	}
+	return wav, nil
+}
+
+//Creates a WAV file at path, replacing it if it exists
+func CreateWAV(path string, rate int) (*WAV, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	wav, err := NewWAV(f, rate)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	wav.file = f
+	return wav, nil
+}
+
+func (wav *WAV) SampleRate() int {
+	return wav.rate
+}
+
+func (wav *WAV) WriteSamples(samples []float64) error {
+	var b [2]byte
+	for _, s := range samples {
+		s = math.Max(-1, math.Min(1, s))
+		binary.LittleEndian.PutUint16(b[:], uint16(int16(math.Round(s*math.MaxInt16))))
+		if _, err := wav.buf.Write(b[:]); err != nil {
+			return err
+		}
+	}
+	wav.size += uint32(2 * len(samples))
+	return nil
+}
+
+//The RIFF header for 16 bit mono PCM, with the sizes so far
+func (wav *WAV) writeHeader() error {
+	var h []byte
+	h = append(h, "RIFF"...)
+	h = binary.LittleEndian.AppendUint32(h, 36+wav.size)
+	h = append(h, "WAVEfmt "...)
+	h = binary.LittleEndian.AppendUint32(h, 16)
+	h = binary.LittleEndian.AppendUint16(h, 1) //PCM
+	h = binary.LittleEndian.AppendUint16(h, 1) //Mono
+	h = binary.LittleEndian.AppendUint32(h, uint32(wav.rate))
+	h = binary.LittleEndian.AppendUint32(h, uint32(wav.rate*2))
+	h = binary.LittleEndian.AppendUint16(h, 2)
+	h = binary.LittleEndian.AppendUint16(h, 16)
+	h = append(h, "data"...)
+	h = binary.LittleEndian.AppendUint32(h, wav.size)
+	_, err := wav.w.Write(h)
+	return err
+}
+
+//Finishes the file by filling in the sizes in the header. Closes the file
+//if CreateWAV opened it
+func (wav *WAV) Close() error {
+	err := wav.buf.Flush()
+	if err == nil {
+		if _, err = wav.w.Seek(0, io.SeekStart); err == nil {
+			err = wav.writeHeader()
+		}
+	}
+	if wav.file != nil {
+		if cerr := wav.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
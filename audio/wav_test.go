@@ -0,0 +1,165 @@
+package audio
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//The parts of a WAV file the tests look at
+type wavFile struct {
+	riffSize, fmtSize uint32
+	format, channels  uint16
+	rate, byteRate    uint32
+	blockAlign, bits  uint16
+	dataSize          uint32
+	samples           []int16
+}
+
+func readWAV(t *testing.T, path string) wavFile {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 44 || string(data[0:4]) != "RIFF" || string(data[8:16]) != "WAVEfmt " || string(data[36:40]) != "data" {
+		t.Fatalf("%s doesn't have a WAV header", path)
+	}
+	le := binary.LittleEndian
+	w := wavFile{
+		riffSize:   le.Uint32(data[4:]),
+		fmtSize:    le.Uint32(data[16:]),
+		format:     le.Uint16(data[20:]),
+		channels:   le.Uint16(data[22:]),
+		rate:       le.Uint32(data[24:]),
+		byteRate:   le.Uint32(data[28:]),
+		blockAlign: le.Uint16(data[32:]),
+		bits:       le.Uint16(data[34:]),
+		dataSize:   le.Uint32(data[40:]),
+	}
+	for i := 44; i+1 < len(data); i += 2 {
+		w.samples = append(w.samples, int16(le.Uint16(data[i:])))
+	}
+	if int(w.dataSize) != 2*len(w.samples) {
+		t.Fatalf("Header says %d bytes of samples, the file has %d", w.dataSize, 2*len(w.samples))
+	}
+	return w
+}
+
+func TestWAV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wav")
+	wav, err := CreateWAV(path, 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wav.WriteSamples([]float64{0, 1, -1, 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wav.WriteSamples([]float64{2, -2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wav.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := readWAV(t, path)
+	if w.riffSize != 36+12 || w.fmtSize != 16 || w.dataSize != 12 {
+		t.Errorf("Sizes are %d, %d and %d, want 48, 16 and 12", w.riffSize, w.fmtSize, w.dataSize)
+	}
+	if w.format != 1 || w.channels != 1 || w.bits != 16 || w.blockAlign != 2 {
+		t.Errorf("Format %d, %d channels, %d bits, %d byte blocks, want 16 bit mono PCM", w.format, w.channels, w.bits, w.blockAlign)
+	}
+	if w.rate != 8000 || w.byteRate != 16000 {
+		t.Errorf("Rate %d, %d bytes a second, want 8000 and 16000", w.rate, w.byteRate)
+	}
+
+	//Out of range samples are clipped
+	want := []int16{0, 32767, -32767, 16384, 32767, -32767}
+	for i := range want {
+		if i >= len(w.samples) || w.samples[i] != want[i] {
+			t.Fatalf("Samples are %v, want %v", w.samples, want)
+		}
+	}
+}
+
+func TestWAVEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.wav")
+	wav, err := CreateWAV(path, DefaultSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wav.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w := readWAV(t, path); w.riffSize != 36 || len(w.samples) != 0 {
+		t.Errorf("Empty file has a RIFF size of %d and %d samples", w.riffSize, len(w.samples))
+	}
+}
+
+//The file lines up with the cycles the program ran, so the sound starts
+//and stops on the samples of the instructions that started and stopped it
+func TestWAVTiming(t *testing.T) {
+	//Wait 60 frames on the delay timer, then sound for 30. At 11
+	//instructions a frame, 44100 samples a second gives every instruction
+	//735/11 samples
+	rom := []byte{
+		0x60, 0x3C, //200 V0 := 60
+		0xF0, 0x15, //202 DT := V0
+		0xF1, 0x07, //204 V1 := DT
+		0x31, 0x00, //206 skip the jump once DT is 0
+		0x12, 0x04, //208 jump 204
+		0x60, 0x1E, //20A V0 := 30
+		0xF0, 0x18, //20C ST := V0
+		0x12, 0x0E, //20E loop
+	}
+	path := filepath.Join(t.TempDir(), "timing.wav")
+	wav, err := CreateWAV(path, DefaultSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := chip8.NewMachine(chip8.WithAudio(wav), chip8.WithInstructionsPerFrame(11))
+	if err := vm.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+
+	//Find the cycle ST := V0 runs on
+	start := uint64(0)
+	for vm.Cycles() < 100*11 {
+		vm.Step()
+		if start == 0 && vm.Registers().SoundTimer > 0 {
+			start = vm.Cycles()
+		}
+	}
+	if err := wav.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if start == 0 {
+		t.Fatal("The sound timer was never set")
+	}
+
+	//The sound stops at the end of the 30th frame after the frame ST was
+	//set in
+	stop := (start/11 + 30) * 11
+	w := readWAV(t, path)
+	if got, want := len(w.samples), 100*735; got != want {
+		t.Fatalf("%d samples for 100 frames, want %d", got, want)
+	}
+	sampleOf := func(cycle uint64) int {
+		return int(cycle * 735 / 11)
+	}
+	first, last := -1, -1
+	for i, s := range w.samples {
+		if s != 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first != sampleOf(start-1) || last != sampleOf(stop)-1 {
+		t.Errorf("Sound from sample %d to %d, want %d (cycle %d) to %d (cycle %d)", first, last, sampleOf(start-1), start, sampleOf(stop)-1, stop)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"alex/chip8/asm"
+)
+
+// asmCmd represents the asm command
+var asmCmd = &cobra.Command{
+	Use:   "asm 'path/to/source'",
+	Short: "Assemble a source file into a ROM",
+	Long: `Assembles a source file written with Cowgod's mnemonics, the syntax
+"chip8 disasm" writes, into a ROM that "chip8 run" can load. The ROM is
+written next to the source with a .ch8 extension unless --output is given.
+
+Besides the instructions there are labels ("name:"), constants
+("name EQU value"), DB and DW for bytes and words of data, and
+INCLUDE "file" to assemble another file in place.`,
+	Run: asmChip8,
+	}
+
+var asmOutput string
+
+func asmChip8(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("The asm command takes one argument: a `path/to/source`")
+		os.Exit(1)
+	}
+	source := args[0]
+
+	rom, err := asm.AssembleFile(source)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	out := asmOutput
+	if out == "" {
+		out = strings.TrimSuffix(source, filepath.Ext(source)) + ".ch8"
+	}
+	if err := os.WriteFile(out, rom, 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d bytes to %s\n", len(rom), out)
+}
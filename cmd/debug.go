@@ -0,0 +1,64 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+	chip8 "alex/chip8/emulator"
+)
+
+// debugCmd represents the debug command
+var debugCmd = &cobra.Command{
+	Use:   "debug 'path/to/rom'",
+	Short: "Run a ROM under the interactive debugger",
+	Long: `Loads a ROM paused at its first instruction and reads debugger commands
+from the terminal. Type "help" at the (chip8) prompt for the list of commands.
+Ctrl-C pauses the machine wherever it is.`,
+	Run: debugChip8,
+	}
+
+func debugChip8(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("The debug command takes one argument: a `path/to/rom`")
+		os.Exit(1)
+	}
+	filePath := args[0]
+	applyConfig(cmd, filePath)
+	applyDatabase(cmd, filePath)
+	if frontend == "tty" {
+		fmt.Println("The debugger reads commands from the terminal, so it can't draw there too. Try --frontend gui or headless")
+		os.Exit(1)
+	}
+
+	openFrontend(func(display chip8.Frontend) {
+		vm := newVM(filePath, display)
+		vm.Pause()
+		dbg := chip8.NewDebugger(vm, os.Stdout)
+
+		//Ctrl-C pauses rather than quits
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		defer signal.Stop(interrupts)
+		go func() {
+			for range interrupts {
+				dbg.Interrupt()
+			}
+		}()
+
+		go vm.Run()
+		go func() {
+			dbg.Serve(os.Stdin)
+			vm.Stop()
+		}()
+
+		<-vm.ShutdownChan
+		closeAudio()
+		stopVideo(vm)
+	})
+}
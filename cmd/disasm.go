@@ -0,0 +1,48 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	chip8 "alex/chip8/emulator"
+)
+
+// disasmCmd represents the disasm command
+var disasmCmd = &cobra.Command{
+	Use:   "disasm 'path/to/rom'",
+	Short: "Print a ROM as assembly",
+	Long: `Decodes a ROM into assembly with the address and raw bytes of every line.
+Code is found by following every jump, call and skip from 0x200, anything
+not reached that way is printed as data. Jump targets are labelled loc_XXXX
+and subroutines sub_XXXX.`,
+	Run: disasmChip8,
+	}
+
+var syntax string
+
+func disasmChip8(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("The disasm command takes one argument: a `path/to/rom`")
+		os.Exit(1)
+	}
+
+	s, err := chip8.ParseSyntax(syntax)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	rom, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := chip8.Disassemble(os.Stdout, rom, s); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	chip8 "alex/chip8/emulator"
+)
+
+// infoCmd represents the info command
+var infoCmd = &cobra.Command{
+	Use:   "info 'path/to/rom'",
+	Short: "Print what the CHIP-8 database knows about a ROM",
+	Long: `Looks a ROM up by its SHA-1 in the community CHIP-8 database and prints
+its title, authors, platform, quirks, speed, colours and keys. run, debug and
+serve set themselves up the same way for ROMs the database knows.
+
+The database is the programs.json from
+https://github.com/chip-8/chip-8-database. Put it in the chip8 folder of the
+user config directory, next to the settings file, or point --database at it.`,
+	Run: infoChip8,
+	}
+
+func infoChip8(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("The info command takes one argument: a `path/to/rom`")
+		os.Exit(1)
+	}
+	applyConfig(cmd, args[0])
+	rom, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	hash := chip8.ROMHash(rom)
+	fmt.Printf("SHA-1:       %s\n", hash)
+
+	db, path := openDatabase()
+	if db == nil {
+		fmt.Printf("No CHIP-8 database found at %s. See `chip8 help info`\n", path)
+		os.Exit(1)
+	}
+	entry, ok := db.Lookup(hash)
+	if !ok {
+		fmt.Println("This ROM isn't in the database")
+		return
+	}
+
+	fmt.Printf("Title:       %s\n", entry.Title())
+	if authors := entry.Authors(); len(authors) > 0 {
+		fmt.Printf("Authors:     %s\n", strings.Join(authors, ", "))
+	}
+	if release := entry.Release(); release != "" {
+		fmt.Printf("Released:    %s\n", release)
+	}
+	if entry.ROM.File != "" {
+		fmt.Printf("File:        %s\n", entry.ROM.File)
+	}
+	if len(entry.ROM.Platforms) > 0 {
+		fmt.Printf("Platforms:   %s\n", strings.Join(entry.ROM.Platforms, ", "))
+	}
+	if name, ok := entry.Platform(); ok {
+		q, _ := entry.Quirks()
+		fmt.Printf("Runs as:     --platform %s\n", name)
+		fmt.Printf("Quirks:      %+v\n", q)
+	} else {
+		fmt.Println("Runs as:     none of its platforms are supported")
+	}
+	if entry.ROM.Tickrate > 0 {
+		fmt.Printf("Speed:       --ipf %d\n", entry.ROM.Tickrate)
+	}
+	if p, ok := entry.Palette(); ok {
+		fmt.Printf("Colours:     --palette %s\n", p)
+	}
+	if hints := entry.KeyHints(parseKeymap()); len(hints) > 0 {
+		fmt.Printf("Keys:        %s\n", strings.Join(hints, ", "))
+	}
+	description := entry.ROM.Description
+	if description == "" {
+		description = entry.Program.Description
+	}
+	if description != "" {
+		fmt.Printf("\n%s\n", strings.TrimSpace(description))
+	}
+}
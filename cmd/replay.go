@@ -0,0 +1,118 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	chip8 "alex/chip8/emulator"
+)
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay 'path/to/movie' ['path/to/rom']",
+	Short: "Play back a movie recorded with --record-input",
+	Long: `Runs the ROM a movie was recorded with again, with the same settings and
+the same keypad input at the same cycles, and checks the display matches the
+recording all the way through. The ROM is looked for next to the movie if it
+isn't given.
+
+Replays run headless as fast as possible by default. Use --frontend gui to
+watch one.`,
+	Run: replayChip8,
+	}
+
+var recordInput string
+
+//Movie being recorded for --record-input, saved by saveRecording
+var recording *chip8.Movie
+
+//Starts recording the keypad if --record-input was given
+func startRecording(vm *chip8.Machine, filePath string) {
+	if recordInput == "" {
+		return
+	}
+	if loadState != "" {
+		fmt.Println("--record-input can't be used with --load-state, movies start from power on")
+		os.Exit(1)
+	}
+	recording = vm.Record(seed)
+	recording.ROM = filepath.Base(filePath)
+	recording.Platform = platform
+	recording.RNG = rngMode
+}
+
+//Finishes the --record-input movie and writes it out
+func saveRecording(vm *chip8.Machine) {
+	if recording == nil {
+		return
+	}
+	movie := vm.StopRecording()
+	if err := movie.Save(recordInput); err != nil {
+		fmt.Printf("Error saving movie: %v\n", err)
+		return
+	}
+	fmt.Printf("Saved %d key events over %d cycles to %s\n", len(movie.Events), movie.Cycles, recordInput)
+}
+
+func replayChip8(cmd *cobra.Command, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("The replay command takes a `path/to/movie` and optionally a `path/to/rom`")
+		os.Exit(1)
+	}
+	frontend = replayFrontend
+	audioName = replayAudio
+
+	movie, err := chip8.LoadMovie(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	romPath := filepath.Join(filepath.Dir(args[0]), movie.ROM)
+	if len(args) == 2 {
+		romPath = args[1]
+	}
+	rom, err := loadROM(romPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	openFrontend(func(display chip8.Frontend) {
+		mode := movie.RNG
+		if mode == "" {
+			mode = chip8.RNGDefault
+		}
+		//The movie's quirks are needed before loading, for how big a ROM
+		//can be
+		vm := chip8.NewMachine(chip8.WithFrontend(display), chip8.WithQuirks(movie.Quirks), chip8.WithRNG(newRNG(mode)), chip8.WithAudio(openAudio()))
+		err := vm.LoadROM(rom)
+		if err == nil {
+			err = vm.Replay(movie)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if frontend == "headless" {
+			err = vm.RunReplay()
+		} else {
+			go vm.Run()
+			<-vm.ShutdownChan
+			err = vm.FinishReplay()
+		}
+		closeAudio()
+		stopVideo(vm)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Replayed %d cycles, the display matched the recording at all %d checkpoints\n", movie.Cycles, len(movie.Checkpoints))
+	})
+}
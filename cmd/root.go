@@ -7,8 +7,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"alex/chip8/audio"
+	chip8 "alex/chip8/emulator"
 )
 
 
@@ -28,14 +31,122 @@ func runRoot(cmd *cobra.Command, args []string) {
 }
 
 var clockSpeed int
+var ipf int
 var debug bool
+var frontend string
+var platform string
+var loadState string
+var rewindMem int
+var seed int64
+var rngMode string
+var vipInterpreter string
+var toneFreq float64
+var waveformName string
+var volume float64
+var audioName string
+var audioFile string
+var screenshotAt string
+var screenshotFile string
+var screenshotScale int
+var paletteFlag string
+var recordVideo string
+var ttyMode string
+var keymapName string
+var configFile string
+var databaseFile string
+
+//Flags that other commands have too but with a different default get their
+//own variable, since registering a flag writes its default to the variable
+var replayFrontend string
+var replayAudio string
+var serveAudio string
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Settings file with defaults for the run, debug and serve flags, and settings for particular ROMs. Defaults to chip8/config.json in the user config directory")
+
+	rootCmd.PersistentFlags().StringVar(&databaseFile, "database", "", "The CHIP-8 database's programs.json, used to set up ROMs it knows. Defaults to chip8/programs.json in the user config directory")
+
 	rootCmd.AddCommand(runCmd)
 
 	//Defines an optional flag to set the clock speed
-	runCmd.Flags().IntVarP(&clockSpeed, "clockspeed", "c", 700, "Set clock speed")
+	runCmd.Flags().IntVarP(&clockSpeed, "clockspeed", "c", 700, "Set clock speed in instructions per second")
+	runCmd.Flags().IntVar(&ipf, "ipf", 0, "Instructions to run per 60Hz frame, overriding --clockspeed")
 	runCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug mode by appending \"--debug=[true/false]\"")
+	runCmd.Flags().StringVarP(&platform, "platform", "p", "vip", "Set which interpreter's quirks to follow: "+strings.Join(chip8.Platforms(), ", "))
+	runCmd.Flags().StringVar(&loadState, "load-state", "", "Resume from a save state. F5/F9 save to and load from this file, or 'path/to/rom.state' if not set")
+	runCmd.Flags().IntVar(&rewindMem, "rewind-mem", 16, "Megabytes of snapshots to keep for rewinding with Backspace, 0 to disable")
+	runCmd.Flags().StringVarP(&frontend, "frontend", "f", "gui", "Where to show the display: \"gui\", \"headless\" or \"tty\" for the terminal")
+	runCmd.Flags().StringVarP(&keymapName, "keymap", "k", "", "Keyboard keys for the keypad: "+strings.Join(chip8.Keymaps(), ", ")+", a keymap from the settings file, or 16 keys in keypad order like 1234qwerasdfzxcv")
+	runCmd.Flags().StringVar(&ttyMode, "tty-mode", "half", "How the tty frontend draws: \"half\" for colour half blocks or \"braille\" for braille dots")
+	runCmd.Flags().Int64Var(&seed, "seed", 0, "Seed for CXKK's random numbers, 0 for one from the clock")
+	runCmd.Flags().StringVar(&rngMode, "rng", chip8.RNGDefault, "Random number routine for CXKK: \"default\", or \"vip\" for the COSMAC VIP interpreter's, which needs --vip-interpreter")
+	runCmd.Flags().StringVar(&vipInterpreter, "vip-interpreter", "", "Dump of the 512 byte COSMAC VIP CHIP-8 interpreter, which --rng vip reads its table from. Required for --rng vip, as it isn't included")
+	runCmd.Flags().Float64Var(&toneFreq, "tone-freq", chip8.DefaultTone.Frequency, "Frequency of the buzzer in Hz")
+	runCmd.Flags().StringVar(&waveformName, "waveform", "square", "Shape of the buzzer's wave: "+strings.Join(chip8.Waveforms(), ", "))
+	runCmd.Flags().Float64Var(&volume, "volume", chip8.DefaultTone.Volume, "Volume of the sound from 0 to 1")
+	runCmd.Flags().StringVar(&audioName, "audio", "speaker", "Where the sound goes: "+strings.Join(audio.Sinks, ", "))
+	runCmd.Flags().StringVar(&audioFile, "audio-file", "", "File the wav audio sink writes to")
+	runCmd.Flags().StringVar(&screenshotAt, "screenshot-at", "", "Run headless to this cycle, or frame if it ends in f, save a screenshot and exit")
+	runCmd.Flags().StringVar(&screenshotFile, "screenshot", "", "Where --screenshot-at saves to, 'path/to/rom.png' if not set")
+	runCmd.Flags().IntVar(&screenshotScale, "scale", 8, "Size of each CHIP-8 pixel in screenshots")
+	runCmd.Flags().StringVar(&paletteFlag, "palette", "", "Colours as 2 or 4 comma separated hex values, background first, like 000000,ffffff")
+	runCmd.Flags().StringVar(&recordVideo, "record", "", "Record the display to this .gif or .y4m file")
+	runCmd.Flags().StringVar(&traceFile, "trace", "", "Write a record of every instruction executed to this file")
+	runCmd.Flags().StringVar(&traceFormat, "trace-format", "text", "Format of the --trace file: \"text\" or \"binary\"")
+	runCmd.Flags().StringVar(&traceRange, "trace-range", "", "Only trace instructions at these addresses, as START-END or START+LENGTH in hex")
+	runCmd.Flags().StringVar(&traceOps, "trace-ops", "", "Only trace these comma separated opcode classes: "+strings.Join(chip8.OpcodeClasses(), ", "))
+	runCmd.Flags().StringVar(&recordInput, "record-input", "", "Record the keypad to this movie file, to play back with `chip8 replay`")
+
+	rootCmd.AddCommand(debugCmd)
+	debugCmd.Flags().IntVarP(&clockSpeed, "clockspeed", "c", 700, "Set clock speed in instructions per second")
+	debugCmd.Flags().IntVar(&ipf, "ipf", 0, "Instructions to run per 60Hz frame, overriding --clockspeed")
+	debugCmd.Flags().StringVarP(&platform, "platform", "p", "vip", "Set which interpreter's quirks to follow: "+strings.Join(chip8.Platforms(), ", "))
+	debugCmd.Flags().StringVar(&loadState, "load-state", "", "Start from a save state")
+	debugCmd.Flags().StringVarP(&frontend, "frontend", "f", "gui", "Where to show the display: \"gui\" or \"headless\"")
+	debugCmd.Flags().StringVarP(&keymapName, "keymap", "k", "", "Keyboard keys for the keypad: "+strings.Join(chip8.Keymaps(), ", ")+", a keymap from the settings file, or 16 keys in keypad order like 1234qwerasdfzxcv")
+	debugCmd.Flags().Int64Var(&seed, "seed", 0, "Seed for CXKK's random numbers, 0 for one from the clock")
+	debugCmd.Flags().StringVar(&rngMode, "rng", chip8.RNGDefault, "Random number routine for CXKK: \"default\", or \"vip\" for the COSMAC VIP interpreter's, which needs --vip-interpreter")
+	debugCmd.Flags().StringVar(&vipInterpreter, "vip-interpreter", "", "Dump of the 512 byte COSMAC VIP CHIP-8 interpreter, which --rng vip reads its table from. Required for --rng vip, as it isn't included")
+	debugCmd.Flags().Float64Var(&toneFreq, "tone-freq", chip8.DefaultTone.Frequency, "Frequency of the buzzer in Hz")
+	debugCmd.Flags().StringVar(&waveformName, "waveform", "square", "Shape of the buzzer's wave: "+strings.Join(chip8.Waveforms(), ", "))
+	debugCmd.Flags().Float64Var(&volume, "volume", chip8.DefaultTone.Volume, "Volume of the sound from 0 to 1")
+	debugCmd.Flags().StringVar(&audioName, "audio", "speaker", "Where the sound goes: "+strings.Join(audio.Sinks, ", "))
+	debugCmd.Flags().StringVar(&audioFile, "audio-file", "", "File the wav audio sink writes to")
+
+	rootCmd.AddCommand(disasmCmd)
+	disasmCmd.Flags().StringVarP(&syntax, "syntax", "s", "cowgod", "Assembly syntax to write: \"cowgod\" or \"octo\"")
+
+	rootCmd.AddCommand(asmCmd)
+	asmCmd.Flags().StringVarP(&asmOutput, "output", "o", "", "Where to write the ROM, 'path/to/source.ch8' if not set")
+
+	rootCmd.AddCommand(traceCmd)
+
+	rootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().StringVarP(&replayFrontend, "frontend", "f", "headless", "Where to show the display: \"gui\", \"headless\" or \"tty\" for the terminal")
+	replayCmd.Flags().StringVar(&ttyMode, "tty-mode", "half", "How the tty frontend draws: \"half\" for colour half blocks or \"braille\" for braille dots")
+	replayCmd.Flags().StringVar(&vipInterpreter, "vip-interpreter", "", "Dump of the 512 byte COSMAC VIP CHIP-8 interpreter. Required for movies recorded with --rng vip, as it isn't included")
+	replayCmd.Flags().StringVar(&replayAudio, "audio", "null", "Where the sound goes: "+strings.Join(audio.Sinks, ", "))
+	replayCmd.Flags().StringVar(&audioFile, "audio-file", "", "File the wav audio sink writes to")
+
+	rootCmd.AddCommand(infoCmd)
+	infoCmd.Flags().StringVarP(&keymapName, "keymap", "k", "", "Keymap to show the ROM's keys with: "+strings.Join(chip8.Keymaps(), ", ")+", a keymap from the settings file, or 16 keys in keypad order")
+
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVarP(&serveAddr, "addr", "a", "localhost:8080", "Address to serve the page on")
+	serveCmd.Flags().StringSliceVar(&allowOrigins, "allow-origin", nil, "Other sites whose pages may connect, as host:port or a full origin like https://example.com. \"*\" allows any site")
+	serveCmd.Flags().StringVarP(&keymapName, "keymap", "k", "", "Keyboard keys for the keypad: "+strings.Join(chip8.Keymaps(), ", ")+", a keymap from the settings file, or 16 keys in keypad order like 1234qwerasdfzxcv")
+	serveCmd.Flags().IntVarP(&clockSpeed, "clockspeed", "c", 700, "Set clock speed in instructions per second")
+	serveCmd.Flags().IntVar(&ipf, "ipf", 0, "Instructions to run per 60Hz frame, overriding --clockspeed")
+	serveCmd.Flags().StringVarP(&platform, "platform", "p", "vip", "Set which interpreter's quirks to follow: "+strings.Join(chip8.Platforms(), ", "))
+	serveCmd.Flags().Int64Var(&seed, "seed", 0, "Seed for CXKK's random numbers, 0 for one from the clock")
+	serveCmd.Flags().StringVar(&rngMode, "rng", chip8.RNGDefault, "Random number routine for CXKK: \"default\", or \"vip\" for the COSMAC VIP interpreter's, which needs --vip-interpreter")
+	serveCmd.Flags().StringVar(&vipInterpreter, "vip-interpreter", "", "Dump of the 512 byte COSMAC VIP CHIP-8 interpreter, which --rng vip reads its table from. Required for --rng vip, as it isn't included")
+	serveCmd.Flags().StringVar(&paletteFlag, "palette", "", "Colours as 2 or 4 comma separated hex values, background first, like 000000,ffffff")
+	serveCmd.Flags().Float64Var(&toneFreq, "tone-freq", chip8.DefaultTone.Frequency, "Frequency of the buzzer in Hz")
+	serveCmd.Flags().StringVar(&waveformName, "waveform", "square", "Shape of the buzzer's wave: "+strings.Join(chip8.Waveforms(), ", "))
+	serveCmd.Flags().Float64Var(&volume, "volume", chip8.DefaultTone.Volume, "Volume of the sound from 0 to 1")
+	serveCmd.Flags().StringVar(&serveAudio, "audio", "null", "Where the sound goes, on the machine serving: "+strings.Join(audio.Sinks, ", "))
+	serveCmd.Flags().StringVar(&audioFile, "audio-file", "", "File the wav audio sink writes to")
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
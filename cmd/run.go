@@ -7,15 +7,45 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"alex/chip8/audio"
+	"alex/chip8/config"
 	chip8 "alex/chip8/emulator"
+	gui "alex/chip8/gui"
+	"alex/chip8/octo"
+	"alex/chip8/romdb"
+	"alex/chip8/tty"
 )
 
 // runCmd represents the run command
 var runCmd = &cobra.Command{
 	Use:   "run 'path/to/rom'",
 	Short: "Run the chip8 emulator",
+	Long: `Runs a ROM. Files ending in .8o are compiled from Octo source first.
+
+With --screenshot-at the ROM runs headless and as fast as possible to the
+given cycle, or frame if the number ends in f (like 120f), then the display
+is saved as a PNG and the emulator exits. F12 takes a screenshot at any time
+in the window.
+
+--record saves everything the display shows as an animated GIF, or a Y4M
+video for longer recordings, at 60 frames a second. F10 starts and stops a
+GIF recording in the window.
+
+--rng vip gives the same random numbers as the COSMAC VIP, whose interpreter
+reads them from a table in its own code. That code isn't included, so --rng
+vip needs --vip-interpreter pointing at a dump of the 512 byte interpreter as
+it's loaded at 0x000.
+
+Flags that aren't given are taken from the settings file, which can hold
+defaults for every flag and settings for particular ROMs by their SHA-1. See
+--config.`,
 	Run: runChip8,
 	}
 
@@ -24,17 +54,410 @@ func runChip8(cmd *cobra.Command, args []string) {
 		fmt.Println("The run command takes one argument: a `path/to/rom`")
 		os.Exit(1)
 	}
-	filePath := os.Args[2]
+	filePath := args[0]
+	applyConfig(cmd, filePath)
+	applyDatabase(cmd, filePath)
+
+	if screenshotAt != "" {
+		takeScreenshot(cmd, filePath)
+		return
+	}
+
+	openFrontend(func(display chip8.Frontend) {
+		vm := newVM(filePath, display)
+		startRecording(vm, filePath)
+		startVideo(vm)
+
+		go vm.Run()
 
-	//Starts new vm
-	vm, err := chip8.NewVM(filePath, clockSpeed, debug)
+		<-vm.ShutdownChan
+		closeAudio()
+		closeTrace()
+		saveRecording(vm)
+		stopVideo(vm)
+	})
+}
+
+//Opens the frontend picked with --frontend and calls f with it
+func openFrontend(f func(display chip8.Frontend)) {
+	switch frontend {
+	case "gui":
+		//The window has to be created on the main thread
+		gui.Run(func() {
+			win, err := gui.NewWindow()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			win.Palette = parsePalette()
+			if err := win.SetKeymap(parseKeymap()); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			f(win)
+		})
+	case "headless":
+		f(chip8.NewHeadless())
+	case "tty":
+		mode, err := tty.ParseMode(ttyMode)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		keymap := parseKeymap()
+		term, err := tty.NewTerminal(mode)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		term.Palette = parsePalette()
+		if err := term.SetKeymap(keymap); err != nil {
+			term.Close()
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		f(term)
+		term.Close()
+	default:
+		fmt.Printf("Unknown frontend %q. Try \"gui\", \"headless\" or \"tty\"\n", frontend)
+		os.Exit(1)
+	}
+}
+
+//Creates a new vm from the command line flags, running on the given frontend
+func newVM(filePath string, display chip8.Frontend) *chip8.Machine {
+	quirks, err := chip8.PlatformQuirks(platform)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if romQuirks != nil && platform == romPlatform {
+		quirks = *romQuirks
+	}
+
+	waveform, err := chip8.ParseWaveform(waveformName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	stateFile := loadState
+	if stateFile == "" {
+		stateFile = filePath + ".state"
+	}
+
+	opts := []chip8.Option{
+		chip8.WithRNG(newRNG(rngMode)),
+		chip8.WithAudio(openAudio()),
+		chip8.WithStateFile(stateFile),
+		chip8.WithScreenshots(strings.TrimSuffix(filePath, filepath.Ext(filePath)), screenshotScale, parsePalette()),
+		chip8.WithRewind(rewindMem << 20),
+		chip8.WithClockSpeed(clockSpeed),
+		chip8.WithInstructionsPerFrame(ipf),
+		chip8.WithQuirks(quirks),
+		chip8.WithTone(chip8.Tone{Frequency: toneFreq, Waveform: waveform, Volume: volume}),
+		chip8.WithDebug(debug),
+		chip8.WithFrontend(display),
+	}
+	vm := chip8.NewMachine(append(opts, traceOptions()...)...)
+	rom, err := loadROM(filePath)
+	if err == nil {
+		err = vm.LoadROM(rom)
+	}
 	if err != nil {
 		fmt.Printf("\nError creating a new CHIP-8 VM: %v\n", err)
 		os.Exit(1)
 	}
 
-	go vm.Audio()
-	go vm.Run()
+	if loadState != "" {
+		if err := vm.LoadStateFile(loadState); err != nil {
+			fmt.Printf("\nError loading state: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	<-vm.ShutdownChan
+	return vm
+}
+
+//Builds the RNG for a random mode, seeded with --seed. A seed of 0 is
+//replaced with one from the clock, so it can be recorded in movies
+func newRNG(mode string) chip8.RNG {
+	var interpreter []byte
+	if vipInterpreter != "" {
+		var err error
+		if interpreter, err = os.ReadFile(vipInterpreter); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	rng, err := chip8.ParseRNG(mode, interpreter)
+	if err != nil {
+		fmt.Println(err)
+		if interpreter == nil && strings.EqualFold(mode, chip8.RNGVIP) {
+			fmt.Println("It isn't included with chip8. Give a dump of it with --vip-interpreter")
+		}
+		os.Exit(1)
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng.Seed(seed)
+	return rng
+}
+
+//Returns the --palette colours, or the default ones if it wasn't given
+func parsePalette() chip8.Palette {
+	if paletteFlag == "" {
+		return chip8.DefaultPalette
+	}
+	p, err := chip8.ParsePalette(paletteFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return p
+}
+
+//The settings file, loaded by applyConfig
+var conf = &config.Config{}
+
+//Flags applyConfig took from the defaults rather than the ROM's own
+//settings. The CHIP-8 database's recommendations override these
+var configDefaults map[string]bool
+
+//Loads the settings file and fills in the flags that weren't given from
+//its defaults and its settings for the ROM
+func applyConfig(cmd *cobra.Command, filePath string) {
+	path := configFile
+	if path == "" {
+		var err error
+		if path, err = config.Path(); err != nil {
+			return
+		}
+	}
+	var err error
+	if conf, err = config.Load(path); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	//A ROM that can't be read is reported when it's loaded
+	rom, _ := os.ReadFile(filePath)
+	hash := chip8.ROMHash(rom)
+	settings, found := conf.ForROM(hash)
+	if found {
+		name, _ := settings.Value(config.NameSetting)
+		if name == "" {
+			name = filepath.Base(filePath)
+		}
+		fmt.Printf("Using the settings for %s from %s\n", name, path)
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	configDefaults = map[string]bool{}
+	for _, name := range names {
+		if name == config.NameSetting {
+			continue
+		}
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			//Settings can be for flags only other commands have
+			if !isFlag(name) {
+				fmt.Printf("Unknown setting %q in %s\n", name, path)
+			}
+			continue
+		}
+		if flag.Changed {
+			continue
+		}
+		value, err := settings.Value(name)
+		if err == nil {
+			err = cmd.Flags().Set(name, value)
+		}
+		if err != nil {
+			fmt.Printf("Bad %q setting in %s: %v\n", name, path, err)
+			os.Exit(1)
+		}
+		if _, own := conf.ROMs[hash][name]; !own {
+			configDefaults[name] = true
+		}
+	}
+}
+
+//Reports whether any command has a flag with this long name
+func isFlag(name string) bool {
+	for _, cmd := range rootCmd.Commands() {
+		if cmd.Flags().Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+//Quirks the database has for the ROM, used while --platform is still the
+//one the database picked
+var romQuirks *chip8.Quirks
+var romPlatform string
+
+//Reads the CHIP-8 database from --database, or programs.json next to the
+//settings file. Returns nil if there isn't one
+func openDatabase() (*romdb.Database, string) {
+	path := databaseFile
+	if path == "" {
+		dir, err := config.Dir()
+		if err != nil {
+			return nil, ""
+		}
+		path = filepath.Join(dir, romdb.ProgramsFile)
+		if _, err := os.Stat(path); err != nil {
+			return nil, path
+		}
+	}
+	db, err := romdb.Load(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return db, path
+}
+
+//Looks the ROM up in the CHIP-8 database and fills in the platform, quirks,
+//speed and colours it recommends. Flags and the ROM's own settings win over
+//the database, and the database wins over the settings file's defaults
+func applyDatabase(cmd *cobra.Command, filePath string) {
+	db, _ := openDatabase()
+	if db == nil {
+		return
+	}
+	rom, _ := os.ReadFile(filePath)
+	entry, ok := db.Lookup(chip8.ROMHash(rom))
+	if !ok {
+		return
+	}
+	fmt.Printf("Recognised %s\n", entry.Title())
+
+	flags := cmd.Flags()
+	given := func(name string) bool {
+		return flags.Changed(name) && !configDefaults[name]
+	}
+	if name, ok := entry.Platform(); ok && !given("platform") {
+		flags.Set("platform", name)
+		if q, ok := entry.Quirks(); ok {
+			romQuirks, romPlatform = &q, name
+		}
+	}
+	if entry.ROM.Tickrate > 0 && !given("ipf") && !given("clockspeed") {
+		flags.Set("ipf", strconv.Itoa(entry.ROM.Tickrate))
+	}
+	if p, ok := entry.Palette(); ok && !given("palette") && flags.Lookup("palette") != nil {
+		flags.Set("palette", p.String())
+	}
+	if hints := entry.KeyHints(parseKeymap()); len(hints) > 0 {
+		fmt.Printf("Keys: %s\n", strings.Join(hints, ", "))
+	}
+}
+
+//Returns the keymap picked with --keymap or in the settings file
+func parseKeymap() chip8.Keymap {
+	km, err := conf.Keymap(keymapName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return km
+}
+
+//Runs headless to the --screenshot-at cycle or frame, saves the display
+//as a PNG and exits
+func takeScreenshot(cmd *cobra.Command, filePath string) {
+	//Nobody's listening to a run that only lasts a moment
+	if !cmd.Flags().Changed("audio") {
+		audioName = "null"
+	}
+	vm := newVM(filePath, chip8.NewHeadless())
+
+	at := strings.TrimSuffix(screenshotAt, "f")
+	n, err := strconv.ParseUint(at, 10, 64)
+	if err != nil {
+		fmt.Printf("Bad --screenshot-at %q, expected a cycle like 5000 or a frame like 120f\n", screenshotAt)
+		os.Exit(1)
+	}
+	if at != screenshotAt {
+		n *= uint64(vm.InstructionsPerFrame())
+	}
+	vm.RunUntil(n)
+
+	path := screenshotFile
+	if path == "" {
+		path = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".png"
+	}
+	err = vm.SaveScreenshot(path)
+	closeAudio()
+	closeTrace()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Saved the display at cycle %d to %s\n", vm.Cycles(), path)
+}
+
+//Starts recording the display if --record was given
+func startVideo(vm *chip8.Machine) {
+	if recordVideo == "" {
+		return
+	}
+	v, err := chip8.CreateVideo(recordVideo, screenshotScale, parsePalette())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	vm.StartVideo(v)
+}
+
+//Finishes the --record video, or one started with the hotkey
+func stopVideo(vm *chip8.Machine) {
+	if err := vm.StopVideo(); err != nil {
+		fmt.Printf("Error saving video: %v\n", err)
+	}
+}
+
+//The sink opened for --audio, closed by closeAudio
+var audioSink audio.Sink
+
+//Opens the sink picked with --audio. Falls back to no sound if there's no
+//sound device
+func openAudio() chip8.AudioSink {
+	sink, err := audio.Open(audioName, audioFile)
+	if err != nil && audioName == "speaker" {
+		fmt.Printf("No sound: %v\n", err)
+		sink = audio.Null{}
+	} else if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	audioSink = sink
+	return sink
+}
+
+//Finishes with the --audio sink, which writes out the end of a WAV file
+func closeAudio() {
+	if audioSink == nil {
+		return
+	}
+	if err := audioSink.Close(); err != nil {
+		fmt.Printf("Error writing audio: %v\n", err)
+	}
+}
+
+//Reads a ROM, compiling it first if it's Octo source
+func loadROM(filePath string) ([]byte, error) {
+	if strings.EqualFold(filepath.Ext(filePath), ".8o") {
+		return octo.CompileFile(filePath)
+	}
+	return os.ReadFile(filePath)
 }
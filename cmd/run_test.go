@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	chip8 "alex/chip8/emulator"
+	"alex/chip8/romdb"
+	"github.com/spf13/cobra"
+)
+
+//Flags, then the ROM's own settings, then the CHIP-8 database, then the
+//settings file's defaults
+func TestSettingsPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	romPath := filepath.Join(dir, "game.ch8")
+	rom := []byte{0x12, 0x00}
+	if err := os.WriteFile(romPath, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash := chip8.ROMHash(rom)
+	t.Cleanup(func() { configFile, databaseFile = "", "" })
+
+	programs := fmt.Sprintf(`[{"title": "Game", "roms": {%q: {
+		"platforms": ["superchip"],
+		"tickrate": 30,
+		"colors": {"pixels": ["#112233", "#ddeeff"]}
+	}}}]`, hash)
+	databaseFile = filepath.Join(dir, romdb.ProgramsFile)
+	if err := os.WriteFile(databaseFile, []byte(programs), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		rom   string
+		flags []string
+		want  map[string]string
+	}{
+		{"database over defaults", "", nil, map[string]string{"platform": "schip", "ipf": "30", "clockspeed": "1000", "palette": "112233,ddeeff,aaaaaa,555555"}},
+		{"rom settings over database", `"palette": "000000,ffffff", "clockspeed": 2000`, nil, map[string]string{"platform": "schip", "ipf": "0", "clockspeed": "2000", "palette": "000000,ffffff"}},
+		{"flags over database", "", []string{"--platform", "xochip", "--ipf", "100"}, map[string]string{"platform": "xochip", "ipf": "100", "clockspeed": "1000", "palette": "112233,ddeeff,aaaaaa,555555"}},
+	}
+	for _, test := range tests {
+		settings := fmt.Sprintf(`{
+			"defaults": {"platform": "chip8", "clockspeed": 1000, "palette": "222222,ffcc00"},
+			"roms": {%q: {%s}}
+		}`, hash, test.rom)
+		configFile = filepath.Join(dir, "config.json")
+		if err := os.WriteFile(configFile, []byte(settings), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := &cobra.Command{}
+		cmd.Flags().String("platform", "chip8", "")
+		cmd.Flags().Int("clockspeed", 700, "")
+		cmd.Flags().Int("ipf", 0, "")
+		cmd.Flags().String("palette", "", "")
+		if err := cmd.Flags().Parse(test.flags); err != nil {
+			t.Fatal(err)
+		}
+		applyConfig(cmd, romPath)
+		applyDatabase(cmd, romPath)
+		for name, want := range test.want {
+			if got := cmd.Flags().Lookup(name).Value.String(); got != want {
+				t.Errorf("%s: --%s is %q, want %q", test.name, name, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+	"alex/chip8/web"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve 'path/to/rom'",
+	Short: "Run a ROM and show it in the browser",
+	Long: `Runs a ROM and serves a page that shows it, so it can be played in a
+browser with nothing installed. Open the address it prints.
+
+Any number of tabs can watch at once. The first tab to connect plays and the
+others spectate, and when the player's tab closes the next one takes over.`,
+	Run: serveChip8,
+	}
+
+var serveAddr string
+var allowOrigins []string
+
+func serveChip8(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("The serve command takes one argument: a `path/to/rom`")
+		os.Exit(1)
+	}
+
+	applyConfig(cmd, args[0])
+	applyDatabase(cmd, args[0])
+	audioName = serveAudio
+	server := web.NewServer()
+	server.Palette = parsePalette()
+	server.Keymap = parseKeymap()
+	server.AllowedOrigins = allowOrigins
+	vm := newVM(args[0], server)
+
+	//Listen first so a port that's in use is reported before anything runs
+	listener, err := net.Listen("tcp", serveAddr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	go func() {
+		//Shutting down closes the listener, which isn't worth reporting
+		if err := http.Serve(listener, server); err != nil && !errors.Is(err, net.ErrClosed) {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}()
+	fmt.Printf("Serving %s at http://%s/\n", args[0], listener.Addr())
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		<-interrupts
+		server.Close()
+	}()
+
+	go vm.Run()
+
+	<-vm.ShutdownChan
+	listener.Close()
+	closeAudio()
+}
@@ -0,0 +1,112 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	chip8 "alex/chip8/emulator"
+)
+
+// traceCmd represents the trace command
+var traceCmd = &cobra.Command{
+	Use:   "trace 'path/to/trace'",
+	Short: "Print a binary trace as text",
+	Long: `Prints a trace written by "chip8 run --trace-format binary" in the same
+format as a text trace, so the two can be compared.`,
+	Run: printTrace,
+	}
+
+var traceFile string
+var traceFormat string
+var traceRange string
+var traceOps string
+
+//The trace being written for --trace, closed by closeTrace
+var tracer *chip8.Tracer
+var traceOut *os.File
+
+//Opens the --trace file, if one was given, and returns the options that
+//make the machine write to it
+func traceOptions() []chip8.Option {
+	if traceFile == "" {
+		return nil
+	}
+
+	format, err := chip8.ParseTraceFormat(traceFormat)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var filter chip8.TraceFilter
+	if traceRange != "" {
+		if filter.Start, filter.End, err = chip8.ParseTraceRange(traceRange); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if traceOps != "" {
+		if filter.Ops, err = chip8.ParseOpcodeClasses(traceOps); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	traceOut, err = os.Create(traceFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	tracer = chip8.NewTracer(traceOut, format, filter)
+	return []chip8.Option{chip8.WithTrace(tracer)}
+}
+
+//Finishes writing the --trace file
+func closeTrace() {
+	if tracer == nil {
+		return
+	}
+	if err := tracer.Flush(); err != nil {
+		fmt.Printf("Error writing trace: %v\n", err)
+	}
+	traceOut.Close()
+}
+
+func printTrace(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("The trace command takes one argument: a `path/to/trace`")
+		os.Exit(1)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	r, err := chip8.NewTraceReader(f)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			out.Flush()
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(out, record)
+	}
+}
@@ -0,0 +1,132 @@
+//Package config reads the settings file, config.json in the chip8 folder
+//of the user's config directory ($XDG_CONFIG_HOME/chip8 or ~/.config/chip8
+//on Linux). It looks like
+//
+//	{
+//		"defaults": {"clockspeed": 1000, "palette": "222222,ffcc00", "audio": "null"},
+//		"keymaps": {"arrows": "1,2,3,4,q,up,e,r,left,down,right,f,z,x,c,v"},
+//		"roms": {
+//			"<SHA-1 of the ROM>": {"name": "Tetris", "platform": "schip", "keymap": "arrows"}
+//		}
+//	}
+//
+//Settings are named after the flags they stand in for and take the same
+//values. Settings for a ROM override the defaults, and flags override both.
+//The CHIP-8 database's setup for a ROM comes between the defaults and the
+//ROM's own settings.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	chip8 "alex/chip8/emulator"
+)
+
+type Config struct {
+	//Used for every ROM
+	Defaults Settings `json:"defaults"`
+
+	//Keymaps by name, written the way --keymap takes them. They can be
+	//used by name anywhere a keymap is picked
+	Keymaps map[string]string `json:"keymaps,omitempty"`
+
+	//Settings for particular ROMs, by the hex SHA-1 of the ROM file
+	ROMs map[string]Settings `json:"roms,omitempty"`
+}
+
+//Settings for a run, by the long name of the flag each one stands in for.
+//Values are strings, bools or json.Numbers. The name setting isn't a flag,
+//it names the ROM for people reading the file
+type Settings map[string]interface{}
+
+//Name of the setting that names a ROM
+const NameSetting = "name"
+
+//A setting's value the way it would be typed after its flag
+func (s Settings) Value(name string) (string, error) {
+	switch v := s[name].(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("The %q setting has to be a string, number or true/false", name)
+}
+
+//The chip8 folder in the user's config directory
+func Dir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "chip8"), nil
+}
+
+//Where the settings file is kept
+func Path() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+//Reads the settings file at path. A missing file is an empty config
+func Load(path string) (*Config, error) {
+	c := &Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	//Keeps numbers as they're written, so big ones don't turn into 1e+06
+	dec.UseNumber()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", path, err)
+	}
+
+	//Hashes are looked up in lower case
+	roms := make(map[string]Settings, len(c.ROMs))
+	for hash, s := range c.ROMs {
+		roms[strings.ToLower(hash)] = s
+	}
+	c.ROMs = roms
+	return c, nil
+}
+
+//Returns the settings for a ROM, its own laid over the defaults. Reports
+//whether the ROM has settings of its own
+func (c *Config) ForROM(hash string) (Settings, bool) {
+	s := Settings{}
+	for name, v := range c.Defaults {
+		s[name] = v
+	}
+	rom, ok := c.ROMs[hash]
+	for name, v := range rom {
+		s[name] = v
+	}
+	return s, ok
+}
+
+//Looks up a keymap by name in Keymaps, falling back to chip8.ParseKeymap
+//for presets and keymaps written out. An empty name is the default keymap
+func (c *Config) Keymap(name string) (chip8.Keymap, error) {
+	if name == "" {
+		return chip8.DefaultKeymap, nil
+	}
+	if km, ok := c.Keymaps[name]; ok {
+		return chip8.ParseKeymap(km)
+	}
+	return chip8.ParseKeymap(name)
+}
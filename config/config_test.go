@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `{
+	"defaults": {"clockspeed": 1000, "palette": "222222,ffcc00", "audio": "null", "debug": false},
+	"keymaps": {"arrows": "1,2,3,4,q,up,e,r,left,down,right,f,z,x,c,v"},
+	"roms": {
+		"ABCDEF0123": {"name": "Tetris", "platform": "schip", "clockspeed": 2000000, "keymap": "arrows"},
+		"bad": {"palette": ["000000", "ffffff"]}
+	}
+}`
+
+func writeConfig(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestForROM(t *testing.T) {
+	c, err := Load(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hash string
+		own  bool
+		want map[string]string
+	}{
+		//Only the defaults
+		{"0000", false, map[string]string{"clockspeed": "1000", "palette": "222222,ffcc00", "audio": "null", "debug": "false"}},
+
+		//The ROM's own settings win, and big numbers aren't written as
+		//floats. Hashes in the file are matched in lower case
+		{"abcdef0123", true, map[string]string{"clockspeed": "2000000", "palette": "222222,ffcc00", "audio": "null", "debug": "false", "platform": "schip", "keymap": "arrows", NameSetting: "Tetris"}},
+	}
+	for _, test := range tests {
+		s, own := c.ForROM(test.hash)
+		if own != test.own {
+			t.Errorf("%s: ForROM reports own settings %v, want %v", test.hash, own, test.own)
+		}
+		if len(s) != len(test.want) {
+			t.Errorf("%s: %d settings, want %d", test.hash, len(s), len(test.want))
+		}
+		for name, want := range test.want {
+			if got, err := s.Value(name); err != nil || got != want {
+				t.Errorf("%s: Value(%q) = %q, %v, want %q", test.hash, name, got, err, want)
+			}
+		}
+	}
+
+	//The defaults aren't changed by laying a ROM's settings over them
+	if got, _ := c.Defaults.Value("clockspeed"); got != "1000" {
+		t.Errorf("Default clockspeed is %q after ForROM, want 1000", got)
+	}
+
+	s, _ := c.ForROM("bad")
+	if _, err := s.Value("palette"); err == nil || !strings.Contains(err.Error(), `The "palette" setting`) {
+		t.Errorf("Value of a list = %v, want an error", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	c, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || len(c.Defaults) != 0 || len(c.ROMs) != 0 {
+		t.Errorf("Load of a missing file = %v, %v, want an empty config", c, err)
+	}
+
+	path := writeConfig(t, `{"defaults": {`)
+	if _, err := Load(path); err == nil || !strings.HasPrefix(err.Error(), "Error reading "+path) {
+		t.Errorf("Load of bad JSON = %v, want an error naming the file", err)
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	c, err := Load(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		key  string
+		want byte
+	}{
+		{"", "w", 0x5},
+		{"arrows", "up", 0x5},
+		{"hex", "a", 0xA},
+		{"1234qwerasdfzxcv", "v", 0xF},
+	}
+	for _, test := range tests {
+		km, err := c.Keymap(test.name)
+		if err != nil {
+			t.Errorf("Keymap(%q): %v", test.name, err)
+			continue
+		}
+		if got, ok := km.Key(test.key); !ok || got != test.want {
+			t.Errorf("Keymap(%q).Key(%q) = %X, %v, want %X", test.name, test.key, got, ok, test.want)
+		}
+	}
+	if _, err := c.Keymap("nonsense"); err == nil {
+		t.Error("Keymap(\"nonsense\") didn't fail")
+	}
+}
@@ -9,11 +9,8 @@ import (
 	"fmt"
 	"time"
 	"os"
-	gui "alex/chip8/gui"
-	"github.com/faiface/beep/mp3"
-	"github.com/faiface/beep/speaker"
+	"sync"
 	//sdl "github.com/veandco/go-sdl2/sdl"
-	rand "math/rand"
 )
 
 // Format of the fontset
@@ -36,16 +33,19 @@ var fontSet = []uint8{
 	0xF0, 0x80, 0xF0, 0x80, 0x80, //F
 }
 
-//Format of the emulator
-type chip8 struct {
-	//System memory. See diagram in documentation
-	mem [4096]byte
+//Format of the emulator. Machine is safe to embed in other programs: build
+//one with NewMachine, load a ROM with LoadROM, then either drive it yourself
+//with Step/RunFrame or hand it over to Run
+type Machine struct {
+	//System memory. See diagram in documentation. XO-CHIP programs can
+	//use all 64K, everything else stays in the first 4K
+	mem [65536]byte
 
 	//General purpose registers
 	v [16]byte
 
 	//Index register
-	I uint16
+	index uint16
 
 	//Program counter
 	pc uint16
@@ -65,14 +65,50 @@ type chip8 struct {
 	//Sound timer
 	soundTime byte
 
-	//Channel to check for audio events
-	audioChan chan struct{}
+	//Where the sound goes, nil for nowhere
+	audio AudioSink
 
-	//CPU clock
-	Clock *time.Ticker
+	//Makes the samples for audio with the buzzer's tone
+	synth synth
 
-	//Graphics
-	gfx [64 * 32]byte //size of display
+	//Samples made since the last reset, and the ones not yet handed to
+	//audio
+	samples  uint64
+	audioBuf []float64
+
+	//Set after audio fails, so the error is only printed once
+	audioFailed bool
+
+	//CPU clock speed in instructions per second
+	clockSpeed int
+
+	//Instructions run per 60Hz frame. 0 works it out from clockSpeed
+	ipf int
+
+	//Number of cycles executed since the last reset
+	cycles uint64
+
+	//Graphics, big enough for hires. In lores only the first 64 * 32 are used
+	gfx [hiresWidth * hiresHeight]byte
+
+	//Set by 00FF, cleared by 00FE
+	hires bool
+
+	//RPL user flags for FX75/FX85. These survive a reset, like they did on
+	//the HP-48
+	rpl [16]byte
+
+	//Set by 00FD, or when the program crashes the machine
+	exited bool
+
+	//Why the machine stopped, if the program crashed it
+	err error
+
+	//Bitplanes selected by FN01
+	plane byte
+
+	//XO-CHIP audio pattern and pitch
+	sound soundState
 
 	//Draw flag
 	drawFlag bool
@@ -83,84 +119,318 @@ type chip8 struct {
 	//Debug flag
 	debug bool
 
-	//Channel to check for shutdown signal
+	//Behaviour of the instructions interpreters disagree on
+	quirks Quirks
+
+	//Random number source for CXKK
+	rng RNG
+
+	//Last program loaded, kept so Reset can reload it
+	rom []byte
+
+	//Where the save and load state hotkeys save to and load from
+	stateFile string
+
+	//Screenshots taken with the hotkey are saved as this followed by the
+	//cycle number and .png
+	screenshotPrefix string
+
+	//Size and colours of screenshots and videos
+	screenshotScale int
+	palette         Palette
+
+	//Recording of the display, if one is going, and the last frame it got
+	video      VideoWriter
+	videoFrame uint64
+
+	//Snapshots for stepping backwards, nil if rewinding is disabled
+	rewind *rewindBuffer
+
+	//Set while the rewind hotkey is held
+	rewinding bool
+
+	//Held by Run for every tick, so other goroutines (like the debugger)
+	//can safely look at and change the machine in between
+	mu sync.Mutex
+
+	//Set while Run is paused
+	paused bool
+
+	//Attached debugger, if any
+	debugger *Debugger
+
+	//Writes a record of every instruction, if set
+	tracer *Tracer
+
+	//Movie the keypad is being recorded into, if any
+	recorder *Movie
+
+	//Movie being played back into the keypad, if any
+	player *moviePlayer
+
+	//Closed by Stop to end Run
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	//Closed once Run has finished shutting down
 	ShutdownChan chan struct{}
 
-	//SDL window
-	win *gui.Window
+	//Where the display is drawn and the keypad is read from
+	display Frontend
 
 }
 
-//Initialise emulator instance
-func NewVM(filePath string, clockSpeed int, debug bool)  (*chip8, error) {
-	win, err := gui.NewWindow()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
-	vm := chip8{
-		mem:			[4096]byte{},
-		v:				[16]byte{},
-		pc:				0x200,
-		stack: 			[16]uint16{},
-		gfx: 			[64 * 32]byte{},
-		audioChan:		make(chan struct{}),
-		Clock:			time.NewTicker(time.Second / time.Duration(clockSpeed)),
+//Initialise emulator instance. Without options the machine runs at 700Hz
+//with the default quirks, a time seeded RNG and a Headless frontend
+func NewMachine(opts ...Option) *Machine {
+	vm := &Machine{
+		synth:			synth{tone: DefaultTone},
+		screenshotScale:	8,
+		palette:		DefaultPalette,
+		clockSpeed:		700,
+		quirks:			DefaultQuirks,
+		rng:			NewRandRNG(time.Now().UnixNano()),
+		stop:			make(chan struct{}),
 		ShutdownChan:	make(chan struct{}),
-		win:			win,
-		key: 			[16]byte{},
-		debug:			debug,
+		display:		NewHeadless(),
 		}
 
-	//Load fontset
-	for i := 0; i < 80; i++ {
-		vm.mem[i] = fontSet[i]
-		}
+	for _, opt := range opts {
+		opt(vm)
+	}
 
+	vm.Reset()
+	return vm
+}
 
+//Initialise emulator instance and load the ROM at filePath into it
+func NewVM(filePath string, opts ...Option)  (*Machine, error) {
+	vm := NewMachine(opts...)
 	if loadErr := vm.LoadProgram(filePath); loadErr != nil {
-		panic(loadErr)
 		return nil, loadErr
 	}
 
-	return &vm, nil
+	return vm, nil
+}
+
+//Puts the machine back in its power-on state, with the last loaded
+//program (if any) back in memory
+func (vm *Machine) Reset() {
+	vm.mem = [65536]byte{}
+	vm.v = [16]byte{}
+	vm.index = 0
+	vm.pc = 0x200
+	vm.op = 0
+	vm.stack = [16]uint16{}
+	vm.sp = 0
+	vm.delayTime = 0
+	vm.soundTime = 0
+	vm.cycles = 0
+	vm.samples = 0
+	vm.gfx = [hiresWidth * hiresHeight]byte{}
+	vm.hires = false
+	vm.exited = false
+	vm.err = nil
+	vm.plane = 1
+	vm.drawFlag = false
+	vm.key = [16]byte{}
+
+	//Load fontset
+	for i := 0; i < 80; i++ {
+		vm.mem[i] = fontSet[i]
+		}
+	copy(vm.mem[bigFontStart:], bigFontSet)
+
+	copy(vm.mem[0x200:], vm.rom)
+
+	vm.sound.mu.Lock()
+	vm.sound.custom = false
+	vm.sound.pattern = [16]byte{}
+	vm.sound.pitch = 64
+	vm.sound.playing = false
+	vm.sound.mu.Unlock()
 }
 
-func (vm *chip8) Run() {
+//Runs the machine against its frontend a frame at a time, 60 frames a
+//second, until the frontend is closed or Stop is called
+func (vm *Machine) Run() {
+	clock := time.NewTicker(time.Second / 60)
+	defer clock.Stop()
+
 	for {
 		select {
-		case <-vm.Clock.C:
-			if !vm.win.Closed() {
-					vm.delayTimeTick()
-					vm.FDE()
-					vm.drawOrUpdate()
-					vm.handleKeyInput()
-					vm.delayTimeTick()
-					vm.soundTimeTick()
-					if vm.debug == true {
-						vm.consoleDebug()
-					}
+		case <-clock.C:
+			if !vm.display.Closed() && !vm.exited {
+					vm.mu.Lock()
+					vm.tick()
+					vm.mu.Unlock()
 				continue
 			}
 			break
-		case <-vm.ShutdownChan:
+		case <-vm.stop:
 			break
 		}
 		break
 	}
+	if vm.err != nil {
+		fmt.Printf("\n%v", vm.err)
+	}
 	vm.signalShutdown("\nShutting down...")
 }
 
-func (vm *chip8) drawOrUpdate() {
+//Returns why the machine stopped, if the program crashed it, like
+//returning with nothing on the stack. Nil otherwise
+func (vm *Machine) Err() error {
+	return vm.err
+}
+
+//Stops the machine because the program did something it can't carry on from
+func (vm *Machine) fault(format string, args ...interface{}) {
+	vm.err = fmt.Errorf(format, args...)
+	vm.exited = true
+}
+
+//One tick of Run's clock: the rest of the current frame's instructions,
+//then the display and keypad. The display and keypad are kept going while
+//paused so the window stays responsive
+func (vm *Machine) tick() {
+	if vm.rewinding {
+		vm.Rewind()
+	}
+	for !vm.paused && !vm.rewinding && !vm.exited {
+		if vm.debugger != nil && vm.debugger.breakBefore() {
+			vm.paused = true
+			break
+		}
+		vm.Step()
+		vm.recordRewind()
+		if vm.player != nil {
+			//Replays feed the keypad after every instruction, so events
+			//land on their cycle even if the recording paused mid frame
+			vm.handleKeyInput()
+		}
+		if vm.debugger != nil && vm.debugger.breakAfter() {
+			vm.paused = true
+		}
+		if vm.frameDone() {
+			break
+		}
+	}
+
+	vm.drawOrUpdate()
+	vm.handleKeyInput()
+	vm.handleHotkeys()
+	vm.checkReplayEnd()
+}
+
+//Stops Run from executing instructions until Resume is called
+func (vm *Machine) Pause() {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	vm.paused = true
+}
+
+//Lets a paused Run carry on
+func (vm *Machine) Resume() {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	vm.paused = false
+}
+
+//Reports whether Run is paused
+func (vm *Machine) Paused() bool {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	return vm.paused
+}
+
+//Makes Run return. Safe to call more than once and from any goroutine
+func (vm *Machine) Stop() {
+	vm.stopOnce.Do(func() { close(vm.stop) })
+}
+
+//Executes a single instruction. Every InstructionsPerFrame instructions make
+//up a 60Hz frame, and the timers count down once at the end of each one.
+//Tying the timers to the cycle count rather than the wall clock keeps
+//stepping, rewinding and replays exact
+func (vm *Machine) Step() {
+	if vm.exited {
+		return
+	}
+	if vm.tracer != nil {
+		vm.tracer.before(vm)
+	}
+	vm.FDE()
+	if vm.tracer != nil {
+		vm.tracer.after(vm)
+	}
+	vm.cycles++
+	if vm.audio != nil {
+		vm.makeSound()
+	}
+	if vm.frameDone() {
+		vm.delayTimeTick()
+		vm.soundTimeTick()
+		if vm.audio != nil {
+			vm.flushSound()
+		}
+	}
+	if vm.recorder != nil && vm.cycles%uint64(60*vm.InstructionsPerFrame()) == 0 {
+		vm.recorder.checkpoint(vm)
+	}
+	if vm.player != nil {
+		vm.player.check(vm)
+	}
+	if vm.debug == true {
+		vm.consoleDebug()
+	}
+}
+
+//Executes the rest of the current 60Hz frame, then draws the screen if it
+//changed and reads the keypad
+func (vm *Machine) RunFrame() {
+	for !vm.exited {
+		vm.Step()
+		vm.recordRewind()
+		if vm.frameDone() {
+			break
+		}
+	}
+	vm.drawOrUpdate()
+	vm.handleKeyInput()
+}
+
+//Instructions run per 60Hz frame, set with WithInstructionsPerFrame or
+//worked out from the clock speed
+func (vm *Machine) InstructionsPerFrame() int {
+	if vm.ipf > 0 {
+		return vm.ipf
+	}
+	if n := vm.clockSpeed / 60; n > 0 {
+		return n
+	}
+	return 1
+}
+
+//Reports whether the last instruction was the end of a frame
+func (vm *Machine) frameDone() bool {
+	return vm.cycles%uint64(vm.InstructionsPerFrame()) == 0
+}
+
+//Draws the screen if anything was drawn since the last time
+func (vm *Machine) drawOrUpdate() {
+	if vm.video != nil {
+		vm.recordVideo()
+	}
 	if vm.drawFlag {
-		vm.win.DrawGraphics(vm.graphicsBuffer())
+		vm.display.DrawGraphics(vm.Framebuffer())
+		vm.drawFlag = false
 	} else {
-		vm.win.UpdateInput()
+		vm.display.UpdateInput()
 	}
 }
 
-// func (vm *chip8) keyPoll() {
+// func (vm *Machine) keyPoll() {
 // 	if sdlError := sdl.Init(sdl.INIT_EVERYTHING); sdlError != nil {
 // 		panic(sdlError)
 // 	}
@@ -244,152 +514,255 @@ func (vm *chip8) drawOrUpdate() {
 // 		}
 // 	}
 
-func (vm *chip8) handleKeyInput() {
-	for i, key := range vm.win.KeyMap {
-		if vm.win.JustReleased(key) && vm.win.KeysDown[i] != nil {
-			vm.win.KeysDown[i].Stop()
-			vm.win.KeysDown[i] = nil
-		} else if vm.win.JustPressed(key) {
-			if vm.win.KeysDown[i] == nil {
-				vm.win.KeysDown[i] = time.NewTicker(time.Second / 5)
-			}
-			vm.setKeyDown(byte(i))
+func (vm *Machine) handleKeyInput() {
+	events := vm.display.KeyEvents()
+	if vm.player != nil {
+		events = vm.player.eventsAt(vm.cycles)
+	}
+	for _, ev := range events {
+		if vm.recorder != nil {
+			vm.recorder.Events = append(vm.recorder.Events, MovieEvent{Cycle: vm.cycles, Key: ev.Key, Down: ev.Down})
 		}
 
-		if vm.win.KeysDown[i] == nil {
-			continue
+		//Keys stay latched until an instruction consumes them,
+		//so only presses change the keypad state
+		if ev.Down {
+			vm.setKeyDown(ev.Key)
 		}
+	}
+}
 
-		select {
-		case <-vm.win.KeysDown[i].C:
-			vm.setKeyDown(byte(i))
-		default:
+func (vm *Machine) handleHotkeys() {
+	source, ok := vm.display.(HotkeySource)
+	if !ok {
+		return
+	}
+
+	vm.rewinding = false
+	for _, hotkey := range source.Hotkeys() {
+		switch hotkey {
+		case HotkeyRewind:
+			//Random numbers can't be taken back, so movies can't follow a
+			//rewind
+			vm.rewinding = vm.rewind != nil && vm.recorder == nil && vm.player == nil
+		case HotkeySaveState:
+			if vm.stateFile == "" {
+				fmt.Println("\nNo state file to save to")
+			} else if err := vm.SaveStateFile(vm.stateFile); err != nil {
+				fmt.Printf("\nError saving state: %v\n", err)
+			} else {
+				fmt.Printf("\nSaved state to %s\n", vm.stateFile)
+			}
+		case HotkeyScreenshot:
+			path := fmt.Sprintf("%s-%d.png", vm.screenshotPrefix, vm.cycles)
+			if vm.screenshotPrefix == "" {
+				fmt.Println("\nNo file to save screenshots to")
+			} else if err := vm.SaveScreenshot(path); err != nil {
+				fmt.Printf("\nError saving screenshot: %v\n", err)
+			} else {
+				fmt.Printf("\nSaved screenshot to %s\n", path)
+			}
+		case HotkeyRecord:
+			vm.toggleVideo()
+		case HotkeyLoadState:
+			if vm.recorder != nil || vm.player != nil {
+				fmt.Println("\nCan't load a state while recording or replaying a movie")
+			} else if vm.stateFile == "" {
+				fmt.Println("\nNo state file to load from")
+			} else if err := vm.LoadStateFile(vm.stateFile); err != nil {
+				fmt.Printf("\nError loading state: %v\n", err)
+			} else {
+				fmt.Printf("\nLoaded state from %s\n", vm.stateFile)
+			}
 		}
 	}
 }
 
-func (vm *chip8) setKeyDown(index byte) {
-	vm.key[index] = 1
+//Reads a byte of memory on behalf of an instruction, so watchpoints see it
+func (vm *Machine) readMem(addr uint16) byte {
+	val := vm.mem[addr]
+	if vm.debugger != nil {
+		vm.debugger.memAccess(addr, false, val, val)
+	}
+	return val
+}
+
+//Writes a byte of memory on behalf of an instruction, so watchpoints see it
+func (vm *Machine) writeMem(addr uint16, val byte) {
+	if vm.debugger != nil {
+		vm.debugger.memAccess(addr, true, vm.mem[addr], val)
+	}
+	if vm.tracer != nil {
+		vm.tracer.memWrite(addr, val)
+	}
+	vm.mem[addr] = val
+}
+
+func (vm *Machine) setKeyDown(index byte) {
+	vm.key[index&0xF] = 1
 }
 
-func (vm *chip8) LoadProgram(filePath string) error {
+//Reads a ROM from disk and loads it with LoadROM
+func (vm *Machine) LoadProgram(filePath string) error {
 	//Reads file using os library
-	file, fileErr := os.OpenFile(filePath, os.O_RDONLY, 0777)
+	fileBuffer, fileErr := os.ReadFile(filePath)
 	if fileErr != nil {
 		return fileErr
 	}
-	defer file.Close()
 
-	//Reads file information, also using os
-	fStat, fStatErr := file.Stat()
-	if fStatErr != nil {
-		return fStatErr
-	}
-	if int64(len(vm.mem)-512) < fStat.Size() { //Checks file size doesn't exceed memory space
-		return fmt.Errorf("File size is greater than memory")
-	}
+	return vm.LoadROM(fileBuffer)
+}
 
-	fileBuffer := make([]byte, fStat.Size())
-	//Checks file can be read properly
-	if _, readErr := file.Read(fileBuffer); readErr != nil {
-		return readErr
+//Resets the machine and places the program at 0x200. It can fill the rest
+//of 4K of memory, or of 64K with the Memory64K quirk
+func (vm *Machine) LoadROM(program []byte) error {
+	memory := 0x1000
+	if vm.quirks.Memory64K {
+		memory = len(vm.mem)
 	}
-
-	//If there are no errors, replace every byte in memory from 0x200 onward
-	for i := 0; i < len(fileBuffer); i++ {
-		vm.mem[i+512] = fileBuffer[i]
+	if memory-512 < len(program) { //Checks file size doesn't exceed memory space
+		return fmt.Errorf("File size is greater than memory: %d bytes, and %d fit", len(program), memory-512)
 	}
 
+	vm.rom = append([]byte(nil), program...)
+	vm.Reset()
 	return nil
 }
 
-func Clock(d time.Duration) <-chan time.Time {
-	ch := make(chan time.Time, 1)
-	go func() {
-		for {
-		time.Sleep(d)
-		ch <- time.Now()
-	}
-	close(ch)
-	}()
-	return ch
-}	
-
 //Checks a key is pressed (check format in main)
-func (vm *chip8) Key(num uint8, down bool) {
+func (vm *Machine) Key(num uint8, down bool) {
 	if down {
-		vm.key[num] = 1
+		vm.key[num&0xF] = 1
 	} else {
-		vm.key[num] = 0
+		vm.key[num&0xF] = 0
 	}
 }
 
-func (vm *chip8) delayTimeTick() {
+func (vm *Machine) delayTimeTick() {
 	if vm.delayTime > 0 {
 		vm.delayTime --
 	}
 }
 
-func (vm *chip8) soundTimeTick() {
+func (vm *Machine) soundTimeTick() {
 	if vm.soundTime > 0 {
-		if vm.soundTime == 1 {
-			vm.audioChan <- struct{}{}
-		}
 		vm.soundTime--
+		vm.updateSound()
 	}
 }
 
-func (vm *chip8) Audio() {
-	f, err := os.Open("beep.mp3")
-	if err != nil {
-		return
+//Returns a copy of the display at its current resolution
+func (vm *Machine) Framebuffer() Framebuffer {
+	w, h := vm.width(), vm.height()
+	return Framebuffer{
+		Width:	w,
+		Height:	h,
+		Pix:	append([]byte(nil), vm.gfx[:w*h]...),
 	}
-	
-	streamer, format, err := mp3.Decode(f)
-	if err != nil {
-		return
-	}
-	defer streamer.Close()
+}	
 
-	speaker.Init(
-		format.SampleRate,
-		format.SampleRate.N(time.Second/10),
-	)
+//Snapshot of the CPU state, as returned by Registers
+type Registers struct {
+	V          [16]byte
+	I          uint16
+	PC         uint16
+	SP         uint16
+	Stack      [16]uint16
+	Opcode     uint16
+	DelayTimer byte
+	SoundTimer byte
+}
 
-	for range vm.audioChan {
-		speaker.Play(streamer)
-		fmt.Printf("\nBeep!!")
+//Returns a copy of the registers, stack and timers
+func (vm *Machine) Registers() Registers {
+	return Registers{
+		V:          vm.v,
+		I:          vm.index,
+		PC:         vm.pc,
+		SP:         vm.sp,
+		Stack:      vm.stack,
+		Opcode:     vm.op,
+		DelayTimer: vm.delayTime,
+		SoundTimer: vm.soundTime,
 	}
-}	
+}
 
-func (vm chip8) graphicsBuffer() [64 * 32]byte {
-	return vm.gfx
-}	
+//Returns a copy of the whole of memory
+func (vm *Machine) Memory() []byte {
+	return append([]byte(nil), vm.mem[:]...)
+}
+
+//Returns the state of the 16 keys, 1 meaning pressed
+func (vm *Machine) Keys() [16]byte {
+	return vm.key
+}
 
-func (vm *chip8) signalShutdown(msg string) {
+//Returns the number of instructions executed since the last reset
+func (vm *Machine) Cycles() uint64 {
+	return vm.cycles
+}
+
+func (vm *Machine) signalShutdown(msg string) {
 	fmt.Println(msg)
-	close(vm.audioChan)
-	vm.ShutdownChan <- struct{}{}
+	close(vm.ShutdownChan)
 }
 
-func (vm *chip8) drawSprite(x, y uint16) {
+func (vm *Machine) drawSprite(x, y uint16) {
 	height := vm.op & 0x000F
 	vm.v[0xF] = 0
 	var pix uint16
 
-	for yLine := uint16(0); yLine < height; yLine++ {
-		pix = uint16(vm.mem[vm.I+yLine])
+	//DXY0 draws a 16x16 sprite, two bytes per row
+	width := uint16(8)
+	if height == 0 {
+		width, height = 16, 16
+	}
+	screenW, screenH := uint16(vm.width()), uint16(vm.height())
+
+	//The starting position always wraps, only the parts of the
+	//sprite hanging off the edge are clipped or wrapped
+	x %= screenW
+	y %= screenH
+
+	//With both XO-CHIP planes selected, the sprite for plane 2
+	//follows straight on from the one for plane 1
+	addr := vm.index
+	for _, plane := range []byte{1, 2} {
+		if vm.plane & plane == 0 {
+			continue
+		}
 
-		for xLine := uint16(0); xLine < 8; xLine++ {
-			ind := (x + xLine + ((y + yLine) * 64))
-			if ind >= uint16(len(vm.graphicsBuffer())) {
-				continue
+		for yLine := uint16(0); yLine < height; yLine++ {
+			if width == 16 {
+				pix = uint16(vm.readMem(addr)) << 8 | uint16(vm.readMem(addr+1))
+				addr += 2
+			} else {
+				pix = uint16(vm.readMem(addr)) << 8
+				addr++
 			}
-			if (pix & (0x80 >> xLine)) != 0 {
-				if vm.graphicsBuffer()[ind] == 1 {
-					vm.v[0xF] = 1
+			row := y + yLine
+			if row >= screenH {
+				if vm.quirks.Clip {
+					continue
+				}
+				row %= screenH
+			}
+
+			for xLine := uint16(0); xLine < width; xLine++ {
+				col := x + xLine
+				if col >= screenW {
+					if vm.quirks.Clip {
+						break
+					}
+					col %= screenW
+				}
+				ind := col + row * screenW
+				if (pix & (0x8000 >> xLine)) != 0 {
+					if vm.gfx[ind] & plane != 0 {
+						vm.v[0xF] = 1
+					}
+					vm.gfx[ind] ^= plane
 				}
-				vm.gfx[ind] ^= 1
 			}
 		}
 	}
@@ -398,229 +771,290 @@ func (vm *chip8) drawSprite(x, y uint16) {
 }
 
 //Fetch-Decode-Execute Cycle
-func (vm *chip8) FDE() {
+func (vm *Machine) FDE() {
 	//Sets opcode variable to whats in mem, shift left, OR whats in mem+1
 	vm.op = (uint16(vm.mem[vm.pc]) << 8) | uint16(vm.mem[vm.pc+1])
-	vm.drawFlag = false
 
-	//Match opcode to first nibble
-	switch vm.op & 0xF000 {
+	//The decoder is shared with the disassembler, see decode.go
+	ins := decodeAt(vm.mem[:], vm.pc)
+	x, y := uint16(ins.X), uint16(ins.Y)
 
-	//First nibble is 0000
-	case 0x0000: 
-		switch vm.op & 0x00FF {
+	switch ins.Op {
 
-			case 0x00E0: //0x00E0 clears screen
-				vm.gfx = [64 * 32]byte{}
-				vm.pc += 2
+	case OpCLS: //0x00E0 clears screen
+		vm.clearScreen()
+		vm.pc += 2
 
-			case 0x00EE: //0x00EE returns from a subroutine
-				vm.pc = vm.stack[vm.sp] + 2
-				vm.sp--
-			}
-		
-	//First nibble is 0001
-	case 0x1000: //0x1NNN jumps to NNN on memory
+	case OpRET: //0x00EE returns from a subroutine
+		if vm.sp == 0 {
+			vm.fault("Stack underflow: 00EE at 0x%03X with no subroutine to return from", vm.pc)
+			break
+		}
+		vm.pc = vm.stack[vm.sp] + 2
+		vm.sp--
+
+	case OpSCD: //0x00CN scrolls the display down N pixels
+		vm.scrollDown(int(ins.N))
+		vm.pc += 2
+
+	case OpSCU: //0x00DN scrolls the display up N pixels
+		vm.scrollDown(-int(ins.N))
+		vm.pc += 2
+
+	case OpSCR: //0x00FB scrolls the display right by 4 pixels
+		vm.scrollSideways(4)
+		vm.pc += 2
+
+	case OpSCL: //0x00FC scrolls the display left by 4 pixels
+		vm.scrollSideways(-4)
+		vm.pc += 2
+
+	case OpEXIT: //0x00FD exits the interpreter
+		vm.exited = true
+
+	case OpLOW: //0x00FE switches to 64x32 lores mode
+		vm.setHires(false)
+		vm.pc += 2
+
+	case OpHIGH: //0x00FF switches to 128x64 hires mode
+		vm.setHires(true)
+		vm.pc += 2
+
+	case OpJP: //0x1NNN jumps to NNN on memory
 		//Program counter = last 3 nibbles of opcode
-		vm.pc = (vm.op & 0x0FFF)
+		vm.pc = ins.NNN
 
-	//First nibble is 0002
-	case 0x2000: //0x2nnn calls subroutine at nnn
+	case OpCALL: //0x2nnn calls subroutine at nnn
+		if int(vm.sp)+1 >= len(vm.stack) {
+			vm.fault("Stack overflow: 2%03X at 0x%03X is nested more than %d calls deep", ins.NNN, vm.pc, len(vm.stack)-1)
+			break
+		}
 		vm.sp++
-		vm.stack[vm.sp] = vm.pc 
-		vm.pc = (vm.op & 0x0FFF)
+		vm.stack[vm.sp] = vm.pc
+		vm.pc = ins.NNN
 
-	case 0x3000: //0x3XKK Skips next instruction if VX = KK
-		if uint16(vm.v[((vm.op & 0x0F00) >> 8)]) == uint16(vm.op & 0x00FF) {
-			vm.pc += 4
+	case OpSEByte: //0x3XKK Skips next instruction if VX = KK
+		if vm.v[x] == ins.KK {
+			vm.skipNext()
 		} else { //Otherwise does nothing
 			vm.pc += 2
 		}
 
-	case 0x4000: //0x4XKK skips next instruction if VX != KK
-		if uint16(vm.v[((vm.op & 0x0F00) >> 8)]) != uint16(vm.op & 0x00FF) {
-			vm.pc += 4
+	case OpSNEByte: //0x4XKK skips next instruction if VX != KK
+		if vm.v[x] != ins.KK {
+			vm.skipNext()
 		} else { //Otherwise does nothing
-			vm.pc += 2 
+			vm.pc += 2
 		}
 
-	case 0x5000: //0x5XY0 skips next instruction if VX = VY
-		if uint16(vm.v[(vm.op & 0x0F00) >> 8]) == uint16(vm.v[(vm.op & 0x00F0) >> 4]) {
-			vm.pc += 4
+	case OpSEReg: //0x5XY0 skips next instruction if VX = VY
+		if vm.v[x] == vm.v[y] {
+			vm.skipNext()
 		} else {
 			vm.pc += 2
 		}
 
-	case 0x6000: //0x6XNN sets value of VX to NN
-		vm.v[(vm.op & 0x0F00) >> 8] = byte(vm.op & 0x00FF)
+	case OpSaveRange: //0x5XY2 stores VX through VY in memory starting at I
+		vm.saveRange(x, y)
 		vm.pc += 2
 
-	case 0x7000: //0x7XNN adds value NN to value VX, doesn't affect carry
-		vm.v[(vm.op & 0x0F00) >> 8] += byte(vm.op & 0x00FF)
+	case OpLoadRange: //0x5XY3 reads VX through VY from memory starting at I
+		vm.loadRange(x, y)
 		vm.pc += 2
 
-	case 0x8000: //Arithmetic operations
-		switch vm.op & 0x000F { //Check the last nibble 
-			
-			case 0x0000: //0x8XY0 Sets VX to VY
-				vm.v[(vm.op & 0x0F00) >> 8] = vm.v[(vm.op & 0x00F0) >> 4]
-				vm.pc += 2
+	case OpLDByte: //0x6XNN sets value of VX to NN
+		vm.v[x] = ins.KK
+		vm.pc += 2
 
-			case 0x0001: //0x8XY1 Sets VX to VX OR VY
-				vm.v[(vm.op & 0x0F00) >> 8] |= vm.v[(vm.op & 0x00F0) >> 4]
-				vm.pc += 2
-			
-			case 0x0002: //0x8XY2 Sets VX to VX AND VY
-				vm.v[(vm.op & 0x0F00) >> 8] &= vm.v[(vm.op & 0x00F0) >> 4]
-				vm.pc += 2
+	case OpADDByte: //0x7XNN adds value NN to value VX, doesn't affect carry
+		vm.v[x] += ins.KK
+		vm.pc += 2
 
-			case 0x0003: //0x8XY3 Sets VX to VX XOR (exclusive OR) VY
-				vm.v[(vm.op & 0x0F00) >> 8] ^= vm.v[(vm.op & 0x00F0) >> 4]
-				vm.pc += 2
+	//Arithmetic operations
+	case OpLDReg: //0x8XY0 Sets VX to VY
+		vm.v[x] = vm.v[y]
+		vm.pc += 2
 
-			case 0x0004: //0x8XY4 Adds VY to VX, sets VF to 1 if result overflows
-				if vm.v[(vm.op & 0x00F0) >> 4] > 0xFF - vm.v[(vm.op & 0x0F00) >> 8] {
-					vm.v[0xF] = 1 //Set VF to 1
-				} else {
-					vm.v[0xF] = 0
-				}
-				(vm.v[(vm.op & 0x0F00) >> 8]) += (vm.v[(vm.op & 0x00F0) >> 4]) //Otherwise, add VX and VY
-				vm.pc += 2
-			
-			case 0x0005: //0x8XY5 Sets VX to VX - VY, sets VF to 1 if a borrow occurs
-				if vm.v[(vm.op & 0x00F0) >> 4] > vm.v[(vm.op & 0x0F00) >> 8] {
-					vm.v[0xF] = 0
-				} else {
-					vm.v[0xF] = 1
-				}
-				vm.v[(vm.op & 0x0F00) >> 8] -= vm.v[(vm.op & 0x00F0) >> 4]
-				vm.pc += 2
+	case OpOR: //0x8XY1 Sets VX to VX OR VY
+		vm.v[x] |= vm.v[y]
+		vm.logicResetVF()
+		vm.pc += 2
 
-			case 0x0006: //0x8XY6 Sets VX to VY right shifted by 1, setting VF to the bit lost in the shift
-				vm.v[(vm.op & 0x0F00) >> 8] = ((vm.v[(vm.op & 0x00F0) >> 4]) >> 1)
-				vm.v[0xF] = (vm.v[vm.op & 0x00F0 >> 4] & 0x01)
-				vm.pc += 2
+	case OpAND: //0x8XY2 Sets VX to VX AND VY
+		vm.v[x] &= vm.v[y]
+		vm.logicResetVF()
+		vm.pc += 2
 
-			case 0x0007: //0x8XY7 Sets VX to VY - VX, and sets VF to 1 if VY > VX
-				if vm.v[(vm.op & 0x0F00) >> 8] > vm.v[(vm.op & 0x00F0) >> 4] {
-					vm.v[0xF] = 0
-				} else {
-					vm.v[0xF] = 1
-				} 
-				vm.v[(vm.op & 0x0F00) >> 8] = ((vm.v[(vm.op & 0x00F0) >> 4]) - (vm.v[(vm.op & 0x0F00) >> 8]))
-				vm.pc += 2
+	case OpXOR: //0x8XY3 Sets VX to VX XOR (exclusive OR) VY
+		vm.v[x] ^= vm.v[y]
+		vm.logicResetVF()
+		vm.pc += 2
 
-			case 0x000E: //0x8XYE sets VX to VX bit-shifted left by 1, sets VF to 1 if the MSB of VX is 1
-				vm.v[(vm.op & 0x0F00) >> 8] = ((vm.v[(vm.op & 0x00F0) >> 4]) << 1)
-				vm.v[0xf] = (vm.v[(vm.op & 0x00F0) >> 4] & 0x80)
-				vm.pc += 2
+	//VF is set after the result in 8XY4, 8XY5 and 8XY7, so that the flag
+	//wins when VF is also the destination. Octo's comparisons rely on it
+	case OpADDReg: //0x8XY4 Adds VY to VX, sets VF to 1 if result overflows
+		carry := byte(0)
+		if vm.v[y] > 0xFF - vm.v[x] {
+			carry = 1
+		}
+		vm.v[x] += vm.v[y]
+		vm.v[0xF] = carry
+		vm.pc += 2
+
+	case OpSUB: //0x8XY5 Sets VX to VX - VY, sets VF to 0 if a borrow occurs
+		noBorrow := byte(1)
+		if vm.v[y] > vm.v[x] {
+			noBorrow = 0
+		}
+		vm.v[x] -= vm.v[y]
+		vm.v[0xF] = noBorrow
+		vm.pc += 2
+
+	case OpSHR: //0x8XY6 Sets VX to VY right shifted by 1, setting VF to the bit lost in the shift
+		shifted := vm.shiftSource()
+		vm.v[x] = shifted >> 1
+		vm.v[0xF] = shifted & 0x01
+		vm.pc += 2
 
-				//if (vm.v[(vm.op & 0x0F00) >> 8] & 0xF000) == 1 {
-				//	vm.v[0xf] = 1
-				//} else {
-				//	vm.v[0xf] = 0
-				//}
-				//vm.v[(vm.op & 0x0F00) >> 8] = (vm.v[(vm.op & 0x0F00) >> 8] << 1)
-				//vm.pc += 2
+	case OpSUBN: //0x8XY7 Sets VX to VY - VX, and sets VF to 1 if VY >= VX
+		noBorrow := byte(1)
+		if vm.v[x] > vm.v[y] {
+			noBorrow = 0
 		}
+		vm.v[x] = vm.v[y] - vm.v[x]
+		vm.v[0xF] = noBorrow
+		vm.pc += 2
 
-	case 0x9000: //0x9XY0 skips the next instruction if VX is not equal to VY
-		if vm.v[(vm.op & 0x0F00) >> 8] != vm.v[(vm.op & 0x00F0) >> 4] {
-			vm.pc += 4
+	case OpSHL: //0x8XYE sets VX to VY bit-shifted left by 1, sets VF to 1 if the MSB of VY is 1
+		shifted := vm.shiftSource()
+		vm.v[x] = shifted << 1
+		vm.v[0xf] = shifted >> 7
+		vm.pc += 2
+
+	case OpSNEReg: //0x9XY0 skips the next instruction if VX is not equal to VY
+		if vm.v[x] != vm.v[y] {
+			vm.skipNext()
 		} else {
 			vm.pc += 2
 		}
 
-	case 0xA000: //0xANNN Sets I to address NNN
-		vm.I = (vm.op & 0x0FFF)
+	case OpLDI: //0xANNN Sets I to address NNN
+		vm.index = ins.NNN
 		vm.pc += 2
 
-	case 0xB000: //0xBNNN jumps to address NNN + V0
-		vm.pc = ((vm.op & 0x0FFF) + uint16(vm.v[0]))
-		vm.pc += 2
+	case OpJPV0: //0xBNNN jumps to address NNN + V0
+		offset := vm.v[0]
+		if vm.quirks.JumpVX { //BXNN jumps to XNN + VX instead
+			offset = vm.v[x]
+		}
+		vm.pc = ins.NNN + uint16(offset)
 
-	case 0xC000: //0xCXKK sets VX to a random byte AND KK
-		vm.v[(vm.op & 0x0F00) >> 8] = byte(rand.Float32()*255) & byte(vm.op & 0x00FF)
+	case OpRND: //0xCXKK sets VX to a random byte AND KK
+		vm.v[x] = vm.rng.Byte() & ins.KK
 		vm.pc += 2
 
-	case 0xD000: //0xDXYN Draws sprite of length N in memory starting at I at co-ords (VX, VY)
-		x := uint16(vm.v[(vm.op & 0x0F00) >> 8])
-		y := uint16(vm.v[(vm.op & 0x00F0) >> 4])
-		vm.drawSprite(x, y)
+	case OpDRW: //0xDXYN Draws sprite of length N in memory starting at I at co-ords (VX, VY)
+		vm.drawSprite(uint16(vm.v[x]), uint16(vm.v[y]))
 		vm.pc += 2
 
-	case 0xE000:
-		switch vm.op & 0x00FF {
-			case 0x009E: //0xE09E Skips next instruction if the key with value VX is pressed
-				if vm.key[vm.v[(vm.op & 0x0F00) >> 8]] == 1 {
-					vm.pc += 4
-					vm.key[vm.v[(vm.op & 0x0F00) >> 8]] = 0
-				} else {
-					vm.pc += 2
-				}
+	case OpSKP: //0xEX9E Skips next instruction if the key with value VX is pressed
+		//Only the low nibble of VX picks a key, like on the VIP
+		if vm.key[vm.v[x]&0xF] == 1 {
+			vm.skipNext()
+			vm.key[vm.v[x]&0xF] = 0
+		} else {
+			vm.pc += 2
+		}
 
-			case 0x00A1: //0xE0A1 Skips next instructions if the key with value VX is NOT pressed
-				if vm.key[vm.v[(vm.op & 0x0F00) >> 8]] == 0 {
-					vm.pc += 4
-				} else {
-					vm.key[vm.v[(vm.op & 0x0F00) >> 8]] = 0
-					vm.pc += 2
-				}
-		}	
+	case OpSKNP: //0xEXA1 Skips next instructions if the key with value VX is NOT pressed
+		if vm.key[vm.v[x]&0xF] == 0 {
+			vm.skipNext()
+		} else {
+			vm.key[vm.v[x]&0xF] = 0
+			vm.pc += 2
+		}
 
-	case 0xF000: //Memory and register management
-		switch vm.op & 0x00FF {
+	//Memory and register management
+	case OpLDVxDT: //0xFX07 sets VX to the value of the delay timer
+		vm.v[x] = vm.delayTime
+		vm.pc += 2
 
-			case 0x0007: //0xFX07 sets VX to the value of the delay timer
-				vm.v[(vm.op & 0x0F00) >> 8] = vm.delayTime
+	case OpLDVxK: //0xFX0A waits for a key press, then stores key value in VX
+		for i, k := range vm.key {
+			if k != 0 {
+				vm.v[x] = byte(i)
 				vm.pc += 2
+				break
+			}
+		}
+		//Causing strange crashes during waiting
+		//vm.key[vm.v[(vm.op & 0x0F00) >> 8]] = 0 Causing strange crashes during waiting
 
-			case 0x000A: //0xFX0A waits for a key press, then stores key value in VX
-				for i, k := range vm.key {
-					if k != 0 {
-						vm.v[(vm.op & 0x0F00) >> 8] = byte(i)
-						vm.pc += 2
-						break
-					}
-				}
-				//Causing strange crashes during waiting
-				//vm.key[vm.v[(vm.op & 0x0F00) >> 8]] = 0 Causing strange crashes during waiting
+	case OpLDDTVx: //0xFX15 sets delay timer to VX
+		vm.delayTime = vm.v[x]
+		vm.pc += 2
 
-			case 0x0015: //0xFX15 sets delay timer to VX
-				vm.delayTime = vm.v[(vm.op & 0x0F00) >> 8]
-				vm.pc += 2
+	case OpLDSTVx: //0xFX18 sets sound timer to VX
+		vm.soundTime = vm.v[x]
+		vm.updateSound()
+		vm.pc += 2
 
-			case 0x0018: //0xFX18 sets sound timer to VX
-				vm.soundTime = vm.v[(vm.op & 0x0F00) >> 8]
-				vm.pc += 2
+	case OpADDI: //0xFX1E sets I to I + VX
+		vm.index += uint16(vm.v[x])
+		vm.pc += 2
 
-			case 0x001E: //0xFX1E sets I to I + VX
-				vm.I += uint16(vm.v[(vm.op & 0x0F00) >> 8])
-				vm.pc += 2
+	case OpLDF: //0xFX29 sets I to the location for the font sprite corresponding to VX
+		vm.index = uint16(vm.v[x]) * 5
+		vm.pc += 2
 
-			case 0x0029: //0xFX29 sets I to the location for the font sprite corresponding to VX
-				vm.I = uint16(vm.v[(vm.op & 0x0F00) >> 8]) * 5 
-				vm.pc += 2
+	case OpLDILong: //0xF000 NNNN sets I to the 16 bit address NNNN
+		vm.index = ins.NNN
+		vm.pc += 4
 
-			case 0x0033: //0xFX33 Stores the binary of VX in memory locations I, I+1 and I+2
-				vm.mem[vm.I] = (vm.v[(vm.op & 0x0F00) >> 8]) / 100
-				vm.mem[vm.I+1] = (vm.v[(vm.op & 0x0F00) >> 8] / 10) % 10
-				vm.mem[vm.I+2] = (vm.v[(vm.op & 0x0F00) >> 8] % 100) % 10
-				vm.pc += 2
-				//Very complicated, needed help!
+	case OpPLANE: //0xFN01 selects the bitplanes N to draw on
+		vm.selectPlanes(ins.X)
+		vm.pc += 2
 
-			case 0x0055: //0xFX55 stores registers V0 -> VX in memory starting at I
-				for i := uint16(0); i <= ((vm.op & 0x0F00) >> 8); i++ {
-					vm.mem[vm.I+i] = vm.v[i]
-				}
-				vm.pc += 2
+	case OpAUDIO: //0xF002 loads the 16 byte audio pattern at I
+		vm.loadPattern()
+		vm.pc += 2
 
-			case 0x0065: //0xFX65 READS registers V0 through VX FROM memory starting at I
-				for i := uint16(0); i <= ((vm.op & 0x0F00) >> 8); i++ {
-					vm.v[i] = vm.mem[vm.I+i]
-				}
-				vm.pc += 2
-			}
+	case OpPITCH: //0xFX3A sets the audio pattern's pitch to VX
+		vm.setPitch(vm.v[x])
+		vm.pc += 2
+
+	case OpLDHF: //0xFX30 sets I to the location of the big font sprite for VX
+		vm.index = bigFontStart + uint16(vm.v[x] & 0x0F) * 10
+		vm.pc += 2
+
+	case OpSaveFlags: //0xFX75 stores V0 through VX in the RPL user flags
+		vm.saveFlags(x)
+		vm.pc += 2
+
+	case OpLoadFlags: //0xFX85 reads V0 through VX from the RPL user flags
+		vm.loadFlags(x)
+		vm.pc += 2
+
+	case OpLDB: //0xFX33 Stores the binary of VX in memory locations I, I+1 and I+2
+		vm.writeMem(vm.index, vm.v[x] / 100)
+		vm.writeMem(vm.index+1, (vm.v[x] / 10) % 10)
+		vm.writeMem(vm.index+2, (vm.v[x] % 100) % 10)
+		vm.pc += 2
+		//Very complicated, needed help!
+
+	case OpStore: //0xFX55 stores registers V0 -> VX in memory starting at I
+		for i := uint16(0); i <= x; i++ {
+			vm.writeMem(vm.index+i, vm.v[i])
+		}
+		vm.loadStoreIncrement()
+		vm.pc += 2
+
+	case OpLoad: //0xFX65 READS registers V0 through VX FROM memory starting at I
+		for i := uint16(0); i <= x; i++ {
+			vm.v[i] = vm.readMem(vm.index+i)
+		}
+		vm.loadStoreIncrement()
+		vm.pc += 2
 
 	default:
 		fmt.Printf("Invalid opcode 0x%X\n", vm.op)
@@ -628,7 +1062,7 @@ func (vm *chip8) FDE() {
 	}
 }
 
-func (vm *chip8) consoleDebug() {
+func (vm *Machine) consoleDebug() {
 	fmt.Printf(`
 opcode: %x
 pc: %d
@@ -651,7 +1085,7 @@ VC: %d
 VD: %d
 VE: %d
 VF: %d`,
-		vm.op, vm.pc, vm.sp, vm.I, vm.v[0],
+		vm.op, vm.pc, vm.sp, vm.index, vm.v[0],
 		vm.v[1], vm.v[2], vm.v[3], vm.v[4],
 		vm.v[5], vm.v[6], vm.v[7], vm.v[8],
 		vm.v[9], vm.v[10], vm.v[11], vm.v[12],
@@ -0,0 +1,88 @@
+package chip8
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+//Makes a headless machine with a fixed seed, running rom
+func newTestMachine(t *testing.T, rom []byte, opts ...Option) *Machine {
+	t.Helper()
+	opts = append([]Option{WithRNG(NewRandRNG(1))}, opts...)
+	vm := NewMachine(opts...)
+	if err := vm.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func readTestROM(t *testing.T, name string) []byte {
+	t.Helper()
+	rom, err := os.ReadFile("../TestPrograms/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rom
+}
+
+func TestStackFaults(t *testing.T) {
+	tests := []struct {
+		name string
+		rom  []byte
+		err  string
+	}{
+		//RET with nothing to return to
+		{"underflow", []byte{0x00, 0xEE}, "Stack underflow: 00EE at 0x200"},
+
+		//A subroutine that calls itself forever
+		{"overflow", []byte{0x22, 0x00}, "Stack overflow: 2200 at 0x200 is nested more than 15 calls deep"},
+	}
+	for _, test := range tests {
+		vm := newTestMachine(t, test.rom)
+		vm.RunUntil(100)
+		if !vm.Exited() {
+			t.Errorf("%s: machine still running after 100 cycles", test.name)
+		}
+		if err := vm.Err(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Err() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestKeyIndexMasked(t *testing.T) {
+	//V0 := 0x15, then SKP V0 checks key 5 rather than indexing past the
+	//keypad. The skipped instruction would jump back to the start
+	rom := []byte{0x60, 0x15, 0xE0, 0x9E, 0x12, 0x00, 0x12, 0x06}
+	vm := newTestMachine(t, rom)
+	vm.Key(0x25, true)
+	if keys := vm.Keys(); keys[5] != 1 {
+		t.Fatalf("Key(0x25) set keys %v, want key 5", keys)
+	}
+	vm.RunUntil(3)
+	if pc := vm.Registers().PC; pc != 0x206 {
+		t.Errorf("PC = 0x%03X after SKP with key 5 down, want 0x206", pc)
+	}
+}
+
+func TestLoadROMSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		quirks Quirks
+		size   int
+		ok     bool
+	}{
+		{"vip fills 4K", QuirksCOSMACVIP, 0x1000 - 0x200, true},
+		{"vip past 4K", QuirksCOSMACVIP, 0x1000 - 0x200 + 1, false},
+		{"schip past 4K", QuirksSuperChip, 0x1000 - 0x200 + 1, false},
+		{"xochip past 4K", QuirksXOChip, 0x1000, true},
+		{"xochip fills 64K", QuirksXOChip, 0x10000 - 0x200, true},
+		{"xochip past 64K", QuirksXOChip, 0x10000 - 0x200 + 1, false},
+	}
+	for _, test := range tests {
+		vm := NewMachine(WithQuirks(test.quirks))
+		if err := vm.LoadROM(make([]byte, test.size)); (err == nil) != test.ok {
+			t.Errorf("%s: LoadROM of %d bytes = %v, want ok %v", test.name, test.size, err, test.ok)
+		}
+	}
+}
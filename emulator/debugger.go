@@ -0,0 +1,777 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//A Debugger attaches to a Machine and controls it from a command line,
+//in the style of gdb. Commands are run with Exec, or read one per line by
+//Serve. Execution happens inside Run, so the display keeps updating while
+//stepping and the machine can be paused at any time
+type Debugger struct {
+	vm *Machine
+
+	//Breakpoints and watchpoints by ID
+	breakpoints map[int]*Breakpoint
+	nextID      int
+
+	//Read and write watchpoints triggered by the current instruction,
+	//checked once it's finished
+	pending []watchHit
+
+	//Checked after every instruction while stepping, returns true once
+	//the step is over
+	until       func() bool
+	untilReason string
+
+	//Set when carrying on so we don't stop at the breakpoint we're on
+	skipBreak bool
+
+	//Why Run stopped, picked up by Serve
+	stops chan string
+
+	//Last command, repeated when an empty line is entered
+	lastLine string
+
+	out io.Writer
+}
+
+//What makes a Breakpoint trigger
+type BreakKind int
+
+const (
+	//About to execute the instruction at Addr
+	BreakExecute BreakKind = iota
+
+	//About to execute an instruction anywhere from Addr to End
+	WatchExecute
+
+	//An instruction read memory between Addr and End
+	WatchRead
+
+	//An instruction wrote memory between Addr and End
+	WatchWrite
+
+	//An instruction read or wrote memory between Addr and End
+	WatchAccess
+)
+
+var breakKindNames = map[BreakKind]string{
+	BreakExecute: "break",
+	WatchExecute: "xwatch",
+	WatchRead:    "rwatch",
+	WatchWrite:   "watch",
+	WatchAccess:  "awatch",
+}
+
+//A breakpoint or watchpoint. Execution stops before the instruction for
+//breakpoints and execute watchpoints, and after it for memory watchpoints
+type Breakpoint struct {
+	ID   int
+	Kind BreakKind
+
+	//Addresses covered, inclusive. End is Addr for a single address
+	Addr uint16
+	End  uint16
+
+	//Deleted the first time it's hit (tbreak)
+	Temporary bool
+
+	//Only stops when this is non-zero, if set
+	Condition *Expr
+
+	//Number of times it has triggered with its condition true
+	Hits int
+
+	//Number of upcoming hits to carry on through
+	Ignore int
+}
+
+func (bp *Breakpoint) covers(addr uint16) bool {
+	return addr >= bp.Addr && addr <= bp.End
+}
+
+func (bp *Breakpoint) String() string {
+	where := fmt.Sprintf("0x%04X", bp.Addr)
+	if bp.End != bp.Addr {
+		where += fmt.Sprintf("-0x%04X", bp.End)
+	}
+	kind := breakKindNames[bp.Kind]
+	if bp.Temporary {
+		kind = "tbreak"
+	}
+	desc := fmt.Sprintf("%-3d %-6s %-13s hits %d", bp.ID, kind, where, bp.Hits)
+	if bp.Ignore > 0 {
+		desc += fmt.Sprintf(", ignoring next %d", bp.Ignore)
+	}
+	if bp.Condition != nil {
+		desc += " if " + bp.Condition.String()
+	}
+	return desc
+}
+
+//A memory access that matched a watchpoint
+type watchHit struct {
+	bp    *Breakpoint
+	write bool
+	addr  uint16
+	old   byte
+	new   byte
+}
+
+//Attaches a new debugger to vm. Output from commands goes to out
+func NewDebugger(vm *Machine, out io.Writer) *Debugger {
+	d := &Debugger{
+		vm:          vm,
+		breakpoints: map[int]*Breakpoint{},
+		nextID:      1,
+		stops:       make(chan string, 8),
+		out:         out,
+	}
+
+	vm.mu.Lock()
+	vm.debugger = d
+	vm.mu.Unlock()
+	return d
+}
+
+//Called by Run before every instruction. Reports whether to stop first
+func (d *Debugger) breakBefore() bool {
+	if d.skipBreak {
+		d.skipBreak = false
+		return false
+	}
+
+	for _, id := range d.sortedIDs() {
+		bp := d.breakpoints[id]
+		if bp.Kind != BreakExecute && bp.Kind != WatchExecute || !bp.covers(d.vm.pc) || !d.hit(bp) {
+			continue
+		}
+		if bp.Temporary {
+			delete(d.breakpoints, bp.ID)
+			d.until = nil
+			d.stopped(fmt.Sprintf("Temporary breakpoint %d", bp.ID))
+			return true
+		}
+		d.until = nil
+		if bp.Kind == WatchExecute {
+			d.stopped(fmt.Sprintf("Watchpoint %d: execute 0x%04X", bp.ID, d.vm.pc))
+		} else {
+			d.stopped(fmt.Sprintf("Breakpoint %d", bp.ID))
+		}
+		return true
+	}
+	return false
+}
+
+//Called by Run after every instruction. Reports whether to stop now
+func (d *Debugger) breakAfter() bool {
+	pending := d.pending
+	d.pending = nil
+	seen := map[*Breakpoint]bool{}
+	for _, wh := range pending {
+		//An instruction touching several bytes in range only counts once
+		if seen[wh.bp] {
+			continue
+		}
+		seen[wh.bp] = true
+		if !d.hit(wh.bp) {
+			continue
+		}
+		d.until = nil
+		if wh.write {
+			d.stopped(fmt.Sprintf("Watchpoint %d: write 0x%04X 0x%02X -> 0x%02X", wh.bp.ID, wh.addr, wh.old, wh.new))
+		} else {
+			d.stopped(fmt.Sprintf("Watchpoint %d: read 0x%04X = 0x%02X", wh.bp.ID, wh.addr, wh.old))
+		}
+		return true
+	}
+
+	if d.until == nil || !d.until() {
+		return false
+	}
+	d.until = nil
+	d.stopped(d.untilReason)
+	return true
+}
+
+//Counts a trigger of bp, reporting whether to stop for it
+func (d *Debugger) hit(bp *Breakpoint) bool {
+	if bp.Condition != nil && bp.Condition.Eval(d.vm) == 0 {
+		return false
+	}
+	bp.Hits++
+	if bp.Ignore > 0 {
+		bp.Ignore--
+		return false
+	}
+	return true
+}
+
+//Called by the machine for every memory read and write an instruction
+//makes. Matching watchpoints are checked once the instruction is done
+func (d *Debugger) memAccess(addr uint16, write bool, old, new byte) {
+	for _, bp := range d.breakpoints {
+		if !bp.covers(addr) {
+			continue
+		}
+		if bp.Kind == WatchAccess || bp.Kind == WatchWrite && write || bp.Kind == WatchRead && !write {
+			d.pending = append(d.pending, watchHit{bp: bp, write: write, addr: addr, old: old, new: new})
+		}
+	}
+}
+
+func (d *Debugger) sortedIDs() []int {
+	ids := make([]int, 0, len(d.breakpoints))
+	for id := range d.breakpoints {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (d *Debugger) stopped(reason string) {
+	select {
+	case d.stops <- reason:
+	default:
+	}
+}
+
+//Pauses the machine wherever it is, as if the pause command was run.
+//Meant for signal handlers such as Ctrl-C
+func (d *Debugger) Interrupt() {
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+	if !d.vm.paused {
+		d.vm.paused = true
+		d.until = nil
+		d.stopped("Interrupted")
+	}
+}
+
+//Reads commands from in, one per line, until it runs out, quit is entered
+//or the machine shuts down
+func (d *Debugger) Serve(in io.Reader) {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	d.prompt()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if quit := d.Exec(line); quit {
+				return
+			}
+			d.prompt()
+		case reason := <-d.stops:
+			fmt.Fprintf(d.out, "\n%s at %s\n", reason, d.location())
+			d.prompt()
+		case <-d.vm.ShutdownChan:
+			return
+		}
+	}
+}
+
+func (d *Debugger) prompt() {
+	fmt.Fprint(d.out, "(chip8) ")
+}
+
+//Describes the instruction the machine is about to execute
+func (d *Debugger) location() string {
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+	pc := d.vm.pc
+	ins := decodeAt(d.vm.mem[:], pc)
+	return fmt.Sprintf("0x%04X: %04X  %s", pc, ins.Raw, ins)
+}
+
+//Runs a single command. Returns true if it was quit
+func (d *Debugger) Exec(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = d.lastLine
+	}
+	d.lastLine = line
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	cmd, args := fields[0], fields[1:]
+
+	var err error
+	switch cmd {
+	case "break", "b":
+		err = d.cmdBreak(args, BreakExecute, false)
+	case "tbreak":
+		err = d.cmdBreak(args, BreakExecute, true)
+	case "watch":
+		err = d.cmdBreak(args, WatchWrite, false)
+	case "rwatch":
+		err = d.cmdBreak(args, WatchRead, false)
+	case "awatch":
+		err = d.cmdBreak(args, WatchAccess, false)
+	case "xwatch":
+		err = d.cmdBreak(args, WatchExecute, false)
+	case "condition":
+		err = d.cmdCondition(args)
+	case "ignore":
+		err = d.cmdIgnore(args)
+	case "delete", "d":
+		err = d.cmdDelete(args)
+	case "info", "i":
+		d.cmdInfo()
+	case "step", "s":
+		err = d.cmdStep(args)
+	case "next", "n":
+		err = d.cmdNext()
+	case "finish":
+		err = d.cmdFinish()
+	case "continue", "c":
+		err = d.resume(nil, "")
+	case "pause":
+		d.Interrupt()
+	case "print", "p":
+		err = d.cmdPrint(args)
+	case "examine", "x":
+		err = d.cmdExamine(args)
+	case "set":
+		err = d.cmdSet(args)
+	case "help", "h":
+		fmt.Fprint(d.out, debuggerHelp)
+	case "quit", "q":
+		d.vm.Stop()
+		return true
+	default:
+		err = fmt.Errorf("Unknown command %q. Try \"help\"", cmd)
+	}
+
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+	}
+	return false
+}
+
+const debuggerHelp = `Commands:
+  break ADDR [if EXPR]  stop before executing the instruction at ADDR (b)
+  tbreak ADDR [if EXPR] like break, but deleted once it's hit
+  watch RANGE [if EXPR] stop after an instruction writes to RANGE
+  rwatch RANGE [if EXPR] stop after an instruction reads from RANGE
+  awatch RANGE [if EXPR] stop after an instruction reads or writes RANGE
+  xwatch RANGE [if EXPR] stop before executing an instruction in RANGE
+  condition ID [EXPR] only stop at ID when EXPR is non-zero, or always
+  ignore ID COUNT     carry on through the next COUNT hits of ID
+  delete [ID...]      delete breakpoints, or all of them (d)
+  info                list breakpoints and their hit counts (i)
+  step [N]            execute N instructions, 1 by default (s)
+  next                like step, but runs called subroutines to completion (n)
+  finish              run until the current subroutine returns
+  continue            carry on running (c)
+  pause               stop running, Ctrl-C does the same
+  print registers     show V0-VF, I, PC, SP and the timers (p r)
+  print stack         show the subroutine call stack (p s)
+  print EXPR          evaluate an expression
+  examine ADDR [LEN]  show LEN bytes of memory from ADDR, 16 by default (x)
+  set REG VALUE       set V0-VF, I, PC, SP, DT or ST
+  set mem ADDR VALUE... write bytes to memory starting at ADDR
+  quit                stop the machine and exit (q)
+Numbers can be decimal or hex with a 0x prefix. A RANGE is an address,
+START-END or START+LENGTH. Expressions use C operators over v0-vf, i, pc,
+sp, dt, st and mem[ADDR], e.g. "v3 == 0x10 && mem[i] > 4". An empty line
+repeats the last command.
+`
+
+//Parses a decimal or 0x prefixed hex number that fits in bits
+func parseNumber(s string, bits int) (uint64, error) {
+	digits, base := s, 10
+	if strings.HasPrefix(strings.ToLower(s), "0x") {
+		digits, base = s[2:], 16
+	}
+	n, err := strconv.ParseUint(digits, base, bits)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number %q", s)
+	}
+	return n, nil
+}
+
+//Parses ADDR, START-END or START+LENGTH
+func parseRange(s string) (uint16, uint16, error) {
+	if i := strings.IndexAny(s, "-+"); i > 0 {
+		start, err := parseNumber(s[:i], 16)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := parseNumber(s[i+1:], 16)
+		if err != nil {
+			return 0, 0, err
+		}
+		end := n
+		if s[i] == '+' {
+			if n == 0 {
+				return 0, 0, fmt.Errorf("Range %q is empty", s)
+			}
+			end = start + n - 1
+		}
+		if end < start || end > 0xFFFF {
+			return 0, 0, fmt.Errorf("Invalid range %q", s)
+		}
+		return uint16(start), uint16(end), nil
+	}
+
+	addr, err := parseNumber(s, 16)
+	return uint16(addr), uint16(addr), err
+}
+
+//Splits "... if EXPR" into the words before the if and the parsed EXPR
+func splitCondition(args []string) ([]string, *Expr, error) {
+	for i, arg := range args {
+		if arg != "if" {
+			continue
+		}
+		cond, err := ParseExpr(strings.Join(args[i+1:], " "))
+		if err != nil {
+			return nil, nil, err
+		}
+		return args[:i], cond, nil
+	}
+	return args, nil, nil
+}
+
+func (d *Debugger) cmdBreak(args []string, kind BreakKind, temporary bool) error {
+	args, cond, err := splitCondition(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: %s ADDR [if EXPR]", breakKindNames[kind])
+	}
+
+	var start, end uint16
+	if kind == BreakExecute {
+		addr, err := parseNumber(args[0], 16)
+		if err != nil {
+			return err
+		}
+		start, end = uint16(addr), uint16(addr)
+	} else if start, end, err = parseRange(args[0]); err != nil {
+		return err
+	}
+
+	d.vm.mu.Lock()
+	bp := &Breakpoint{
+		ID:        d.nextID,
+		Kind:      kind,
+		Addr:      start,
+		End:       end,
+		Temporary: temporary,
+		Condition: cond,
+	}
+	d.breakpoints[bp.ID] = bp
+	d.nextID++
+	d.vm.mu.Unlock()
+
+	if kind == BreakExecute {
+		fmt.Fprintf(d.out, "Breakpoint %d at 0x%04X\n", bp.ID, bp.Addr)
+	} else {
+		fmt.Fprintf(d.out, "Watchpoint %s\n", bp)
+	}
+	return nil
+}
+
+//Looks up a breakpoint from its ID. The caller must hold vm.mu
+func (d *Debugger) breakpoint(arg string) (*Breakpoint, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid breakpoint number %q", arg)
+	}
+	bp, ok := d.breakpoints[id]
+	if !ok {
+		return nil, fmt.Errorf("No breakpoint number %d", id)
+	}
+	return bp, nil
+}
+
+func (d *Debugger) cmdCondition(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Usage: condition ID [EXPR]")
+	}
+	var cond *Expr
+	if len(args) > 1 {
+		var err error
+		if cond, err = ParseExpr(strings.Join(args[1:], " ")); err != nil {
+			return err
+		}
+	}
+
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+	bp, err := d.breakpoint(args[0])
+	if err != nil {
+		return err
+	}
+	bp.Condition = cond
+	return nil
+}
+
+func (d *Debugger) cmdIgnore(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Usage: ignore ID COUNT")
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 0 {
+		return fmt.Errorf("Invalid count %q", args[1])
+	}
+
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+	bp, err := d.breakpoint(args[0])
+	if err != nil {
+		return err
+	}
+	bp.Ignore = count
+	return nil
+}
+
+func (d *Debugger) cmdDelete(args []string) error {
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+
+	if len(args) == 0 {
+		d.breakpoints = map[int]*Breakpoint{}
+		return nil
+	}
+	for _, arg := range args {
+		bp, err := d.breakpoint(arg)
+		if err != nil {
+			return err
+		}
+		delete(d.breakpoints, bp.ID)
+	}
+	return nil
+}
+
+func (d *Debugger) cmdInfo() {
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.out, "No breakpoints")
+		return
+	}
+	for _, id := range d.sortedIDs() {
+		fmt.Fprintln(d.out, d.breakpoints[id])
+	}
+}
+
+//Lets Run carry on until until returns true, a breakpoint is hit or the
+//machine is paused
+func (d *Debugger) resume(until func() bool, reason string) error {
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+	if !d.vm.paused {
+		return fmt.Errorf("The machine is already running")
+	}
+	d.until = until
+	d.untilReason = reason
+	d.skipBreak = true
+	d.vm.paused = false
+	return nil
+}
+
+func (d *Debugger) cmdStep(args []string) error {
+	count := uint64(1)
+	if len(args) > 0 {
+		n, err := parseNumber(args[0], 32)
+		if err != nil {
+			return err
+		}
+		count = n
+	}
+	if count == 0 {
+		return fmt.Errorf("Can't step 0 instructions")
+	}
+	return d.resume(func() bool {
+		count--
+		return count == 0
+	}, "Stepped")
+}
+
+func (d *Debugger) cmdNext() error {
+	d.vm.mu.Lock()
+	pc, sp := d.vm.pc, d.vm.sp
+	call := d.vm.mem[pc]&0xF0 == 0x20
+	d.vm.mu.Unlock()
+
+	if !call {
+		return d.cmdStep(nil)
+	}
+	return d.resume(func() bool {
+		return d.vm.pc == pc+2 && d.vm.sp == sp
+	}, "Stepped over call")
+}
+
+func (d *Debugger) cmdFinish() error {
+	d.vm.mu.Lock()
+	sp := d.vm.sp
+	d.vm.mu.Unlock()
+
+	if sp == 0 {
+		return fmt.Errorf("Not in a subroutine")
+	}
+	return d.resume(func() bool {
+		return d.vm.sp < sp
+	}, "Returned")
+}
+
+func (d *Debugger) cmdPrint(args []string) error {
+	what := "registers"
+	if len(args) > 0 {
+		what = args[0]
+	}
+
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+	vm := d.vm
+
+	switch what {
+	case "registers", "regs", "r":
+		fmt.Fprintf(d.out, "PC: 0x%04X  I: 0x%04X  SP: %d  DT: %d  ST: %d\n",
+			vm.pc, vm.index, vm.sp, vm.delayTime, vm.soundTime)
+		for i := 0; i < 16; i++ {
+			fmt.Fprintf(d.out, "V%X: 0x%02X", i, vm.v[i])
+			if i%4 == 3 {
+				fmt.Fprintln(d.out)
+			} else {
+				fmt.Fprint(d.out, "  ")
+			}
+		}
+	case "stack", "s":
+		if vm.sp == 0 {
+			fmt.Fprintln(d.out, "Stack is empty")
+		}
+		for i := vm.sp; i > 0 && int(i) < len(vm.stack); i-- {
+			fmt.Fprintf(d.out, "#%d  return to 0x%04X\n", vm.sp-i, vm.stack[i]+2)
+		}
+	default:
+		expr, err := ParseExpr(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		n := expr.Eval(vm)
+		fmt.Fprintf(d.out, "%d (0x%X)\n", n, n)
+	}
+	return nil
+}
+
+func (d *Debugger) cmdExamine(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("Usage: examine ADDR [LEN]")
+	}
+	addr, err := parseNumber(args[0], 16)
+	if err != nil {
+		return err
+	}
+	length := uint64(16)
+	if len(args) == 2 {
+		if length, err = parseNumber(args[1], 16); err != nil {
+			return err
+		}
+	}
+
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+	for i := uint64(0); i < length; i++ {
+		a := uint16(addr + i)
+		if i%16 == 0 {
+			if i > 0 {
+				fmt.Fprintln(d.out)
+			}
+			fmt.Fprintf(d.out, "0x%04X:", a)
+		}
+		fmt.Fprintf(d.out, " %02X", d.vm.mem[a])
+	}
+	fmt.Fprintln(d.out)
+	return nil
+}
+
+func (d *Debugger) cmdSet(args []string) error {
+	if len(args) >= 3 && args[0] == "mem" {
+		addr, err := parseNumber(args[1], 16)
+		if err != nil {
+			return err
+		}
+		values := make([]byte, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			n, err := parseNumber(arg, 8)
+			if err != nil {
+				return err
+			}
+			values = append(values, byte(n))
+		}
+
+		d.vm.mu.Lock()
+		defer d.vm.mu.Unlock()
+		for i, n := range values {
+			d.vm.mem[uint16(addr)+uint16(i)] = n
+		}
+		d.vm.drawFlag = true
+		return nil
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("Usage: set REG VALUE or set mem ADDR VALUE...")
+	}
+	reg := strings.ToLower(args[0])
+	bits := 8
+	if reg == "i" || reg == "pc" {
+		bits = 16
+	}
+	n, err := parseNumber(args[1], bits)
+	if err != nil {
+		return err
+	}
+
+	d.vm.mu.Lock()
+	defer d.vm.mu.Unlock()
+	vm := d.vm
+	switch reg {
+	case "i":
+		vm.index = uint16(n)
+	case "pc":
+		vm.pc = uint16(n)
+	case "sp":
+		if n >= uint64(len(vm.stack)) {
+			return fmt.Errorf("SP must be less than %d", len(vm.stack))
+		}
+		vm.sp = uint16(n)
+	case "dt":
+		vm.delayTime = byte(n)
+	case "st":
+		vm.soundTime = byte(n)
+		vm.updateSound()
+	default:
+		if len(reg) != 2 || reg[0] != 'v' {
+			return fmt.Errorf("Unknown register %q", args[0])
+		}
+		r, err := strconv.ParseUint(reg[1:], 16, 4)
+		if err != nil {
+			return fmt.Errorf("Unknown register %q", args[0])
+		}
+		vm.v[r] = byte(n)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package chip8
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+//Loads V0, calls a subroutine that loads V1 and V2, then adds to V0 and
+//loops forever
+var debuggerROM = []byte{
+	0x60, 0x01, //200 V0 := 1
+	0x22, 0x08, //202 CALL 0x208
+	0x70, 0x01, //204 V0 += 1
+	0x12, 0x06, //206 JP 0x206
+	0x61, 0x05, //208 V1 := 5
+	0x62, 0x06, //20A V2 := 6
+	0x00, 0xEE, //20C RET
+}
+
+//Makes a paused machine with a debugger attached, the way chip8 debug
+//starts
+func newTestDebugger(t *testing.T) (*Machine, *Debugger, *bytes.Buffer) {
+	t.Helper()
+	vm := newTestMachine(t, debuggerROM)
+	vm.Pause()
+	out := &bytes.Buffer{}
+	return vm, NewDebugger(vm, out), out
+}
+
+//Ticks the machine the way Run does until the debugger stops it, and
+//returns why it stopped
+func runToStop(t *testing.T, vm *Machine, d *Debugger) string {
+	t.Helper()
+	for i := 0; i < 1000 && !vm.paused; i++ {
+		vm.tick()
+	}
+	if !vm.paused {
+		t.Fatal("The machine didn't stop")
+	}
+	reason := ""
+	for len(d.stops) > 0 {
+		reason = <-d.stops
+	}
+	return reason
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmds   []string
+		pc     uint16
+		reason string
+	}{
+		{"break", []string{"break 0x204", "continue"}, 0x204, "Breakpoint 1"},
+		{"decimal break", []string{"b 516", "c"}, 0x204, "Breakpoint 1"},
+
+		//A leading zero is still decimal, not octal
+		{"leading zero break", []string{"b 0516", "c"}, 0x204, "Breakpoint 1"},
+		{"tbreak", []string{"tbreak 0x206", "continue"}, 0x206, "Temporary breakpoint 1"},
+		{"step", []string{"step 2"}, 0x208, "Stepped"},
+		{"repeat", []string{"s", ""}, 0x208, "Stepped"},
+		{"finish", []string{"step 2", "finish"}, 0x204, "Returned"},
+		{"next", []string{"step", "next"}, 0x204, "Stepped over call"},
+		{"deleted break", []string{"break 0x208", "break 0x204", "delete 1", "continue"}, 0x204, "Breakpoint 2"},
+	}
+	for _, test := range tests {
+		vm, d, out := newTestDebugger(t)
+		reason := ""
+		for _, cmd := range test.cmds {
+			d.Exec(cmd)
+			reason = runToStop(t, vm, d)
+		}
+		if vm.pc != test.pc || reason != test.reason {
+			t.Errorf("%s: stopped at 0x%03X (%q), want 0x%03X (%q)\n%s", test.name, vm.pc, reason, test.pc, test.reason, out)
+		}
+	}
+}
+
+func TestDebuggerCommands(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"info", "No breakpoints\n"},
+		{"break 0x20A", "Breakpoint 1 at 0x020A\n"},
+		{"set v3 0x10", ""},
+		{"set i 0x208", ""},
+		{"set mem 0x300 1 2 0xFF", ""},
+		{"examine 0x300 4", "0x0300: 01 02 FF 00\n"},
+		{"x 0x208 2", "0x0208: 61 05\n"},
+		{"print", "PC: 0x0200  I: 0x0208  SP: 0  DT: 0  ST: 0\nV0: 0x00  V1: 0x00  V2: 0x00  V3: 0x10\n"},
+		{"p s", "Stack is empty\n"},
+		{"finish", "Not in a subroutine\n"},
+		{"set sp 16", "SP must be less than 16\n"},
+		{"set v3 256", "Invalid number \"256\"\n"},
+		{"set v3 0o17", "Invalid number \"0o17\"\n"},
+		{"set v3 1_0", "Invalid number \"1_0\"\n"},
+		{"set vz 1", "Unknown register \"vz\"\n"},
+		{"break nowhere", "Invalid number \"nowhere\"\n"},
+		{"delete 7", "No breakpoint number 7\n"},
+		{"frobnicate", "Unknown command \"frobnicate\". Try \"help\"\n"},
+	}
+	vm, d, out := newTestDebugger(t)
+	for _, test := range tests {
+		out.Reset()
+		d.Exec(test.cmd)
+		if got := out.String(); !strings.HasPrefix(got, test.want) {
+			t.Errorf("%q printed %q, want %q", test.cmd, got, test.want)
+		}
+	}
+	if vm.v[3] != 0x10 || vm.index != 0x208 {
+		t.Errorf("V3 = 0x%02X and I = 0x%03X after set, want 0x10 and 0x208", vm.v[3], vm.index)
+	}
+
+	//Resuming a running machine isn't allowed
+	d.Exec("continue")
+	out.Reset()
+	d.Exec("continue")
+	if got := out.String(); got != "The machine is already running\n" {
+		t.Errorf("continue while running printed %q", got)
+	}
+}
+
+func TestDebuggerWatchpoints(t *testing.T) {
+	rom := []byte{
+		0xA3, 0x00, //200 I := 0x300
+		0x60, 0x07, //202 V0 := 7
+		0xF0, 0x55, //204 LD [I], V0
+		0xA3, 0x00, //206 I := 0x300
+		0xF0, 0x65, //208 LD V0, [I]
+		0x12, 0x0A, //20A JP 0x20A
+	}
+	tests := []struct {
+		name   string
+		cmds   []string
+		pc     uint16
+		reason string
+	}{
+		{"write", []string{"watch 0x300", "continue"}, 0x206, "Watchpoint 1: write 0x0300 0x00 -> 0x07"},
+		{"read", []string{"rwatch 0x2FF-0x300", "continue"}, 0x20A, "Watchpoint 1: read 0x0300 = 0x07"},
+		{"access", []string{"awatch 0x300+1", "continue", "continue"}, 0x20A, "Watchpoint 1: read 0x0300 = 0x07"},
+		{"execute", []string{"xwatch 0x206-0x208", "continue"}, 0x206, "Watchpoint 1: execute 0x0206"},
+		{"condition", []string{"break 0x204 if v0 == 8", "break 0x208 if mem[0x300] == 7", "continue"}, 0x208, "Breakpoint 2"},
+		{"condition command", []string{"break 0x204", "condition 1 v0 != 7", "break 0x206", "continue"}, 0x206, "Breakpoint 2"},
+		{"ignore", []string{"xwatch 0x20A", "ignore 1 5", "continue"}, 0x20A, "Watchpoint 1: execute 0x020A"},
+	}
+	for _, test := range tests {
+		vm := newTestMachine(t, rom)
+		vm.Pause()
+		out := &bytes.Buffer{}
+		d := NewDebugger(vm, out)
+		reason := ""
+		for _, cmd := range test.cmds {
+			d.Exec(cmd)
+			reason = runToStop(t, vm, d)
+		}
+		if vm.pc != test.pc || reason != test.reason {
+			t.Errorf("%s: stopped at 0x%03X (%q), want 0x%03X (%q)\n%s", test.name, vm.pc, reason, test.pc, test.reason, out)
+		}
+
+		//The loop is first reached after 5 instructions, then every
+		//instruction after that hits the watchpoint again
+		if test.name == "ignore" && vm.cycles != 10 {
+			t.Errorf("ignore: stopped after %d instructions, want 10", vm.cycles)
+		}
+	}
+}
@@ -0,0 +1,577 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Every instruction the emulator understands. FDE executes them and the
+//disassembler prints them, both from what Decode returns, so the two can
+//never disagree about what a byte pair means
+type Opcode int
+
+const (
+	OpInvalid   Opcode = iota
+	OpCLS              //00E0
+	OpRET              //00EE
+	OpSCD              //00CN
+	OpSCU              //00DN
+	OpSCR              //00FB
+	OpSCL              //00FC
+	OpEXIT             //00FD
+	OpLOW              //00FE
+	OpHIGH             //00FF
+	OpJP               //1NNN
+	OpCALL             //2NNN
+	OpSEByte           //3XKK
+	OpSNEByte          //4XKK
+	OpSEReg            //5XY0
+	OpSaveRange        //5XY2
+	OpLoadRange        //5XY3
+	OpLDByte           //6XKK
+	OpADDByte          //7XKK
+	OpLDReg            //8XY0
+	OpOR               //8XY1
+	OpAND              //8XY2
+	OpXOR              //8XY3
+	OpADDReg           //8XY4
+	OpSUB              //8XY5
+	OpSHR              //8XY6
+	OpSUBN             //8XY7
+	OpSHL              //8XYE
+	OpSNEReg           //9XY0
+	OpLDI              //ANNN
+	OpJPV0             //BNNN
+	OpRND              //CXKK
+	OpDRW              //DXYN
+	OpSKP              //EX9E
+	OpSKNP             //EXA1
+	OpLDILong          //F000 NNNN
+	OpPLANE            //FN01
+	OpAUDIO            //F002
+	OpLDVxDT           //FX07
+	OpLDVxK            //FX0A
+	OpLDDTVx           //FX15
+	OpLDSTVx           //FX18
+	OpADDI             //FX1E
+	OpLDF              //FX29
+	OpLDHF             //FX30
+	OpLDB              //FX33
+	OpPITCH            //FX3A
+	OpStore            //FX55
+	OpLoad             //FX65
+	OpSaveFlags        //FX75
+	OpLoadFlags        //FX85
+)
+
+//A decoded instruction. Only the operands that Op uses are meaningful
+type Instruction struct {
+	Op Opcode
+
+	//The first two bytes as a big endian word
+	Raw uint16
+
+	//Length in bytes, 4 for F000 NNNN and 2 for everything else
+	Size uint16
+
+	X   byte
+	Y   byte
+	N   byte
+	KK  byte
+	NNN uint16 //Holds NNNN for F000 NNNN
+}
+
+//Decodes the instruction starting with the word op. next is the word after
+//it, which only F000 NNNN uses
+func Decode(op, next uint16) Instruction {
+	ins := Instruction{
+		Raw:  op,
+		Size: 2,
+		X:    byte((op & 0x0F00) >> 8),
+		Y:    byte((op & 0x00F0) >> 4),
+		N:    byte(op & 0x000F),
+		KK:   byte(op & 0x00FF),
+		NNN:  op & 0x0FFF,
+	}
+
+	switch op & 0xF000 {
+	case 0x0000:
+		switch {
+		case op == 0x00E0:
+			ins.Op = OpCLS
+		case op == 0x00EE:
+			ins.Op = OpRET
+		case op&0xFFF0 == 0x00C0:
+			ins.Op = OpSCD
+		case op&0xFFF0 == 0x00D0:
+			ins.Op = OpSCU
+		case op == 0x00FB:
+			ins.Op = OpSCR
+		case op == 0x00FC:
+			ins.Op = OpSCL
+		case op == 0x00FD:
+			ins.Op = OpEXIT
+		case op == 0x00FE:
+			ins.Op = OpLOW
+		case op == 0x00FF:
+			ins.Op = OpHIGH
+		}
+	case 0x1000:
+		ins.Op = OpJP
+	case 0x2000:
+		ins.Op = OpCALL
+	case 0x3000:
+		ins.Op = OpSEByte
+	case 0x4000:
+		ins.Op = OpSNEByte
+	case 0x5000:
+		switch op & 0x000F {
+		case 0x0:
+			ins.Op = OpSEReg
+		case 0x2:
+			ins.Op = OpSaveRange
+		case 0x3:
+			ins.Op = OpLoadRange
+		}
+	case 0x6000:
+		ins.Op = OpLDByte
+	case 0x7000:
+		ins.Op = OpADDByte
+	case 0x8000:
+		switch op & 0x000F {
+		case 0x0:
+			ins.Op = OpLDReg
+		case 0x1:
+			ins.Op = OpOR
+		case 0x2:
+			ins.Op = OpAND
+		case 0x3:
+			ins.Op = OpXOR
+		case 0x4:
+			ins.Op = OpADDReg
+		case 0x5:
+			ins.Op = OpSUB
+		case 0x6:
+			ins.Op = OpSHR
+		case 0x7:
+			ins.Op = OpSUBN
+		case 0xE:
+			ins.Op = OpSHL
+		}
+	case 0x9000:
+		if op&0x000F == 0 {
+			ins.Op = OpSNEReg
+		}
+	case 0xA000:
+		ins.Op = OpLDI
+	case 0xB000:
+		ins.Op = OpJPV0
+	case 0xC000:
+		ins.Op = OpRND
+	case 0xD000:
+		ins.Op = OpDRW
+	case 0xE000:
+		switch op & 0x00FF {
+		case 0x9E:
+			ins.Op = OpSKP
+		case 0xA1:
+			ins.Op = OpSKNP
+		}
+	case 0xF000:
+		switch op & 0x00FF {
+		case 0x00:
+			if op == 0xF000 {
+				ins.Op = OpLDILong
+				ins.NNN = next
+				ins.Size = 4
+			}
+		case 0x01:
+			ins.Op = OpPLANE
+		case 0x02:
+			if op == 0xF002 {
+				ins.Op = OpAUDIO
+			}
+		case 0x07:
+			ins.Op = OpLDVxDT
+		case 0x0A:
+			ins.Op = OpLDVxK
+		case 0x15:
+			ins.Op = OpLDDTVx
+		case 0x18:
+			ins.Op = OpLDSTVx
+		case 0x1E:
+			ins.Op = OpADDI
+		case 0x29:
+			ins.Op = OpLDF
+		case 0x30:
+			ins.Op = OpLDHF
+		case 0x33:
+			ins.Op = OpLDB
+		case 0x3A:
+			ins.Op = OpPITCH
+		case 0x55:
+			ins.Op = OpStore
+		case 0x65:
+			ins.Op = OpLoad
+		case 0x75:
+			ins.Op = OpSaveFlags
+		case 0x85:
+			ins.Op = OpLoadFlags
+		}
+	}
+	return ins
+}
+
+//Decodes the instruction at addr in mem, which must be 64K long or wrap
+//around like the machine's memory does
+func decodeAt(mem []byte, addr uint16) Instruction {
+	word := func(a uint16) uint16 {
+		return uint16(mem[int(a)%len(mem)])<<8 | uint16(mem[int(a+1)%len(mem)])
+	}
+	return Decode(word(addr), word(addr+2))
+}
+
+//Reports whether the instruction may skip the one after it
+func (ins Instruction) IsSkip() bool {
+	switch ins.Op {
+	case OpSEByte, OpSNEByte, OpSEReg, OpSNEReg, OpSKP, OpSKNP:
+		return true
+	}
+	return false
+}
+
+//Assembly syntaxes the disassembler can write
+type Syntax int
+
+const (
+	//The mnemonics from Cowgod's Chip-8 Technical Reference, with the
+	//SUPER-CHIP and XO-CHIP extensions
+	SyntaxCowgod Syntax = iota
+
+	//The language of the Octo assembler
+	SyntaxOcto
+)
+
+//Looks up a syntax by name, "cowgod" or "octo"
+func ParseSyntax(name string) (Syntax, error) {
+	switch strings.ToLower(name) {
+	case "cowgod":
+		return SyntaxCowgod, nil
+	case "octo":
+		return SyntaxOcto, nil
+	}
+	return 0, fmt.Errorf("Unknown syntax %q, expected cowgod or octo", name)
+}
+
+//Formats the instruction in Cowgod syntax without labels
+func (ins Instruction) String() string {
+	return ins.Format(SyntaxCowgod, nil)
+}
+
+//Formats the instruction in the given syntax. Addresses that have an entry
+//in labels are written as the label
+func (ins Instruction) Format(syntax Syntax, labels map[uint16]string) string {
+	addr := func(a uint16) string {
+		if label, ok := labels[a]; ok {
+			return label
+		}
+		if ins.Op == OpLDILong {
+			return fmt.Sprintf("0x%04X", a)
+		}
+		return fmt.Sprintf("0x%03X", a)
+	}
+	if syntax == SyntaxOcto {
+		return ins.formatOcto(addr)
+	}
+	return ins.formatCowgod(addr)
+}
+
+func (ins Instruction) formatCowgod(addr func(uint16) string) string {
+	x, y, kk := ins.X, ins.Y, ins.KK
+	switch ins.Op {
+	case OpCLS:
+		return "CLS"
+	case OpRET:
+		return "RET"
+	case OpSCD:
+		return fmt.Sprintf("SCD %d", ins.N)
+	case OpSCU:
+		return fmt.Sprintf("SCU %d", ins.N)
+	case OpSCR:
+		return "SCR"
+	case OpSCL:
+		return "SCL"
+	case OpEXIT:
+		return "EXIT"
+	case OpLOW:
+		return "LOW"
+	case OpHIGH:
+		return "HIGH"
+	case OpJP:
+		return "JP " + addr(ins.NNN)
+	case OpCALL:
+		return "CALL " + addr(ins.NNN)
+	case OpSEByte:
+		return fmt.Sprintf("SE V%X, 0x%02X", x, kk)
+	case OpSNEByte:
+		return fmt.Sprintf("SNE V%X, 0x%02X", x, kk)
+	case OpSEReg:
+		return fmt.Sprintf("SE V%X, V%X", x, y)
+	case OpSaveRange:
+		return fmt.Sprintf("SAVE V%X, V%X", x, y)
+	case OpLoadRange:
+		return fmt.Sprintf("LOAD V%X, V%X", x, y)
+	case OpLDByte:
+		return fmt.Sprintf("LD V%X, 0x%02X", x, kk)
+	case OpADDByte:
+		return fmt.Sprintf("ADD V%X, 0x%02X", x, kk)
+	case OpLDReg:
+		return fmt.Sprintf("LD V%X, V%X", x, y)
+	case OpOR:
+		return fmt.Sprintf("OR V%X, V%X", x, y)
+	case OpAND:
+		return fmt.Sprintf("AND V%X, V%X", x, y)
+	case OpXOR:
+		return fmt.Sprintf("XOR V%X, V%X", x, y)
+	case OpADDReg:
+		return fmt.Sprintf("ADD V%X, V%X", x, y)
+	case OpSUB:
+		return fmt.Sprintf("SUB V%X, V%X", x, y)
+	case OpSHR:
+		return fmt.Sprintf("SHR V%X, V%X", x, y)
+	case OpSUBN:
+		return fmt.Sprintf("SUBN V%X, V%X", x, y)
+	case OpSHL:
+		return fmt.Sprintf("SHL V%X, V%X", x, y)
+	case OpSNEReg:
+		return fmt.Sprintf("SNE V%X, V%X", x, y)
+	case OpLDI:
+		return "LD I, " + addr(ins.NNN)
+	case OpJPV0:
+		return "JP V0, " + addr(ins.NNN)
+	case OpRND:
+		return fmt.Sprintf("RND V%X, 0x%02X", x, kk)
+	case OpDRW:
+		return fmt.Sprintf("DRW V%X, V%X, %d", x, y, ins.N)
+	case OpSKP:
+		return fmt.Sprintf("SKP V%X", x)
+	case OpSKNP:
+		return fmt.Sprintf("SKNP V%X", x)
+	case OpLDILong:
+		return "LD I, LONG " + addr(ins.NNN)
+	case OpPLANE:
+		return fmt.Sprintf("PLANE %d", x)
+	case OpAUDIO:
+		return "AUDIO"
+	case OpLDVxDT:
+		return fmt.Sprintf("LD V%X, DT", x)
+	case OpLDVxK:
+		return fmt.Sprintf("LD V%X, K", x)
+	case OpLDDTVx:
+		return fmt.Sprintf("LD DT, V%X", x)
+	case OpLDSTVx:
+		return fmt.Sprintf("LD ST, V%X", x)
+	case OpADDI:
+		return fmt.Sprintf("ADD I, V%X", x)
+	case OpLDF:
+		return fmt.Sprintf("LD F, V%X", x)
+	case OpLDHF:
+		return fmt.Sprintf("LD HF, V%X", x)
+	case OpLDB:
+		return fmt.Sprintf("LD B, V%X", x)
+	case OpPITCH:
+		return fmt.Sprintf("PITCH V%X", x)
+	case OpStore:
+		return fmt.Sprintf("LD [I], V%X", x)
+	case OpLoad:
+		return fmt.Sprintf("LD V%X, [I]", x)
+	case OpSaveFlags:
+		return fmt.Sprintf("LD R, V%X", x)
+	case OpLoadFlags:
+		return fmt.Sprintf("LD V%X, R", x)
+	}
+	return fmt.Sprintf("DW 0x%04X", ins.Raw)
+}
+
+func (ins Instruction) formatOcto(addr func(uint16) string) string {
+	x, y, kk := ins.X, ins.Y, ins.KK
+	switch ins.Op {
+	case OpCLS:
+		return "clear"
+	case OpRET:
+		return "return"
+	case OpSCD:
+		return fmt.Sprintf("scroll-down %d", ins.N)
+	case OpSCU:
+		return fmt.Sprintf("scroll-up %d", ins.N)
+	case OpSCR:
+		return "scroll-right"
+	case OpSCL:
+		return "scroll-left"
+	case OpEXIT:
+		return "exit"
+	case OpLOW:
+		return "lores"
+	case OpHIGH:
+		return "hires"
+	case OpJP:
+		return "jump " + addr(ins.NNN)
+	case OpCALL:
+		//A bare name is a call in Octo, numbers need :call
+		target := addr(ins.NNN)
+		if strings.HasPrefix(target, "0x") {
+			return ":call " + target
+		}
+		return target
+	//Octo's if statements skip when their condition is false, so the
+	//skip instructions come out inverted
+	case OpSEByte:
+		return fmt.Sprintf("if v%x != 0x%02X then", x, kk)
+	case OpSNEByte:
+		return fmt.Sprintf("if v%x == 0x%02X then", x, kk)
+	case OpSEReg:
+		return fmt.Sprintf("if v%x != v%x then", x, y)
+	case OpSNEReg:
+		return fmt.Sprintf("if v%x == v%x then", x, y)
+	case OpSKP:
+		return fmt.Sprintf("if v%x -key then", x)
+	case OpSKNP:
+		return fmt.Sprintf("if v%x key then", x)
+	case OpSaveRange:
+		return fmt.Sprintf("save v%x - v%x", x, y)
+	case OpLoadRange:
+		return fmt.Sprintf("load v%x - v%x", x, y)
+	case OpLDByte:
+		return fmt.Sprintf("v%x := 0x%02X", x, kk)
+	case OpADDByte:
+		return fmt.Sprintf("v%x += 0x%02X", x, kk)
+	case OpLDReg:
+		return fmt.Sprintf("v%x := v%x", x, y)
+	case OpOR:
+		return fmt.Sprintf("v%x |= v%x", x, y)
+	case OpAND:
+		return fmt.Sprintf("v%x &= v%x", x, y)
+	case OpXOR:
+		return fmt.Sprintf("v%x ^= v%x", x, y)
+	case OpADDReg:
+		return fmt.Sprintf("v%x += v%x", x, y)
+	case OpSUB:
+		return fmt.Sprintf("v%x -= v%x", x, y)
+	case OpSHR:
+		return fmt.Sprintf("v%x >>= v%x", x, y)
+	case OpSUBN:
+		return fmt.Sprintf("v%x =- v%x", x, y)
+	case OpSHL:
+		return fmt.Sprintf("v%x <<= v%x", x, y)
+	case OpLDI:
+		return "i := " + addr(ins.NNN)
+	case OpJPV0:
+		return "jump0 " + addr(ins.NNN)
+	case OpRND:
+		return fmt.Sprintf("v%x := random 0x%02X", x, kk)
+	case OpDRW:
+		return fmt.Sprintf("sprite v%x v%x %d", x, y, ins.N)
+	case OpLDILong:
+		return "i := long " + addr(ins.NNN)
+	case OpPLANE:
+		return fmt.Sprintf("plane %d", x)
+	case OpAUDIO:
+		return "audio"
+	case OpLDVxDT:
+		return fmt.Sprintf("v%x := delay", x)
+	case OpLDVxK:
+		return fmt.Sprintf("v%x := key", x)
+	case OpLDDTVx:
+		return fmt.Sprintf("delay := v%x", x)
+	case OpLDSTVx:
+		return fmt.Sprintf("buzzer := v%x", x)
+	case OpADDI:
+		return fmt.Sprintf("i += v%x", x)
+	case OpLDF:
+		return fmt.Sprintf("i := hex v%x", x)
+	case OpLDHF:
+		return fmt.Sprintf("i := bighex v%x", x)
+	case OpLDB:
+		return fmt.Sprintf("bcd v%x", x)
+	case OpPITCH:
+		return fmt.Sprintf("pitch := v%x", x)
+	case OpStore:
+		return fmt.Sprintf("save v%x", x)
+	case OpLoad:
+		return fmt.Sprintf("load v%x", x)
+	case OpSaveFlags:
+		return fmt.Sprintf("saveflags v%x", x)
+	case OpLoadFlags:
+		return fmt.Sprintf("loadflags v%x", x)
+	}
+	return fmt.Sprintf("0x%02X 0x%02X", ins.Raw>>8, ins.Raw&0xFF)
+}
+
+//The bits of each opcode with every operand zero
+var opcodeBase = map[Opcode]uint16{
+	OpCLS:       0x00E0,
+	OpRET:       0x00EE,
+	OpSCD:       0x00C0,
+	OpSCU:       0x00D0,
+	OpSCR:       0x00FB,
+	OpSCL:       0x00FC,
+	OpEXIT:      0x00FD,
+	OpLOW:       0x00FE,
+	OpHIGH:      0x00FF,
+	OpJP:        0x1000,
+	OpCALL:      0x2000,
+	OpSEByte:    0x3000,
+	OpSNEByte:   0x4000,
+	OpSEReg:     0x5000,
+	OpSaveRange: 0x5002,
+	OpLoadRange: 0x5003,
+	OpLDByte:    0x6000,
+	OpADDByte:   0x7000,
+	OpLDReg:     0x8000,
+	OpOR:        0x8001,
+	OpAND:       0x8002,
+	OpXOR:       0x8003,
+	OpADDReg:    0x8004,
+	OpSUB:       0x8005,
+	OpSHR:       0x8006,
+	OpSUBN:      0x8007,
+	OpSHL:       0x800E,
+	OpSNEReg:    0x9000,
+	OpLDI:       0xA000,
+	OpJPV0:      0xB000,
+	OpRND:       0xC000,
+	OpDRW:       0xD000,
+	OpSKP:       0xE09E,
+	OpSKNP:      0xE0A1,
+	OpLDILong:   0xF000,
+	OpPLANE:     0xF001,
+	OpAUDIO:     0xF002,
+	OpLDVxDT:    0xF007,
+	OpLDVxK:     0xF00A,
+	OpLDDTVx:    0xF015,
+	OpLDSTVx:    0xF018,
+	OpADDI:      0xF01E,
+	OpLDF:       0xF029,
+	OpLDHF:      0xF030,
+	OpLDB:       0xF033,
+	OpPITCH:     0xF03A,
+	OpStore:     0xF055,
+	OpLoad:      0xF065,
+	OpSaveFlags: 0xF075,
+	OpLoadFlags: 0xF085,
+}
+
+//Encodes the instruction back into bytes, the inverse of Decode. Operands
+//the opcode doesn't use must either be zero or agree with the opcode, as
+//they do in anything Decode returns
+func (ins Instruction) Encode() []byte {
+	raw := opcodeBase[ins.Op] | uint16(ins.X&0xF)<<8 | uint16(ins.Y&0xF)<<4 | uint16(ins.N&0xF) | uint16(ins.KK)
+	if ins.Op == OpInvalid {
+		raw = ins.Raw
+	}
+	if ins.Op == OpLDILong {
+		return []byte{byte(raw >> 8), byte(raw), byte(ins.NNN >> 8), byte(ins.NNN)}
+	}
+	raw |= ins.NNN & 0x0FFF
+	return []byte{byte(raw >> 8), byte(raw)}
+}
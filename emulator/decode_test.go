@@ -0,0 +1,82 @@
+package chip8
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		op     uint16
+		want   Opcode
+		cowgod string
+		octo   string
+	}{
+		{0x00E0, OpCLS, "CLS", "clear"},
+		{0x00EE, OpRET, "RET", "return"},
+		{0x00C3, OpSCD, "SCD 3", "scroll-down 3"},
+		{0x00FD, OpEXIT, "EXIT", "exit"},
+		{0x1234, OpJP, "JP 0x234", "jump 0x234"},
+		{0x2345, OpCALL, "CALL 0x345", ":call 0x345"},
+		{0x3A12, OpSEByte, "SE VA, 0x12", "if va != 0x12 then"},
+		{0x5120, OpSEReg, "SE V1, V2", "if v1 != v2 then"},
+		{0x5122, OpSaveRange, "SAVE V1, V2", "save v1 - v2"},
+		{0x6A0F, OpLDByte, "LD VA, 0x0F", "va := 0x0F"},
+		{0x8124, OpADDReg, "ADD V1, V2", "v1 += v2"},
+		{0x812E, OpSHL, "SHL V1, V2", "v1 <<= v2"},
+		{0xA123, OpLDI, "LD I, 0x123", "i := 0x123"},
+		{0xB300, OpJPV0, "JP V0, 0x300", "jump0 0x300"},
+		{0xC1FF, OpRND, "RND V1, 0xFF", "v1 := random 0xFF"},
+		{0xD125, OpDRW, "DRW V1, V2, 5", "sprite v1 v2 5"},
+		{0xE39E, OpSKP, "SKP V3", "if v3 -key then"},
+		{0xE4A1, OpSKNP, "SKNP V4", "if v4 key then"},
+		{0xF000, OpLDILong, "LD I, LONG 0xABCD", "i := long 0xABCD"},
+		{0xF201, OpPLANE, "PLANE 2", "plane 2"},
+		{0xF50A, OpLDVxK, "LD V5, K", "v5 := key"},
+		{0xF633, OpLDB, "LD B, V6", "bcd v6"},
+		{0xF755, OpStore, "LD [I], V7", "save v7"},
+		{0xF865, OpLoad, "LD V8, [I]", "load v8"},
+		{0x0123, OpInvalid, "DW 0x0123", "0x01 0x23"},
+		{0x5121, OpInvalid, "DW 0x5121", "0x51 0x21"},
+	}
+	for _, test := range tests {
+		ins := Decode(test.op, 0xABCD)
+		if ins.Op != test.want {
+			t.Errorf("Decode(%04X).Op = %d, want %d", test.op, ins.Op, test.want)
+		}
+		if got := ins.String(); got != test.cowgod {
+			t.Errorf("Decode(%04X).String() = %q, want %q", test.op, got, test.cowgod)
+		}
+		if got := ins.Format(SyntaxOcto, nil); got != test.octo {
+			t.Errorf("Decode(%04X).Format(SyntaxOcto) = %q, want %q", test.op, got, test.octo)
+		}
+	}
+}
+
+func TestDecodeLabels(t *testing.T) {
+	labels := map[uint16]string{0x234: "loop"}
+	ins := Decode(0x1234, 0)
+	if got := ins.Format(SyntaxCowgod, labels); got != "JP loop" {
+		t.Errorf("Cowgod = %q, want %q", got, "JP loop")
+	}
+	if got := ins.Format(SyntaxOcto, labels); got != "jump loop" {
+		t.Errorf("Octo = %q, want %q", got, "jump loop")
+	}
+}
+
+//Every word must encode back to itself, whatever it decodes to
+func TestEncodeRoundTrip(t *testing.T) {
+	for op := 0; op <= 0xFFFF; op++ {
+		ins := Decode(uint16(op), 0xABCD)
+		want := []byte{byte(op >> 8), byte(op)}
+		if ins.Op == OpLDILong {
+			want = append(want, 0xAB, 0xCD)
+		}
+		if got := ins.Encode(); !bytes.Equal(got, want) {
+			t.Fatalf("Decode(%04X).Encode() = % X, want % X", op, got, want)
+		}
+		if int(ins.Size) != len(want) {
+			t.Fatalf("Decode(%04X).Size = %d, want %d", op, ins.Size, len(want))
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+//Most data bytes written on one line
+const disasmDataPerLine = 8
+
+//Disassembles a ROM loaded at 0x200. Code is told apart from data by
+//following every path the program can take from 0x200, so bytes that are
+//only reached through JP V0 or self modifying code come out as data.
+//Jump and call targets get loc_ and sub_ labels. The output is valid
+//source for the given syntax, with each line's address and raw bytes in a
+//comment
+func Disassemble(w io.Writer, rom []byte, syntax Syntax) error {
+	d := newDisassembly(rom)
+	d.trace(0x200)
+	d.label()
+
+	out := bufio.NewWriter(w)
+	comment := ";"
+	if syntax == SyntaxOcto {
+		comment = "#"
+	}
+
+	//Octo starts programs at main, and puts a jump to it at 0x200 unless
+	//it's the first thing in the program
+	if syntax == SyntaxOcto {
+		fmt.Fprintln(out, ": main")
+	}
+
+	end := 0x200 + len(rom)
+	for addr := 0x200; addr < end; {
+		if label, ok := d.labels[uint16(addr)]; ok {
+			if syntax == SyntaxOcto {
+				fmt.Fprintf(out, ": %s\n", label)
+			} else {
+				fmt.Fprintf(out, "%s:\n", label)
+			}
+		}
+
+		if d.code[addr] {
+			ins := decodeAt(d.mem, uint16(addr))
+			raw := fmt.Sprintf("%04X", ins.Raw)
+			if ins.Size == 4 {
+				raw += fmt.Sprintf(" %04X", ins.NNN)
+			}
+			fmt.Fprintf(out, "\t%-24s %s 0x%04X: %s\n", ins.Format(syntax, d.labels), comment, addr, raw)
+			addr += int(ins.Size)
+			continue
+		}
+
+		//Data runs until the next line of code or label
+		start := addr
+		var bytes []byte
+		for addr < end && len(bytes) < disasmDataPerLine && !d.code[addr] {
+			if _, ok := d.labels[uint16(addr)]; ok && addr != start {
+				break
+			}
+			bytes = append(bytes, d.mem[addr])
+			addr++
+		}
+		fmt.Fprintf(out, "\t%-24s %s 0x%04X\n", formatData(bytes, syntax), comment, start)
+	}
+	return out.Flush()
+}
+
+func formatData(bytes []byte, syntax Syntax) string {
+	s := ""
+	if syntax == SyntaxCowgod {
+		s = "DB "
+	}
+	for i, b := range bytes {
+		if i > 0 {
+			if syntax == SyntaxCowgod {
+				s += ","
+			}
+			s += " "
+		}
+		s += fmt.Sprintf("0x%02X", b)
+	}
+	return s
+}
+
+type disassembly struct {
+	//The ROM at 0x200 in a full address space, so decoding past its end
+	//reads zeros instead of panicking
+	mem []byte
+	end int
+
+	//code is set at the first byte of every instruction found
+	code    []bool
+	covered []bool
+	jumps   map[uint16]bool
+	calls   map[uint16]bool
+	labels  map[uint16]string
+}
+
+func newDisassembly(rom []byte) *disassembly {
+	d := &disassembly{
+		mem:     make([]byte, 65536),
+		end:     0x200 + len(rom),
+		code:    make([]bool, 65536),
+		covered: make([]bool, 65536),
+		jumps:   map[uint16]bool{},
+		calls:   map[uint16]bool{},
+		labels:  map[uint16]string{},
+	}
+	copy(d.mem[0x200:], rom)
+	return d
+}
+
+//Follows every path from start, marking the instructions it passes
+func (d *disassembly) trace(start uint16) {
+	pending := []uint16{start}
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if int(addr) < 0x200 || int(addr)+2 > d.end || d.code[addr] {
+			continue
+		}
+
+		//An instruction starting halfway through another one means the
+		//bytes are read two ways, leave them as the first one found them
+		ins := decodeAt(d.mem, addr)
+		if ins.Op == OpInvalid || int(addr)+int(ins.Size) > d.end || d.covered[addr] || d.covered[addr+1] {
+			continue
+		}
+		d.code[addr] = true
+		for i := uint16(0); i < ins.Size; i++ {
+			d.covered[addr+i] = true
+		}
+		next := addr + ins.Size
+
+		switch {
+		case ins.Op == OpJP:
+			d.jumps[ins.NNN] = true
+			pending = append(pending, ins.NNN)
+		case ins.Op == OpCALL:
+			d.calls[ins.NNN] = true
+			pending = append(pending, ins.NNN, next)
+		case ins.Op == OpRET || ins.Op == OpEXIT || ins.Op == OpJPV0:
+			//Where these go isn't known until the program runs
+		case ins.IsSkip():
+			pending = append(pending, next, next+decodeAt(d.mem, next).Size)
+		default:
+			pending = append(pending, next)
+		}
+	}
+}
+
+//Names the targets that start an instruction. Calls win over jumps, since
+//a subroutine is the more useful thing to know about an address
+func (d *disassembly) label() {
+	for addr := range d.jumps {
+		if d.code[addr] {
+			d.labels[addr] = fmt.Sprintf("loc_%04X", addr)
+		}
+	}
+	for addr := range d.calls {
+		if d.code[addr] {
+			d.labels[addr] = fmt.Sprintf("sub_%04X", addr)
+		}
+	}
+}
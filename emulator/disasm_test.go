@@ -0,0 +1,46 @@
+package chip8
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	//A call, a jump back, data skipped over, and a trailing byte
+	rom := []byte{0x22, 0x06, 0x12, 0x00, 0xF0, 0x0F, 0x60, 0x01, 0xA2, 0x04, 0x00, 0xEE, 0xAB}
+	tests := []struct {
+		syntax Syntax
+		want   string
+	}{
+		{SyntaxCowgod, `loc_0200:
+	CALL sub_0206            ; 0x0200: 2206
+	JP loc_0200              ; 0x0202: 1200
+	DB 0xF0, 0x0F            ; 0x0204
+sub_0206:
+	LD V0, 0x01              ; 0x0206: 6001
+	LD I, 0x204              ; 0x0208: A204
+	RET                      ; 0x020A: 00EE
+	DB 0xAB                  ; 0x020C
+`},
+		{SyntaxOcto, `: main
+: loc_0200
+	sub_0206                 # 0x0200: 2206
+	jump loc_0200            # 0x0202: 1200
+	0xF0 0x0F                # 0x0204
+: sub_0206
+	v0 := 0x01               # 0x0206: 6001
+	i := 0x204               # 0x0208: A204
+	return                   # 0x020A: 00EE
+	0xAB                     # 0x020C
+`},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := Disassemble(&out, rom, test.syntax); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.want {
+			t.Errorf("Syntax %d:\n%s\nwant:\n%s", test.syntax, out.String(), test.want)
+		}
+	}
+}
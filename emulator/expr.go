@@ -0,0 +1,280 @@
+package chip8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//A small expression language for breakpoint conditions and the debugger's
+//print command. It has C's operators and precedence, integers (decimal,
+//0x hex or 0b binary) and these names:
+//
+//	v0-vf       general purpose registers
+//	i, pc, sp   index register, program counter and stack pointer
+//	dt, st      delay and sound timers
+//	mem[e]      the byte at address e
+//
+//For example: v3 == 0x10 && mem[i] > 4
+type Expr struct {
+	source string
+	eval   func(vm *Machine) int64
+}
+
+//Parses an expression, reporting the column of the first error
+func ParseExpr(source string) (*Expr, error) {
+	p := &exprParser{src: source}
+	p.next()
+	eval, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("Unexpected %q", p.tok)
+	}
+	return &Expr{source: source, eval: eval}, nil
+}
+
+//Evaluates the expression against vm. The caller must hold vm.mu if Run
+//might be going
+func (e *Expr) Eval(vm *Machine) int64 {
+	return e.eval(vm)
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+type exprParser struct {
+	src string
+	pos int
+
+	//Current token and where it started. Empty at the end of the input
+	tok    string
+	tokPos int
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Column %d: %s", p.tokPos+1, fmt.Sprintf(format, args...))
+}
+
+//Two character operators, checked before the single character ones
+var exprOperators2 = []string{"||", "&&", "==", "!=", "<=", ">=", "<<", ">>"}
+
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	p.tokPos = p.pos
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+
+	c := rune(p.src[p.pos])
+	if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' {
+		end := p.pos
+		for end < len(p.src) && (unicode.IsLetter(rune(p.src[end])) || unicode.IsDigit(rune(p.src[end])) || p.src[end] == '_') {
+			end++
+		}
+		p.tok = p.src[p.pos:end]
+		p.pos = end
+		return
+	}
+	for _, op := range exprOperators2 {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.tok = op
+			p.pos += 2
+			return
+		}
+	}
+	p.tok = p.src[p.pos : p.pos+1]
+	p.pos++
+}
+
+//Binding strength of each binary operator, loosest first like C
+var exprPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//Parses operators binding tighter than minPrec by precedence climbing
+func (p *exprParser) parseBinary(minPrec int) (func(*Machine) int64, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.tok
+		prec, ok := exprPrecedence[op]
+		if !ok || prec <= minPrec {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.parseBinary(prec)
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryOp(op, lhs, rhs)
+	}
+}
+
+func binaryOp(op string, l, r func(*Machine) int64) func(*Machine) int64 {
+	switch op {
+	case "||":
+		return func(vm *Machine) int64 { return boolInt(l(vm) != 0 || r(vm) != 0) }
+	case "&&":
+		return func(vm *Machine) int64 { return boolInt(l(vm) != 0 && r(vm) != 0) }
+	case "|":
+		return func(vm *Machine) int64 { return l(vm) | r(vm) }
+	case "^":
+		return func(vm *Machine) int64 { return l(vm) ^ r(vm) }
+	case "&":
+		return func(vm *Machine) int64 { return l(vm) & r(vm) }
+	case "==":
+		return func(vm *Machine) int64 { return boolInt(l(vm) == r(vm)) }
+	case "!=":
+		return func(vm *Machine) int64 { return boolInt(l(vm) != r(vm)) }
+	case "<":
+		return func(vm *Machine) int64 { return boolInt(l(vm) < r(vm)) }
+	case "<=":
+		return func(vm *Machine) int64 { return boolInt(l(vm) <= r(vm)) }
+	case ">":
+		return func(vm *Machine) int64 { return boolInt(l(vm) > r(vm)) }
+	case ">=":
+		return func(vm *Machine) int64 { return boolInt(l(vm) >= r(vm)) }
+	case "<<":
+		return func(vm *Machine) int64 { return l(vm) << uint64(r(vm)&63) }
+	case ">>":
+		return func(vm *Machine) int64 { return l(vm) >> uint64(r(vm)&63) }
+	case "+":
+		return func(vm *Machine) int64 { return l(vm) + r(vm) }
+	case "-":
+		return func(vm *Machine) int64 { return l(vm) - r(vm) }
+	case "*":
+		return func(vm *Machine) int64 { return l(vm) * r(vm) }
+	case "/":
+		return func(vm *Machine) int64 {
+			if d := r(vm); d != 0 {
+				return l(vm) / d
+			}
+			return 0
+		}
+	default: //"%"
+		return func(vm *Machine) int64 {
+			if d := r(vm); d != 0 {
+				return l(vm) % d
+			}
+			return 0
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (func(*Machine) int64, error) {
+	switch p.tok {
+	case "!", "-", "~":
+		op := p.tok
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "!":
+			return func(vm *Machine) int64 { return boolInt(operand(vm) == 0) }, nil
+		case "-":
+			return func(vm *Machine) int64 { return -operand(vm) }, nil
+		default:
+			return func(vm *Machine) int64 { return ^operand(vm) }, nil
+		}
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (func(*Machine) int64, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, p.errorf("Unexpected end of expression")
+
+	case tok == "(":
+		p.next()
+		inner, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("Expected )")
+		}
+		p.next()
+		return inner, nil
+
+	case unicode.IsDigit(rune(tok[0])):
+		digits, base := tok, 10
+		switch lower := strings.ToLower(tok); {
+		case strings.HasPrefix(lower, "0x"):
+			digits, base = tok[2:], 16
+		case strings.HasPrefix(lower, "0b"):
+			digits, base = tok[2:], 2
+		}
+		n, err := strconv.ParseInt(digits, base, 64)
+		if err != nil {
+			return nil, p.errorf("Invalid number %q", tok)
+		}
+		p.next()
+		return func(*Machine) int64 { return n }, nil
+	}
+
+	name, at := strings.ToLower(tok), p.tokPos
+	p.next()
+	switch name {
+	case "i":
+		return func(vm *Machine) int64 { return int64(vm.index) }, nil
+	case "pc":
+		return func(vm *Machine) int64 { return int64(vm.pc) }, nil
+	case "sp":
+		return func(vm *Machine) int64 { return int64(vm.sp) }, nil
+	case "dt":
+		return func(vm *Machine) int64 { return int64(vm.delayTime) }, nil
+	case "st":
+		return func(vm *Machine) int64 { return int64(vm.soundTime) }, nil
+	case "mem":
+		if p.tok != "[" {
+			return nil, p.errorf("Expected [ after mem")
+		}
+		p.next()
+		addr, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != "]" {
+			return nil, p.errorf("Expected ]")
+		}
+		p.next()
+		return func(vm *Machine) int64 { return int64(vm.mem[uint16(addr(vm))]) }, nil
+	}
+
+	if len(name) == 2 && name[0] == 'v' {
+		if r, err := strconv.ParseUint(name[1:], 16, 4); err == nil {
+			return func(vm *Machine) int64 { return int64(vm.v[r]) }, nil
+		}
+	}
+	return nil, fmt.Errorf("Column %d: Unknown name %q", at+1, tok)
+}
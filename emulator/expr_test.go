@@ -0,0 +1,86 @@
+package chip8
+
+import "testing"
+
+func TestExprEval(t *testing.T) {
+	vm := NewMachine()
+	vm.v[0x3] = 0x10
+	vm.v[0xF] = 1
+	vm.index = 0x300
+	vm.mem[0x300] = 7
+	vm.mem[0x301] = 9
+	vm.delayTime = 30
+
+	tests := []struct {
+		src  string
+		want int64
+	}{
+		{"42", 42},
+		{"0x2A", 42},
+		{"0b101010", 42},
+		{"010", 10},
+		{"v3", 0x10},
+		{"VF", 1},
+		{"i", 0x300},
+		{"pc", 0x200},
+		{"sp", 0},
+		{"dt", 30},
+		{"st", 0},
+		{"mem[i]", 7},
+		{"mem[i + 1]", 9},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"7 / 2", 3},
+		{"7 % 4", 3},
+		{"7 / 0", 0},
+		{"1 << 4 | 1", 17},
+		{"0xF0 >> 4 & 3", 3},
+		{"6 ^ 3", 5},
+		{"-3 + 5", 2},
+		{"~0", -1},
+		{"!v3", 0},
+		{"!st", 1},
+		{"v3 == 0x10 && mem[i] > 4", 1},
+		{"v3 != 0x10 || dt <= 29", 0},
+		{"1 < 2 == 1", 1},
+		{"dt >= 30", 1},
+	}
+	for _, test := range tests {
+		e, err := ParseExpr(test.src)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", test.src, err)
+			continue
+		}
+		if got := e.Eval(vm); got != test.want {
+			t.Errorf("%q = %d, want %d", test.src, got, test.want)
+		}
+		if e.String() != test.src {
+			t.Errorf("String() = %q, want %q", e.String(), test.src)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"", "Column 1: Unexpected end of expression"},
+		{"v3 ==", "Column 6: Unexpected end of expression"},
+		{"(1 + 2", "Column 7: Expected )"},
+		{"mem 4", "Column 5: Expected [ after mem"},
+		{"mem[4", "Column 6: Expected ]"},
+		{"1 2", "Column 3: Unexpected \"2\""},
+		{"vg + 1", "Column 1: Unknown name \"vg\""},
+		{"1 + 0xZZ", "Column 5: Invalid number \"0xZZ\""},
+		{"0o17", "Column 1: Invalid number \"0o17\""},
+		{"1_000", "Column 1: Invalid number \"1_000\""},
+	}
+	for _, test := range tests {
+		_, err := ParseExpr(test.src)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("ParseExpr(%q) = %v, want %q", test.src, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package chip8
+
+import "sync"
+
+//A Frontend is anything the emulator can draw the display to and read the
+//keypad from. The gui package provides a windowed one, and Headless below
+//can be used anywhere there is no screen (CI, tests, tools)
+type Frontend interface {
+	//Called with a copy of the display whenever the draw flag is set
+	DrawGraphics(fb Framebuffer)
+
+	//Called every cycle the screen isn't redrawn, so input keeps flowing
+	UpdateInput()
+
+	//Returns the keypad presses and releases since the last call
+	KeyEvents() []KeyEvent
+
+	//Reports whether the frontend wants the emulator to stop
+	Closed() bool
+}
+
+//Emulator controls a frontend can offer besides the keypad
+type Hotkey int
+
+const (
+	//Save the machine to its state file
+	HotkeySaveState Hotkey = iota
+
+	//Load the machine from its state file
+	HotkeyLoadState
+
+	//Step backwards. Reported on every poll for as long as it's held
+	HotkeyRewind
+
+	//Save the display as a PNG
+	HotkeyScreenshot
+
+	//Start or stop recording the display as a GIF
+	HotkeyRecord
+)
+
+//Frontends that have hotkeys implement HotkeySource as well as Frontend.
+//Run checks for them every cycle
+type HotkeySource interface {
+	//Returns the hotkeys pressed since the last call
+	Hotkeys() []Hotkey
+}
+
+//A single press or release of one of the 16 CHIP-8 keys
+type KeyEvent struct {
+	Key  byte
+	Down bool
+}
+
+//Headless is a Frontend without a screen. It keeps the last drawn frame in
+//memory and takes key events from whoever drives it
+type Headless struct {
+	//Last frame passed to DrawGraphics
+	Frame Framebuffer
+
+	//Number of times DrawGraphics has been called
+	Frames int
+
+	mu      sync.Mutex
+	pending []KeyEvent
+	closed  bool
+}
+
+func NewHeadless() *Headless {
+	return &Headless{
+		Frame: Framebuffer{Width: loresWidth, Height: loresHeight, Pix: make([]byte, loresWidth*loresHeight)},
+	}
+}
+
+func (h *Headless) DrawGraphics(fb Framebuffer) {
+	h.Frame = fb
+	h.Frames++
+}
+
+func (h *Headless) UpdateInput() {}
+
+func (h *Headless) KeyEvents() []KeyEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := h.pending
+	h.pending = nil
+	return events
+}
+
+func (h *Headless) Closed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closed
+}
+
+//Queues a key press or release to be picked up on the next cycle
+func (h *Headless) PushKey(key byte, down bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending = append(h.pending, KeyEvent{Key: key & 0xF, Down: down})
+}
+
+//Makes Closed report true, which stops Run
+func (h *Headless) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+}
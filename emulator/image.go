@@ -0,0 +1,97 @@
+package chip8
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+//Returns the display as an image, with every pixel drawn as a scale by
+//scale square in its palette colour
+func (fb Framebuffer) Image(scale int, p Palette) *image.Paletted {
+	if scale < 1 {
+		scale = 1
+	}
+	colors := make(color.Palette, len(p))
+	for i, c := range p {
+		colors[i] = c
+	}
+	img := image.NewPaletted(image.Rect(0, 0, fb.Width*scale, fb.Height*scale), colors)
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			pix := fb.At(x, y) & 3
+			if pix == 0 {
+				continue
+			}
+			for row := y * scale; row < (y+1)*scale; row++ {
+				start := img.PixOffset(x*scale, row)
+				for i := 0; i < scale; i++ {
+					img.Pix[start+i] = pix
+				}
+			}
+		}
+	}
+	return img
+}
+
+//Parses a palette written as two or four comma separated hex colours, like
+//"000000,ffffff". The first is the background. With only two, the XO-CHIP
+//colours are left at their defaults
+func ParsePalette(s string) (Palette, error) {
+	p := DefaultPalette
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 && len(parts) != 4 {
+		return p, fmt.Errorf("A palette needs 2 or 4 colours, found %d in %q", len(parts), s)
+	}
+	for i, part := range parts {
+		part = strings.TrimPrefix(strings.TrimSpace(part), "#")
+		rgb, err := hex.DecodeString(part)
+		if err != nil || len(rgb) != 3 {
+			return p, fmt.Errorf("Bad colour %q, expected 6 hex digits like ff8800", part)
+		}
+		p[i] = color.RGBA{rgb[0], rgb[1], rgb[2], 0xFF}
+	}
+	return p, nil
+}
+
+//Writes all four colours out the way ParsePalette reads them
+func (p Palette) String() string {
+	colours := make([]string, len(p))
+	for i, c := range p {
+		colours[i] = fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return strings.Join(colours, ",")
+}
+
+//Writes the display as a PNG, at the scale and palette set with
+//WithScreenshots
+func (vm *Machine) Screenshot(w io.Writer) error {
+	return png.Encode(w, vm.Framebuffer().Image(vm.screenshotScale, vm.palette))
+}
+
+//Saves a screenshot to a file, replacing it if it exists
+func (vm *Machine) SaveScreenshot(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := vm.Screenshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//Runs the machine as fast as it will go until cycle, or until the program
+//exits. Nothing is drawn and the keypad isn't read
+func (vm *Machine) RunUntil(cycle uint64) {
+	for vm.cycles < cycle && !vm.exited {
+		vm.Step()
+		vm.recordRewind()
+	}
+}
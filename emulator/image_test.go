@@ -0,0 +1,114 @@
+package chip8
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden images in testdata")
+
+//Runs the bundled test ROMs headless and compares the screen they finish
+//on with the images in testdata. Run with -update after checking a change
+//to the screen is right
+func TestROMGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		rom    string
+
+		//Test picked from the Timendus suite's menu, through the byte at
+		//0x1FF it checks before showing the menu
+		test byte
+
+		//Key pressed for a few frames after the first half second, for
+		//menus
+		key byte
+	}{
+		{"ibm-logo", "IBM Logo.ch8", 0, 0},
+		{"test-opcode", "test_opcode.ch8", 0, 0},
+		{"chip8-test-rom", "chip8-test-rom.ch8", 0, 0},
+		{"timendus-splash", "chip8-test-suite.ch8", 0, 0},
+		{"timendus-ibm", "chip8-test-suite.ch8", 1, 0},
+		{"timendus-corax", "chip8-test-suite.ch8", 2, 0},
+		{"timendus-flags", "chip8-test-suite.ch8", 3, 0},
+
+		//CHIP-8 quirks. Display wait fails, as drawing doesn't wait for
+		//the next frame
+		{"timendus-quirks", "chip8-test-suite.ch8", 4, 1},
+	}
+	for _, test := range tests {
+		keys := NewHeadless()
+		vm := newTestMachine(t, readTestROM(t, test.rom), WithFrontend(keys))
+		vm.mem[0x1FF] = test.test
+		for frame := 0; frame < 1200; frame++ {
+			switch {
+			case test.key == 0:
+			case frame == 30:
+				keys.PushKey(test.key, true)
+			case frame == 33:
+				keys.PushKey(test.key, false)
+			}
+			vm.RunFrame()
+		}
+		if err := vm.Err(); err != nil {
+			t.Errorf("%s: %v", test.golden, err)
+		}
+
+		got := vm.Framebuffer().Image(4, DefaultPalette)
+		path := filepath.Join("testdata", test.golden+".png")
+		if *update {
+			if err := writePNG(path, got); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := readPNG(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(got, want) {
+			t.Errorf("%s: screen differs from %s", test.golden, path)
+		}
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func sameImage(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r1, g1, b1, a1 := a.At(x, y).RGBA()
+			r2, g2, b2, a2 := b.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+	return true
+}
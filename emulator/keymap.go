@@ -0,0 +1,124 @@
+package chip8
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//A Keymap names the keyboard key that stands for each of the 16 keypad
+//keys, indexed by keypad key. Keys are named by what's printed on them, so
+//a keymap means the same keys on any keyboard layout that has them. Keys
+//without a printable label have names like space
+type Keymap [16]string
+
+//The keypad keys row by row, as laid out on the COSMAC VIP:
+//
+//	1 2 3 C
+//	4 5 6 D
+//	7 8 9 E
+//	A 0 B F
+//
+//Keymaps written out as strings list keyboard keys in this order, so they
+//read like the keypad they make
+var keypadOrder = [16]byte{
+	0x1, 0x2, 0x3, 0xC,
+	0x4, 0x5, 0x6, 0xD,
+	0x7, 0x8, 0x9, 0xE,
+	0xA, 0x0, 0xB, 0xF,
+}
+
+//The block of keys at the top left of the keyboard on each layout, plus
+//hex, which puts each keypad key on the keyboard key with its own label
+var keymapPresets = map[string]string{
+	"qwerty": "1234qwerasdfzxcv",
+	"azerty": "&é\"'azerqsdfwxcv",
+	"dvorak": "1234',.paoeu;qjk",
+	"hex":    "123c456d789ea0bf",
+}
+
+//The QWERTY keymap, used unless another is picked
+var DefaultKeymap = mustParseKeymap("qwerty")
+
+//Names of the keymap presets, sorted
+func Keymaps() []string {
+	names := make([]string, 0, len(keymapPresets))
+	for name := range keymapPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Reads a keymap preset by name, or a keymap written out as the 16 keyboard
+//keys in keypad order (see keypadOrder). Keys can be run together, like
+//1234qwerasdfzxcv, or separated with commas when some have longer names,
+//like 1,2,3,4,q,w,e,r,a,s,d,f,z,x,c,space
+func ParseKeymap(s string) (Keymap, error) {
+	if preset, ok := keymapPresets[strings.ToLower(s)]; ok {
+		s = preset
+	}
+
+	//Dvorak's comma key means a comma doesn't always separate keys
+	keys := strings.Split(s, ",")
+	if len(keys) != 16 {
+		keys = nil
+		for _, r := range s {
+			keys = append(keys, string(r))
+		}
+	}
+	if len(keys) != 16 {
+		return Keymap{}, fmt.Errorf("Bad keymap %q: expected one of %s, or 16 keys in keypad order", s, strings.Join(Keymaps(), ", "))
+	}
+
+	var km Keymap
+	seen := map[string]bool{}
+	for i, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			return Keymap{}, fmt.Errorf("Bad keymap %q: key %X is empty", s, keypadOrder[i])
+		}
+		if seen[key] {
+			return Keymap{}, fmt.Errorf("Bad keymap %q: %q is used for more than one key", s, key)
+		}
+		seen[key] = true
+		km[keypadOrder[i]] = key
+	}
+	return km, nil
+}
+
+func mustParseKeymap(s string) Keymap {
+	km, err := ParseKeymap(s)
+	if err != nil {
+		panic(err)
+	}
+	return km
+}
+
+//Returns the keypad key a keyboard key stands for
+func (km Keymap) Key(name string) (byte, bool) {
+	name = strings.ToLower(name)
+	for key, n := range km {
+		if n == name {
+			return byte(key), true
+		}
+	}
+	return 0, false
+}
+
+//Writes the keymap out in keypad order, the way ParseKeymap reads it
+func (km Keymap) String() string {
+	keys := make([]string, len(keypadOrder))
+	short := true
+	for i, key := range keypadOrder {
+		keys[i] = km[key]
+		if utf8.RuneCountInString(keys[i]) != 1 {
+			short = false
+		}
+	}
+	if short {
+		return strings.Join(keys, "")
+	}
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,59 @@
+package chip8
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKeymap(t *testing.T) {
+	tests := []struct {
+		src    string
+		keys   map[string]byte
+		string string
+	}{
+		{"qwerty", map[string]byte{"1": 0x1, "q": 0x4, "w": 0x5, "x": 0x0, "v": 0xF}, "1234qwerasdfzxcv"},
+		{"AZERTY", map[string]byte{"&": 0x1, "é": 0x2, "w": 0xA}, "&é\"'azerqsdfwxcv"},
+		{"dvorak", map[string]byte{",": 0x5, ".": 0x6, "'": 0x4}, "1234',.paoeu;qjk"},
+		{"hex", map[string]byte{"a": 0xA, "0": 0x0, "c": 0xC}, "123c456d789ea0bf"},
+		{"1,2,3,4,q,w,e,r,a,s,d,f,z,x,c,Space", map[string]byte{"space": 0xF, "SPACE": 0xF}, "1,2,3,4,q,w,e,r,a,s,d,f,z,x,c,space"},
+	}
+	for _, test := range tests {
+		km, err := ParseKeymap(test.src)
+		if err != nil {
+			t.Errorf("ParseKeymap(%q): %v", test.src, err)
+			continue
+		}
+		for name, want := range test.keys {
+			if got, ok := km.Key(name); !ok || got != want {
+				t.Errorf("%q: Key(%q) = %X, %v, want %X", test.src, name, got, ok, want)
+			}
+		}
+		if got := km.String(); got != test.string {
+			t.Errorf("%q: String() = %q, want %q", test.src, got, test.string)
+		}
+		if again, err := ParseKeymap(km.String()); err != nil || again != km {
+			t.Errorf("%q: String() doesn't read back: %v", test.src, err)
+		}
+	}
+	if _, ok := DefaultKeymap.Key("p"); ok {
+		t.Error("Key(\"p\") found in the default keymap")
+	}
+}
+
+func TestParseKeymapErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"colemak", "expected one of azerty, dvorak, hex, qwerty"},
+		{"1234qwerasdfzxc", "expected one of"},
+		{"1234qwerasdfzxcq", "\"q\" is used for more than one key"},
+		{"1,2,3,4,q,w,e,r,a,s,d,f,z,x,c, ", "key F is empty"},
+	}
+	for _, test := range tests {
+		_, err := ParseKeymap(test.src)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("ParseKeymap(%q) = %v, want %q", test.src, err, test.err)
+		}
+	}
+}
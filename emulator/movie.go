@@ -0,0 +1,249 @@
+package chip8
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+)
+
+const movieVersion = 1
+
+//A Movie is everything typed on the keypad while a ROM ran, along with the
+//settings needed to run it again exactly the same way. Movies are saved as
+//JSON so they can be read and attached to bug reports
+type Movie struct {
+	Version int `json:"version"`
+
+	//File name the ROM was loaded from, only a hint for finding it again
+	ROM string `json:"rom"`
+
+	//SHA-1 of the ROM, checked before replaying
+	ROMSHA1 string `json:"rom_sha1"`
+
+	//Name of the quirks preset, only for people reading the movie. Quirks
+	//is what gets used
+	Platform   string `json:"platform,omitempty"`
+	Quirks     Quirks `json:"quirks"`
+	ClockSpeed int    `json:"clock_speed"`
+
+	//Instructions per 60Hz frame, which decides when the timers tick
+	InstructionsPerFrame int `json:"instructions_per_frame"`
+
+	//Random mode and seed for CXKK. Replaying needs an RNG of the same mode
+	RNG  string `json:"rng,omitempty"`
+	Seed int64  `json:"seed"`
+
+	//Length of the movie in cycles
+	Cycles uint64 `json:"cycles"`
+
+	Events      []MovieEvent      `json:"events"`
+	Checkpoints []MovieCheckpoint `json:"checkpoints"`
+}
+
+//A key press or release, at the cycle the machine picked it up
+type MovieEvent struct {
+	Cycle uint64 `json:"cycle"`
+	Key   byte   `json:"key"`
+	Down  bool   `json:"down"`
+}
+
+//A checksum of the display at a cycle, so a replay can tell where it went
+//different from the recording
+type MovieCheckpoint struct {
+	Cycle uint64 `json:"cycle"`
+	Frame uint32 `json:"frame"`
+}
+
+//Hex SHA-1 of a ROM, as stored in movies
+func ROMHash(rom []byte) string {
+	sum := sha1.Sum(rom)
+	return hex.EncodeToString(sum[:])
+}
+
+//CRC-32 of the display, including its size
+func frameChecksum(fb Framebuffer) uint32 {
+	h := crc32.NewIEEE()
+	h.Write([]byte{byte(fb.Width), byte(fb.Height)})
+	h.Write(fb.Pix)
+	return h.Sum32()
+}
+
+//Reads a movie written by Save
+func LoadMovie(path string) (*Movie, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Movie
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("Not a CHIP-8 movie: %v", err)
+	}
+	if m.Version != movieVersion {
+		return nil, fmt.Errorf("Unsupported movie version %d", m.Version)
+	}
+	return &m, nil
+}
+
+//Writes the movie to a file, replacing it if it exists
+func (m *Movie) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (m *Movie) checkpoint(vm *Machine) {
+	if n := len(m.Checkpoints); n > 0 && m.Checkpoints[n-1].Cycle == vm.cycles {
+		return
+	}
+	m.Checkpoints = append(m.Checkpoints, MovieCheckpoint{Cycle: vm.cycles, Frame: frameChecksum(vm.Framebuffer())})
+}
+
+//Resets the machine, seeds its RNG and starts recording the keypad into a
+//new movie. Call StopRecording to finish it
+func (vm *Machine) Record(seed int64) *Movie {
+	vm.rng.Seed(seed)
+	vm.Reset()
+	vm.recorder = &Movie{
+		Version:              movieVersion,
+		ROMSHA1:              ROMHash(vm.rom),
+		Quirks:               vm.quirks,
+		ClockSpeed:           vm.clockSpeed,
+		InstructionsPerFrame: vm.InstructionsPerFrame(),
+		Seed:                 seed,
+	}
+	return vm.recorder
+}
+
+//Finishes the movie started by Record, with a last checkpoint at the
+//current cycle
+func (vm *Machine) StopRecording() *Movie {
+	m := vm.recorder
+	if m == nil {
+		return nil
+	}
+	vm.recorder = nil
+	m.Cycles = vm.cycles
+	m.checkpoint(vm)
+	return m
+}
+
+//Plays a movie back, feeding the keypad from it instead of the frontend
+type moviePlayer struct {
+	movie  *Movie
+	frames map[uint64]uint32
+
+	//Last cycle events were delivered at, so they aren't delivered twice
+	//while Run is paused
+	delivered uint64
+
+	//First difference from the recording
+	err error
+
+	//Set once the end has been reached in Run
+	finished bool
+}
+
+//No cycle has been delivered yet
+const noCycle = ^uint64(0)
+
+func (p *moviePlayer) eventsAt(cycle uint64) []KeyEvent {
+	if cycle == p.delivered {
+		return nil
+	}
+	p.delivered = cycle
+	var events []KeyEvent
+	i := sort.Search(len(p.movie.Events), func(i int) bool { return p.movie.Events[i].Cycle >= cycle })
+	for ; i < len(p.movie.Events) && p.movie.Events[i].Cycle == cycle; i++ {
+		ev := p.movie.Events[i]
+		events = append(events, KeyEvent{Key: ev.Key & 0xF, Down: ev.Down})
+	}
+	return events
+}
+
+func (p *moviePlayer) check(vm *Machine) {
+	want, ok := p.frames[vm.cycles]
+	if !ok || p.err != nil {
+		return
+	}
+	if got := frameChecksum(vm.Framebuffer()); got != want {
+		p.err = fmt.Errorf("Display differs from the recording at cycle %d (%08X, recorded %08X)", vm.cycles, got, want)
+	}
+}
+
+//Resets the machine with the movie's settings and plays it back from then
+//on. The ROM has to be loaded already and be the one the movie was recorded
+//with, and the machine's RNG has to be of the movie's mode. Either Run the
+//machine to watch it, or use RunReplay
+func (vm *Machine) Replay(m *Movie) error {
+	if hash := ROMHash(vm.rom); hash != m.ROMSHA1 {
+		return fmt.Errorf("The ROM doesn't match the movie: SHA-1 is %s, the movie was recorded with %s", hash, m.ROMSHA1)
+	}
+	if m.ClockSpeed > 0 {
+		vm.clockSpeed = m.ClockSpeed
+	}
+	if m.InstructionsPerFrame > 0 {
+		vm.ipf = m.InstructionsPerFrame
+	}
+	vm.quirks = m.Quirks
+	vm.rng.Seed(m.Seed)
+	vm.Reset()
+
+	sort.SliceStable(m.Events, func(i, j int) bool { return m.Events[i].Cycle < m.Events[j].Cycle })
+	p := &moviePlayer{movie: m, frames: map[uint64]uint32{}, delivered: noCycle}
+	for _, c := range m.Checkpoints {
+		p.frames[c.Cycle] = c.Frame
+	}
+	vm.player = p
+	return nil
+}
+
+//Runs a replay to the end of the movie as fast as possible, without
+//drawing anything, and reports whether it matched the recording
+func (vm *Machine) RunReplay() error {
+	if vm.player == nil {
+		return errors.New("No movie is being replayed")
+	}
+	for {
+		vm.handleKeyInput()
+		if vm.cycles >= vm.player.movie.Cycles || vm.exited {
+			break
+		}
+		vm.Step()
+	}
+	return vm.FinishReplay()
+}
+
+//Stops replaying. Returns the first place the display differed from the
+//recording, or an error if the movie didn't get to its end
+func (vm *Machine) FinishReplay() error {
+	p := vm.player
+	if p == nil {
+		return errors.New("No movie is being replayed")
+	}
+	vm.player = nil
+	if p.err != nil {
+		return p.err
+	}
+	if vm.cycles < p.movie.Cycles {
+		return fmt.Errorf("Replay stopped at cycle %d of %d", vm.cycles, p.movie.Cycles)
+	}
+	return nil
+}
+
+//Pauses Run once a replay gets to the end of its movie
+func (vm *Machine) checkReplayEnd() {
+	p := vm.player
+	if p == nil || p.finished || vm.cycles < p.movie.Cycles {
+		return
+	}
+	p.finished = true
+	vm.paused = true
+	fmt.Printf("\nReplay finished after %d cycles\n", vm.cycles)
+}
@@ -0,0 +1,92 @@
+package chip8
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//Plays tetris for a while, pressing keys as it goes, and returns the movie
+func recordTestMovie(t *testing.T, rom []byte) *Movie {
+	t.Helper()
+	keys := NewHeadless()
+	vm := newTestMachine(t, rom, WithFrontend(keys))
+	vm.Record(42)
+	for frame := 0; frame < 600; frame++ {
+		switch frame % 40 {
+		case 10:
+			keys.PushKey(0x5, true)
+		case 12:
+			keys.PushKey(0x5, false)
+		case 25:
+			keys.PushKey(0x6, true)
+		case 30:
+			keys.PushKey(0x6, false)
+		}
+		vm.RunFrame()
+	}
+	return vm.StopRecording()
+}
+
+func TestMovieReplay(t *testing.T) {
+	rom := readTestROM(t, "tetris.ch8")
+	m := recordTestMovie(t, rom)
+	if len(m.Events) == 0 || len(m.Checkpoints) < 2 {
+		t.Fatalf("Movie has %d events and %d checkpoints", len(m.Events), len(m.Checkpoints))
+	}
+
+	//Through a file, as the replay command does
+	path := filepath.Join(t.TempDir(), "tetris.movie")
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMovie(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//A different seed to start with, which Replay has to replace
+	vm := newTestMachine(t, rom, WithRNG(NewRandRNG(7)))
+	if err := vm.Replay(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.RunReplay(); err != nil {
+		t.Errorf("RunReplay() = %v", err)
+	}
+	if vm.Cycles() != m.Cycles {
+		t.Errorf("Replay ended at cycle %d, want %d", vm.Cycles(), m.Cycles)
+	}
+}
+
+func TestMovieReplayErrors(t *testing.T) {
+	rom := readTestROM(t, "tetris.ch8")
+	tests := []struct {
+		name   string
+		modify func(m *Movie)
+		err    string
+	}{
+		{"wrong checksum", func(m *Movie) {
+			m.Checkpoints[len(m.Checkpoints)-1].Frame ^= 1
+		}, "Display differs from the recording"},
+		{"missing key", func(m *Movie) {
+			m.Events = m.Events[:len(m.Events)/2]
+		}, "Display differs from the recording"},
+	}
+	for _, test := range tests {
+		m := recordTestMovie(t, rom)
+		test.modify(m)
+		vm := newTestMachine(t, rom)
+		if err := vm.Replay(m); err != nil {
+			t.Fatal(err)
+		}
+		if err := vm.RunReplay(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: RunReplay() = %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	vm := newTestMachine(t, readTestROM(t, "IBM Logo.ch8"))
+	err := vm.Replay(recordTestMovie(t, rom))
+	if err == nil || !strings.Contains(err.Error(), "The ROM doesn't match the movie") {
+		t.Errorf("Replay() with another ROM = %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package chip8
+
+import (
+	"math"
+	"math/rand"
+)
+
+//An Option changes how NewMachine sets up a Machine
+type Option func(*Machine)
+
+//Sets how many instructions are executed per second
+func WithClockSpeed(hz int) Option {
+	return func(vm *Machine) {
+		if hz > 0 {
+			vm.clockSpeed = hz
+		}
+	}
+}
+
+//Sets how many instructions run in each 60Hz frame, overriding the clock
+//speed. The timers count down once a frame whatever this is set to
+func WithInstructionsPerFrame(n int) Option {
+	return func(vm *Machine) {
+		if n > 0 {
+			vm.ipf = n
+		}
+	}
+}
+
+//Sets the behaviour of the ambiguous instructions
+func WithQuirks(q Quirks) Option {
+	return func(vm *Machine) {
+		vm.quirks = q
+	}
+}
+
+//Sets the source CXKK draws random numbers from. Passing a source with a
+//fixed seed makes runs repeatable
+func WithRandSource(src rand.Source) Option {
+	return func(vm *Machine) {
+		vm.rng = &randRNG{r: rand.New(src)}
+	}
+}
+
+//Sets the RNG CXKK draws random numbers from
+func WithRNG(r RNG) Option {
+	return func(vm *Machine) {
+		if r != nil {
+			vm.rng = r
+		}
+	}
+}
+
+//Sets the buzzer played while the sound timer runs. The volume is kept
+//between 0 and 1
+func WithTone(t Tone) Option {
+	return func(vm *Machine) {
+		t.Volume = math.Max(0, math.Min(1, t.Volume))
+		vm.synth.tone = t
+	}
+}
+
+//Sends the machine's sound to sink
+func WithAudio(sink AudioSink) Option {
+	return func(vm *Machine) {
+		vm.audio = sink
+	}
+}
+
+//Sets where the screenshot and record hotkeys save to, as prefix-CYCLE.png
+//or .gif, and the size and colours of screenshots and videos
+func WithScreenshots(prefix string, scale int, p Palette) Option {
+	return func(vm *Machine) {
+		vm.screenshotPrefix = prefix
+		if scale > 0 {
+			vm.screenshotScale = scale
+		}
+		vm.palette = p
+	}
+}
+
+//Sets where the display is drawn and the keypad is read from
+func WithFrontend(f Frontend) Option {
+	return func(vm *Machine) {
+		if f != nil {
+			vm.display = f
+		}
+	}
+}
+
+//Sets the file the save and load state hotkeys use
+func WithStateFile(path string) Option {
+	return func(vm *Machine) {
+		vm.stateFile = path
+	}
+}
+
+//Keeps up to memory bytes of snapshots, one per frame, so the machine can
+//be stepped backwards with Rewind or the rewind hotkey. 0 disables it
+func WithRewind(memory int) Option {
+	return func(vm *Machine) {
+		if memory > 0 {
+			vm.rewind = newRewindBuffer(memory)
+		} else {
+			vm.rewind = nil
+		}
+	}
+}
+
+//Prints the registers to the console after every instruction
+func WithDebug(debug bool) Option {
+	return func(vm *Machine) {
+		vm.debug = debug
+	}
+}
+
+//Records every instruction executed to t
+func WithTrace(t *Tracer) Option {
+	return func(vm *Machine) {
+		vm.tracer = t
+	}
+}
@@ -0,0 +1,117 @@
+package chip8
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//Quirks selects between the behaviours that CHIP-8 interpreters disagree on.
+//Use one of the platform presets below unless a ROM needs something unusual
+type Quirks struct {
+	//8XY6/8XYE shift VX in place instead of shifting VY into VX
+	ShiftVX bool
+
+	//BNNN is read as BXNN and jumps to XNN + VX instead of NNN + V0
+	JumpVX bool
+
+	//FX55/FX65 leave I pointing just past the last register they touched
+	LoadStoreIncrementsI bool
+
+	//FX55/FX65 add X to I rather than X + 1 (CHIP-48's off by one).
+	//Only used alongside LoadStoreIncrementsI
+	LoadStoreIncrementsByX bool
+
+	//8XY1, 8XY2 and 8XY3 reset VF to 0
+	LogicResetsVF bool
+
+	//Sprites are cut off at the edges of the screen instead of wrapping
+	//around to the other side
+	Clip bool
+
+	//ROMs can fill XO-CHIP's 64K of memory instead of the usual 4K
+	Memory64K bool
+}
+
+//The original interpreter for the RCA COSMAC VIP (1977)
+var QuirksCOSMACVIP = Quirks{
+	LoadStoreIncrementsI: true,
+	LogicResetsVF:        true,
+	Clip:                 true,
+}
+
+//CHIP-48 for the HP-48 calculators (1990)
+var QuirksCHIP48 = Quirks{
+	ShiftVX:                true,
+	JumpVX:                 true,
+	LoadStoreIncrementsI:   true,
+	LoadStoreIncrementsByX: true,
+	Clip:                   true,
+}
+
+//SUPER-CHIP 1.1 for the HP-48 calculators (1991)
+var QuirksSuperChip = Quirks{
+	ShiftVX: true,
+	JumpVX:  true,
+	Clip:    true,
+}
+
+//XO-CHIP, as implemented by Octo (2014)
+var QuirksXOChip = Quirks{
+	LoadStoreIncrementsI: true,
+	Memory64K:            true,
+}
+
+//Quirks a new Machine starts with
+var DefaultQuirks = QuirksCOSMACVIP
+
+//Presets by the names the command line accepts
+var platforms = map[string]Quirks{
+	"vip":    QuirksCOSMACVIP,
+	"chip48": QuirksCHIP48,
+	"schip":  QuirksSuperChip,
+	"xochip": QuirksXOChip,
+}
+
+//Looks up the quirks preset for a platform name such as "vip" or "schip"
+func PlatformQuirks(name string) (Quirks, error) {
+	q, ok := platforms[strings.ToLower(name)]
+	if !ok {
+		return Quirks{}, fmt.Errorf("Unknown platform %q, expected one of: %s", name, strings.Join(Platforms(), ", "))
+	}
+	return q, nil
+}
+
+//Returns the names of all the quirks presets
+func Platforms() []string {
+	names := make([]string, 0, len(platforms))
+	for name := range platforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Returns the register 8XY6/8XYE shifts
+func (vm *Machine) shiftSource() byte {
+	if vm.quirks.ShiftVX {
+		return vm.v[(vm.op&0x0F00)>>8]
+	}
+	return vm.v[(vm.op&0x00F0)>>4]
+}
+
+func (vm *Machine) logicResetVF() {
+	if vm.quirks.LogicResetsVF {
+		vm.v[0xF] = 0
+	}
+}
+
+func (vm *Machine) loadStoreIncrement() {
+	if !vm.quirks.LoadStoreIncrementsI {
+		return
+	}
+	vm.index += (vm.op & 0x0F00) >> 8
+	if !vm.quirks.LoadStoreIncrementsByX {
+		vm.index++
+	}
+}
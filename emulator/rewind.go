@@ -0,0 +1,155 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+//The rewind buffer keeps the newest snapshot in full, and for every older
+//one only the delta that turns its newer neighbour back into it. Stepping
+//backwards is then a single delta away, and when the buffer goes over its
+//memory limit the oldest delta can simply be dropped
+type rewindBuffer struct {
+	//Encoded machineState of the newest snapshot
+	latest []byte
+
+	//Ring of reverse deltas, oldest at head
+	deltas [][]byte
+	head   int
+	count  int
+
+	//Bytes used by latest and the deltas, and the most allowed
+	size  int
+	limit int
+}
+
+func newRewindBuffer(limit int) *rewindBuffer {
+	return &rewindBuffer{limit: limit}
+}
+
+//Adds a snapshot, dropping the oldest ones if the buffer is full
+func (rb *rewindBuffer) push(state []byte) {
+	if rb.latest != nil {
+		rb.append(diffState(state, rb.latest))
+		rb.size -= len(rb.latest)
+	}
+	rb.latest = state
+	rb.size += len(state)
+
+	for rb.size > rb.limit && rb.count > 0 {
+		rb.size -= len(rb.deltas[rb.head])
+		rb.deltas[rb.head] = nil
+		rb.head = (rb.head + 1) % len(rb.deltas)
+		rb.count--
+	}
+}
+
+func (rb *rewindBuffer) append(delta []byte) {
+	if rb.count == len(rb.deltas) {
+		//Grow the ring, unrolling it so head is back at 0
+		grown := make([][]byte, len(rb.deltas)*2+16)
+		for i := 0; i < rb.count; i++ {
+			grown[i] = rb.deltas[(rb.head+i)%len(rb.deltas)]
+		}
+		rb.deltas = grown
+		rb.head = 0
+	}
+	rb.deltas[(rb.head+rb.count)%len(rb.deltas)] = delta
+	rb.count++
+	rb.size += len(delta)
+}
+
+//Removes the newest snapshot and returns the one before it
+func (rb *rewindBuffer) pop() ([]byte, bool) {
+	if rb.count == 0 {
+		return nil, false
+	}
+	last := (rb.head + rb.count - 1) % len(rb.deltas)
+	delta := rb.deltas[last]
+	rb.deltas[last] = nil
+	rb.count--
+
+	rb.latest = patchState(rb.latest, delta)
+	rb.size -= len(delta)
+	return rb.latest, true
+}
+
+//Encodes the XOR of two equal length states as runs of unchanged bytes and
+//literal XORed bytes: (zero run, literal length, literals...) repeated, with
+//the lengths as uvarints
+func diffState(from, to []byte) []byte {
+	var out []byte
+	for i := 0; i < len(from); {
+		zeros := 0
+		for i+zeros < len(from) && from[i+zeros] == to[i+zeros] {
+			zeros++
+		}
+		i += zeros
+
+		literals := 0
+		for i+literals < len(from) && from[i+literals] != to[i+literals] {
+			literals++
+		}
+		if zeros == 0 && literals == 0 {
+			break
+		}
+
+		out = binary.AppendUvarint(out, uint64(zeros))
+		out = binary.AppendUvarint(out, uint64(literals))
+		for n := 0; n < literals; n++ {
+			out = append(out, from[i+n]^to[i+n])
+		}
+		i += literals
+	}
+	return out
+}
+
+//Applies a delta from diffState, returning a new state
+func patchState(state, delta []byte) []byte {
+	out := append([]byte(nil), state...)
+	r := bytes.NewReader(delta)
+	pos := 0
+	for r.Len() > 0 {
+		zeros, _ := binary.ReadUvarint(r)
+		literals, _ := binary.ReadUvarint(r)
+		pos += int(zeros)
+		for n := 0; n < int(literals); n++ {
+			b, _ := r.ReadByte()
+			out[pos] ^= b
+			pos++
+		}
+	}
+	return out
+}
+
+func (vm *Machine) encodeState() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, vm.captureState())
+	return buf.Bytes()
+}
+
+//Takes a rewind snapshot once per frame, if rewinding is enabled
+func (vm *Machine) recordRewind() {
+	if vm.rewind == nil || vm.cycles%uint64(vm.InstructionsPerFrame()) != 0 {
+		return
+	}
+	vm.rewind.push(vm.encodeState())
+}
+
+//Steps the machine back to the previous rewind snapshot, about a frame
+//earlier. Returns false when rewinding is disabled or there's nothing
+//older left
+func (vm *Machine) Rewind() bool {
+	if vm.rewind == nil {
+		return false
+	}
+	state, ok := vm.rewind.pop()
+	if !ok {
+		return false
+	}
+
+	var st machineState
+	binary.Read(bytes.NewReader(state), binary.BigEndian, &st)
+	vm.restoreState(&st)
+	return true
+}
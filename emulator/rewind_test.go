@@ -0,0 +1,55 @@
+package chip8
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDiffPatchState(t *testing.T) {
+	tests := []struct {
+		from, to []byte
+	}{
+		{[]byte{1, 2, 3, 4}, []byte{1, 2, 3, 4}},
+		{[]byte{1, 2, 3, 4}, []byte{9, 2, 3, 4}},
+		{[]byte{1, 2, 3, 4}, []byte{1, 2, 3, 9}},
+		{[]byte{1, 2, 3, 4, 5, 6}, []byte{1, 9, 9, 4, 9, 6}},
+		{make([]byte, 1000), append(make([]byte, 999), 1)},
+	}
+	for _, test := range tests {
+		delta := diffState(test.from, test.to)
+		if got := patchState(test.to, delta); !bytes.Equal(got, test.from) {
+			t.Errorf("patchState(%v, diffState(%v, %v)) = %v", test.to, test.from, test.to, got)
+		}
+	}
+}
+
+func TestRewind(t *testing.T) {
+	vm := newTestMachine(t, readTestROM(t, "tetris.ch8"), WithRewind(1<<20))
+
+	//States at the end of each of the last few frames
+	var frames []*machineState
+	for frame := 0; frame < 50; frame++ {
+		vm.RunFrame()
+		frames = append(frames, vm.captureState())
+	}
+
+	//The newest snapshot is where the machine is now, so the first rewind
+	//goes back a frame
+	for i := len(frames) - 2; i >= len(frames)-10; i-- {
+		if !vm.Rewind() {
+			t.Fatalf("Rewind() = false with %d frames left", i+1)
+		}
+		if got := vm.captureState(); !reflect.DeepEqual(got, frames[i]) {
+			t.Fatalf("After rewinding to cycle %d the machine is at cycle %d, or differs", frames[i].Cycles, got.Cycles)
+		}
+	}
+}
+
+func TestRewindDisabled(t *testing.T) {
+	vm := newTestMachine(t, readTestROM(t, "tetris.ch8"))
+	vm.RunUntil(1000)
+	if vm.Rewind() {
+		t.Error("Rewind() = true without WithRewind")
+	}
+}
@@ -0,0 +1,90 @@
+package chip8
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+//An RNG supplies the random bytes CXKK masks with KK. Give a machine one
+//with WithRNG
+type RNG interface {
+	//Starts the sequence over from seed
+	Seed(seed int64)
+
+	//Returns the next random byte, any of 0x00 to 0xFF
+	Byte() byte
+}
+
+//Names of the RNG modes ParseRNG takes
+const (
+	RNGDefault = "default"
+	RNGVIP     = "vip"
+)
+
+//Builds the RNG for a mode name. The vip mode needs the COSMAC VIP CHIP-8
+//interpreter image, see NewVIPRNG
+func ParseRNG(name string, interpreter []byte) (RNG, error) {
+	switch strings.ToLower(name) {
+	case RNGDefault:
+		return NewRandRNG(0), nil
+	case RNGVIP:
+		if interpreter == nil {
+			return nil, fmt.Errorf("The %s random mode needs an image of the COSMAC VIP CHIP-8 interpreter", RNGVIP)
+		}
+		return NewVIPRNG(interpreter)
+	}
+	return nil, fmt.Errorf("Unknown random mode %q, expected %s or %s", name, RNGDefault, RNGVIP)
+}
+
+//Random bytes from math/rand
+type randRNG struct {
+	r *rand.Rand
+}
+
+//An RNG backed by math/rand, starting from seed
+func NewRandRNG(seed int64) RNG {
+	return &randRNG{r: rand.New(rand.NewSource(seed))}
+}
+
+func (g *randRNG) Seed(seed int64) {
+	g.r.Seed(seed)
+}
+
+func (g *randRNG) Byte() byte {
+	return byte(g.r.Intn(256))
+}
+
+//The random routine of the original COSMAC VIP interpreter. It keeps a 16
+//bit seed in register R9. Each CXKK steps the low byte, reads the byte of
+//the interpreter's second page at that offset, adds the high byte to it and
+//keeps the sum as the new high byte. That sum is the random number
+type vipRNG struct {
+	table [256]byte
+	r9    uint16
+}
+
+//An RNG that follows the COSMAC VIP interpreter's random routine byte for
+//byte. interpreter is the 512 byte CHIP-8 interpreter as loaded at 0x000
+//on the VIP, since the routine reads its own code as a table. It isn't
+//included here, so it has to come from a dump of a VIP
+func NewVIPRNG(interpreter []byte) (RNG, error) {
+	if len(interpreter) < 0x200 {
+		return nil, fmt.Errorf("The COSMAC VIP interpreter image is %d bytes, expected 512", len(interpreter))
+	}
+	g := &vipRNG{}
+	copy(g.table[:], interpreter[0x100:0x200])
+	return g, nil
+}
+
+//Sets R9 to the low 16 bits of seed
+func (g *vipRNG) Seed(seed int64) {
+	g.r9 = uint16(seed)
+}
+
+func (g *vipRNG) Byte() byte {
+	lo := byte(g.r9) + 1
+	hi := g.table[lo] + byte(g.r9>>8)
+	g.r9 = uint16(hi)<<8 | uint16(lo)
+	return hi
+}
@@ -0,0 +1,133 @@
+package chip8
+
+//SUPER-CHIP 1.1 additions: the 128x64 hires mode, scrolling, the big hex
+//font and the RPL user flags
+
+const (
+	loresWidth  = 64
+	loresHeight = 32
+	hiresWidth  = 128
+	hiresHeight = 64
+
+	//Where the 8x10 font is kept, straight after the 4x5 one
+	bigFontStart = 0x50
+)
+
+//Format of the big fontset, 10 bytes per digit
+var bigFontSet = []uint8{
+	0xFF, 0xFF, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, //0
+	0x18, 0x78, 0x78, 0x18, 0x18, 0x18, 0x18, 0x18, 0xFF, 0xFF, //1
+	0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, //2
+	0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, //3
+	0xC3, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, 0x03, 0x03, 0x03, 0x03, //4
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, //5
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, //6
+	0xFF, 0xFF, 0x03, 0x03, 0x06, 0x0C, 0x18, 0x18, 0x18, 0x18, //7
+	0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, //8
+	0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, //9
+	0x7E, 0xFF, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xC3, //A
+	0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC, //B
+	0x3C, 0xFF, 0xC3, 0xC0, 0xC0, 0xC0, 0xC0, 0xC3, 0xFF, 0x3C, //C
+	0xFC, 0xFE, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFE, 0xFC, //D
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, //E
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xC0, 0xC0, //F
+}
+
+//A copy of the display at its current resolution. Pix holds one byte per
+//pixel, row by row. Bit 0 is set when the pixel is lit in the first plane
+//and bit 1 when it's lit in the second (XO-CHIP only), so each byte is an
+//index into a Palette
+type Framebuffer struct {
+	Width  int
+	Height int
+	Pix    []byte
+}
+
+//Returns the value of the pixel at (x, y)
+func (fb Framebuffer) At(x, y int) byte {
+	return fb.Pix[y*fb.Width+x]
+}
+
+//Width of the display in the current mode
+func (vm *Machine) width() int {
+	if vm.hires {
+		return hiresWidth
+	}
+	return loresWidth
+}
+
+//Height of the display in the current mode
+func (vm *Machine) height() int {
+	if vm.hires {
+		return hiresHeight
+	}
+	return loresHeight
+}
+
+//Switches between 64x32 and 128x64. Like Octo, every plane is cleared
+func (vm *Machine) setHires(hires bool) {
+	vm.hires = hires
+	vm.gfx = [hiresWidth * hiresHeight]byte{}
+	vm.drawFlag = true
+}
+
+//Clears the selected planes
+func (vm *Machine) clearScreen() {
+	for i := range vm.gfx {
+		vm.gfx[i] &^= vm.plane
+	}
+	vm.drawFlag = true
+}
+
+//Moves the selected planes down n pixels, blanking the rows scrolled in
+//(00CN). A negative n scrolls up, which XO-CHIP's 00DN does
+func (vm *Machine) scrollDown(n int) {
+	vm.scroll(0, n)
+}
+
+//Moves the selected planes n pixels sideways, right when n is positive and
+//left when it's negative (00FB and 00FC)
+func (vm *Machine) scrollSideways(n int) {
+	vm.scroll(n, 0)
+}
+
+func (vm *Machine) scroll(dx, dy int) {
+	w, h := vm.width(), vm.height()
+	scrolled := make([]byte, w*h)
+	for row := 0; row < h; row++ {
+		for col := 0; col < w; col++ {
+			srcRow, srcCol := row-dy, col-dx
+			if srcRow >= 0 && srcRow < h && srcCol >= 0 && srcCol < w {
+				scrolled[row*w+col] = vm.gfx[srcRow*w+srcCol] & vm.plane
+			}
+		}
+	}
+	for i, pix := range scrolled {
+		vm.gfx[i] = vm.gfx[i]&^vm.plane | pix
+	}
+	vm.drawFlag = true
+}
+
+//FX75 saves V0 through VX to the RPL user flags
+func (vm *Machine) saveFlags(x uint16) {
+	for i := uint16(0); i <= x; i++ {
+		vm.rpl[i] = vm.v[i]
+	}
+}
+
+//FX85 loads V0 through VX from the RPL user flags
+func (vm *Machine) loadFlags(x uint16) {
+	for i := uint16(0); i <= x; i++ {
+		vm.v[i] = vm.rpl[i]
+	}
+}
+
+//Reports whether the program has run 00FD, or crashed the machine (see Err)
+func (vm *Machine) Exited() bool {
+	return vm.exited
+}
+
+//Reports whether the display is in 128x64 mode
+func (vm *Machine) Hires() bool {
+	return vm.hires
+}
@@ -0,0 +1,159 @@
+package chip8
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+//Shapes of wave the buzzer can make
+type Waveform int
+
+const (
+	WaveSquare Waveform = iota
+	WaveTriangle
+	WaveSawtooth
+	WaveSine
+)
+
+var waveformNames = map[string]Waveform{
+	"square":   WaveSquare,
+	"triangle": WaveTriangle,
+	"sawtooth": WaveSawtooth,
+	"sine":     WaveSine,
+}
+
+//Names of the waveforms, sorted
+func Waveforms() []string {
+	names := make([]string, 0, len(waveformNames))
+	for name := range waveformNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Looks up a waveform by name
+func ParseWaveform(name string) (Waveform, error) {
+	w, ok := waveformNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("Unknown waveform %q, expected one of %s", name, strings.Join(Waveforms(), ", "))
+	}
+	return w, nil
+}
+
+//Value of the wave at phase, which runs from 0 to 1 over one period.
+//Values run from -1 to 1
+func (w Waveform) at(phase float64) float64 {
+	switch w {
+	case WaveTriangle:
+		return 4*math.Abs(phase-0.5) - 1
+	case WaveSawtooth:
+		return 2*phase - 1
+	case WaveSine:
+		return math.Sin(2 * math.Pi * phase)
+	}
+	if phase < 0.5 {
+		return 1
+	}
+	return -1
+}
+
+//The buzzer that sounds while the sound timer is running, unless an
+//XO-CHIP program has loaded its own audio pattern
+type Tone struct {
+	//In Hz
+	Frequency float64
+
+	Waveform Waveform
+
+	//From 0 for silent to 1 for full scale. Also used for XO-CHIP patterns
+	Volume float64
+}
+
+//A quiet 440Hz square wave, close to the VIP's buzzer
+var DefaultTone = Tone{Frequency: 440, Waveform: WaveSquare, Volume: 0.25}
+
+//An AudioSink plays or keeps the machine's sound. The machine makes the
+//samples itself, in emulated time, so a sink gets exactly the sound the
+//program made whatever speed it ran at. The audio package has sinks for
+//the speaker, WAV files and nothing at all
+type AudioSink interface {
+	//Samples per second the sink takes
+	SampleRate() int
+
+	//Called at the end of every 60Hz frame with the frame's samples, from
+	//-1 to 1
+	WriteSamples(samples []float64) error
+}
+
+//Turns the sound state into samples, keeping its place in the wave
+//between calls so the sound doesn't click
+type synth struct {
+	tone Tone
+
+	//Position in the tone's period, from 0 to 1
+	phase float64
+
+	//Position in the 128 bit XO-CHIP pattern
+	bit float64
+}
+
+//Fills samples with sound at rate samples per second. The caller holds
+//snd.mu
+func (s *synth) fill(samples []float64, rate float64, snd *soundState) {
+	toneStep := s.tone.Frequency / rate
+	patternStep := patternRate(snd.pitch) / rate
+	for i := range samples {
+		val := 0.0
+		switch {
+		case !snd.playing:
+		case snd.custom:
+			bit := int(s.bit) % 128
+			if snd.pattern[bit/8]&(0x80>>(bit%8)) != 0 {
+				val = s.tone.Volume
+			} else {
+				val = -s.tone.Volume
+			}
+		default:
+			val = s.tone.Volume * s.tone.Waveform.at(s.phase)
+		}
+		samples[i] = val
+		s.phase = math.Mod(s.phase+toneStep, 1)
+		s.bit = math.Mod(s.bit+patternStep, 128)
+	}
+}
+
+//Makes the sound for the instruction that just ran. Each instruction gets
+//an equal share of its frame's samples, so the sound starts and stops on
+//the instruction that changed the sound timer
+func (vm *Machine) makeSound() {
+	rate := uint64(vm.audio.SampleRate())
+	target := vm.cycles * rate / uint64(60*vm.InstructionsPerFrame())
+
+	//Rewinding or loading a state moves the clock, so pick up from there
+	//rather than making up the difference
+	if target < vm.samples || target-vm.samples > rate {
+		vm.samples = target
+	}
+
+	n := int(target - vm.samples)
+	start := len(vm.audioBuf)
+	for i := 0; i < n; i++ {
+		vm.audioBuf = append(vm.audioBuf, 0)
+	}
+	vm.sound.mu.Lock()
+	vm.synth.fill(vm.audioBuf[start:], float64(rate), &vm.sound)
+	vm.sound.mu.Unlock()
+	vm.samples = target
+}
+
+//Hands the frame's sound to the sink
+func (vm *Machine) flushSound() {
+	if err := vm.audio.WriteSamples(vm.audioBuf); err != nil && !vm.audioFailed {
+		fmt.Printf("\nError writing audio: %v\n", err)
+		vm.audioFailed = true
+	}
+	vm.audioBuf = vm.audioBuf[:0]
+}
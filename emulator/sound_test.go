@@ -0,0 +1,90 @@
+package chip8
+
+import (
+	"math"
+	"testing"
+)
+
+//Keeps every sample it's given
+type recordingSink struct {
+	rate    int
+	writes  []int
+	samples []float64
+}
+
+func (s *recordingSink) SampleRate() int {
+	return s.rate
+}
+
+func (s *recordingSink) WriteSamples(samples []float64) error {
+	s.writes = append(s.writes, len(samples))
+	s.samples = append(s.samples, samples...)
+	return nil
+}
+
+func TestSoundTiming(t *testing.T) {
+	//V0 := 10, ST := V0, then loop. At 10 instructions a frame and 600
+	//samples a second there's a sample per instruction, and the sound
+	//timer runs out 10 frames after it was set, at the end of cycle 100
+	rom := []byte{0x60, 0x0A, 0xF0, 0x18, 0x12, 0x04}
+	sink := &recordingSink{rate: 600}
+	vm := newTestMachine(t, rom, WithAudio(sink), WithInstructionsPerFrame(10))
+	vm.RunUntil(200)
+
+	if len(sink.writes) != 20 {
+		t.Fatalf("Sink written %d times in 20 frames, want 20", len(sink.writes))
+	}
+	for i, n := range sink.writes {
+		if n != 10 {
+			t.Fatalf("Frame %d had %d samples, want 10", i, n)
+		}
+	}
+
+	//Sample n is made by instruction n+1, so the sound starts with the
+	//second instruction and stops after the 100th
+	for i, s := range sink.samples {
+		playing := i >= 1 && i < 100
+		if playing && s == 0 {
+			t.Fatalf("Sample %d is silent, want sound from samples 1 to 99", i)
+		}
+		if !playing && s != 0 {
+			t.Fatalf("Sample %d is %v, want silence outside samples 1 to 99", i, s)
+		}
+	}
+}
+
+func TestSoundTone(t *testing.T) {
+	rom := []byte{0x60, 0xFF, 0xF0, 0x18, 0x12, 0x04}
+	for _, waveform := range []Waveform{WaveSquare, WaveTriangle, WaveSawtooth, WaveSine} {
+		sink := &recordingSink{rate: 44100}
+		tone := Tone{Frequency: 441, Waveform: waveform, Volume: 0.5}
+		vm := newTestMachine(t, rom, WithAudio(sink), WithTone(tone), WithInstructionsPerFrame(10))
+		vm.RunUntil(100)
+
+		//The first instruction's share of the frame is silent. After that,
+		//a whole number of periods should be centred on zero
+		lo, hi, sum := 1.0, -1.0, 0.0
+		for _, s := range sink.samples[441 : 441+4400] {
+			lo = math.Min(lo, s)
+			hi = math.Max(hi, s)
+			sum += s
+		}
+		if mean := sum / 4400; math.Abs(mean) > 0.01 {
+			t.Errorf("Waveform %d averages %v, want 0", waveform, mean)
+		}
+		//At 100 samples a period no wave gets right to its peaks, but they
+		//all get close
+		if lo < -0.5 || hi > 0.5 || hi-lo < 0.95 {
+			t.Errorf("Waveform %d runs from %v to %v, want about -0.5 to 0.5", waveform, lo, hi)
+		}
+	}
+}
+
+func TestParseWaveform(t *testing.T) {
+	if w, err := ParseWaveform("Sine"); err != nil || w != WaveSine {
+		t.Errorf("ParseWaveform(\"Sine\") = %d, %v, want %d", w, err, WaveSine)
+	}
+	if _, err := ParseWaveform("noise"); err == nil {
+		t.Error("ParseWaveform(\"noise\") succeeded, want an error")
+	}
+}
@@ -0,0 +1,185 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+//Save states are laid out as:
+//
+//	magic    [4]byte  "CH8S"
+//	version  uint16
+//	length   uint32   size of the payload in bytes
+//	payload  machineState, big endian
+//	checksum uint32   CRC-32 (IEEE) of the payload
+const (
+	stateMagic   = "CH8S"
+	stateVersion = 1
+)
+
+//Everything needed to put a Machine back exactly where it was. The fields
+//are all fixed size so encoding/binary can write it in one go
+type machineState struct {
+	Mem           [65536]byte
+	V             [16]byte
+	I             uint16
+	PC            uint16
+	Op            uint16
+	Stack         [16]uint16
+	SP            uint16
+	DelayTime     byte
+	SoundTime     byte
+	Gfx           [hiresWidth * hiresHeight]byte
+	Hires         bool
+	Exited        bool
+	Plane         byte
+	RPL           [16]byte
+	Key           [16]byte
+	Cycles        uint64
+	Pattern       [16]byte
+	Pitch         byte
+	CustomPattern bool
+}
+
+func (vm *Machine) captureState() *machineState {
+	vm.sound.mu.Lock()
+	defer vm.sound.mu.Unlock()
+	return &machineState{
+		Mem:           vm.mem,
+		V:             vm.v,
+		I:             vm.index,
+		PC:            vm.pc,
+		Op:            vm.op,
+		Stack:         vm.stack,
+		SP:            vm.sp,
+		DelayTime:     vm.delayTime,
+		SoundTime:     vm.soundTime,
+		Gfx:           vm.gfx,
+		Hires:         vm.hires,
+		Exited:        vm.exited,
+		Plane:         vm.plane,
+		RPL:           vm.rpl,
+		Key:           vm.key,
+		Cycles:        vm.cycles,
+		Pattern:       vm.sound.pattern,
+		Pitch:         vm.sound.pitch,
+		CustomPattern: vm.sound.custom,
+	}
+}
+
+func (vm *Machine) restoreState(st *machineState) {
+	vm.mem = st.Mem
+	vm.v = st.V
+	vm.index = st.I
+	vm.pc = st.PC
+	vm.op = st.Op
+	vm.stack = st.Stack
+	vm.sp = st.SP
+	vm.delayTime = st.DelayTime
+	vm.soundTime = st.SoundTime
+	vm.gfx = st.Gfx
+	vm.hires = st.Hires
+	vm.exited = st.Exited
+	vm.plane = st.Plane
+	vm.rpl = st.RPL
+	vm.key = st.Key
+	vm.cycles = st.Cycles
+	vm.drawFlag = true
+
+	vm.sound.mu.Lock()
+	vm.sound.pattern = st.Pattern
+	vm.sound.pitch = st.Pitch
+	vm.sound.custom = st.CustomPattern
+	vm.sound.playing = st.SoundTime > 0
+	vm.sound.mu.Unlock()
+}
+
+//Writes the whole machine to w in the save state format
+func (vm *Machine) SaveState(w io.Writer) error {
+	var payload bytes.Buffer
+	if err := binary.Write(&payload, binary.BigEndian, vm.captureState()); err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, 10)
+	header = append(header, stateMagic...)
+	header = binary.BigEndian.AppendUint16(header, stateVersion)
+	header = binary.BigEndian.AppendUint32(header, uint32(payload.Len()))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload.Bytes()); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, crc32.ChecksumIEEE(payload.Bytes()))
+}
+
+//Replaces the whole machine with a state written by SaveState. The machine
+//is left untouched if the state can't be read
+func (vm *Machine) LoadState(r io.Reader) error {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("Error reading save state header: %v", err)
+	}
+	if string(header[:4]) != stateMagic {
+		return errors.New("Not a CHIP-8 save state")
+	}
+	if version := binary.BigEndian.Uint16(header[4:6]); version != stateVersion {
+		return fmt.Errorf("Unsupported save state version %d", version)
+	}
+
+	var st machineState
+	length := binary.BigEndian.Uint32(header[6:10])
+	if int(length) != binary.Size(st) {
+		return fmt.Errorf("Save state payload is %d bytes, expected %d", length, binary.Size(st))
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return fmt.Errorf("Error reading save state: %v", err)
+	}
+	var checksum uint32
+	if err := binary.Read(r, binary.BigEndian, &checksum); err != nil {
+		return fmt.Errorf("Error reading save state checksum: %v", err)
+	}
+	if checksum != crc32.ChecksumIEEE(payload) {
+		return errors.New("Save state is corrupt: checksum mismatch")
+	}
+
+	if err := binary.Read(bytes.NewReader(payload), binary.BigEndian, &st); err != nil {
+		return err
+	}
+	if int(st.SP) >= len(st.Stack) {
+		return fmt.Errorf("Save state has a stack pointer of %d, past the %d entry stack", st.SP, len(st.Stack))
+	}
+	vm.restoreState(&st)
+	return nil
+}
+
+//Saves the machine to a file, replacing it if it exists
+func (vm *Machine) SaveStateFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := vm.SaveState(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//Loads the machine from a file written by SaveStateFile
+func (vm *Machine) LoadStateFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return vm.LoadState(f)
+}
@@ -0,0 +1,98 @@
+package chip8
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	rom := readTestROM(t, "tetris.ch8")
+	vm := newTestMachine(t, rom)
+	vm.RunUntil(5000)
+
+	var saved bytes.Buffer
+	if err := vm.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	want := vm.captureState()
+
+	//Carrying on changes the machine, loading puts it back
+	vm.RunUntil(8000)
+	if err := vm.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := vm.captureState(); !reflect.DeepEqual(got, want) {
+		t.Error("Loading into the same machine didn't restore it")
+	}
+
+	other := newTestMachine(t, rom)
+	if err := other.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := other.captureState(); !reflect.DeepEqual(got, want) {
+		t.Error("Loading into a new machine didn't restore it")
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	vm := newTestMachine(t, readTestROM(t, "IBM Logo.ch8"))
+	vm.RunUntil(100)
+	var buf bytes.Buffer
+	if err := vm.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+	const header = 10
+
+	tests := []struct {
+		name   string
+		modify func(state []byte) []byte
+		err    string
+	}{
+		{"bad crc", func(s []byte) []byte {
+			s[header+0x200] ^= 0xFF
+			return s
+		}, "checksum mismatch"},
+		{"bad magic", func(s []byte) []byte {
+			s[0] = 'X'
+			return s
+		}, "Not a CHIP-8 save state"},
+		{"bad version", func(s []byte) []byte {
+			s[5] = 9
+			return s
+		}, "Unsupported save state version 9"},
+		{"truncated", func(s []byte) []byte {
+			return s[:len(s)-100]
+		}, "Error reading save state"},
+		{"empty", func(s []byte) []byte {
+			return nil
+		}, "Error reading save state header"},
+	}
+	for _, test := range tests {
+		before := vm.captureState()
+		state := test.modify(append([]byte(nil), good...))
+		err := vm.LoadState(bytes.NewReader(state))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: LoadState() = %v, want %q", test.name, err, test.err)
+		}
+		if !reflect.DeepEqual(vm.captureState(), before) {
+			t.Errorf("%s: failed load changed the machine", test.name)
+		}
+	}
+}
+
+func TestLoadStateBadStackPointer(t *testing.T) {
+	vm := newTestMachine(t, nil)
+	vm.sp = 16
+	var buf bytes.Buffer
+	if err := vm.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	vm.sp = 0
+	err := vm.LoadState(&buf)
+	if err == nil || !strings.Contains(err.Error(), "stack pointer of 16") {
+		t.Errorf("LoadState() = %v, want a stack pointer error", err)
+	}
+}
@@ -0,0 +1,347 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//How a Tracer writes its records
+type TraceFormat int
+
+const (
+	//One line per instruction, readable and easy to diff
+	TraceText TraceFormat = iota
+
+	//Variable length binary records, a few bytes per instruction
+	TraceBinary
+)
+
+//Looks up a trace format by name, "text" or "binary"
+func ParseTraceFormat(name string) (TraceFormat, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return TraceText, nil
+	case "binary":
+		return TraceBinary, nil
+	}
+	return 0, fmt.Errorf("Unknown trace format %q, expected text or binary", name)
+}
+
+//Every opcode class with the instructions in it, for filtering traces
+var opcodeClasses = map[string][]Opcode{
+	"flow":    {OpJP, OpCALL, OpRET, OpJPV0, OpEXIT},
+	"skip":    {OpSEByte, OpSNEByte, OpSEReg, OpSNEReg},
+	"input":   {OpSKP, OpSKNP, OpLDVxK},
+	"alu":     {OpLDByte, OpADDByte, OpLDReg, OpOR, OpAND, OpXOR, OpADDReg, OpSUB, OpSHR, OpSUBN, OpSHL, OpRND},
+	"memory":  {OpLDI, OpLDILong, OpADDI, OpLDF, OpLDHF, OpLDB, OpStore, OpLoad, OpSaveRange, OpLoadRange, OpSaveFlags, OpLoadFlags},
+	"display": {OpCLS, OpDRW, OpSCD, OpSCU, OpSCR, OpSCL, OpLOW, OpHIGH, OpPLANE},
+	"timer":   {OpLDVxDT, OpLDDTVx, OpLDSTVx},
+	"sound":   {OpAUDIO, OpPITCH},
+	"invalid": {OpInvalid},
+}
+
+//Names of the opcode classes, sorted
+func OpcodeClasses() []string {
+	names := make([]string, 0, len(opcodeClasses))
+	for name := range opcodeClasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Which instructions get traced. The zero value traces everything
+type TraceFilter struct {
+	//Only instructions at Start to End inclusive, unless both are zero
+	Start, End uint16
+
+	//Only instructions in these classes, unless empty
+	Ops map[Opcode]bool
+}
+
+//Parses a PC range for a TraceFilter: START-END or START+LENGTH
+func ParseTraceRange(s string) (start, end uint16, err error) {
+	return parseRange(s)
+}
+
+//Turns a comma separated list of opcode classes into the set of opcodes in
+//them
+func ParseOpcodeClasses(list string) (map[Opcode]bool, error) {
+	ops := map[Opcode]bool{}
+	for _, name := range strings.Split(list, ",") {
+		class, ok := opcodeClasses[strings.TrimSpace(strings.ToLower(name))]
+		if !ok {
+			return nil, fmt.Errorf("Unknown opcode class %q, expected one of %s", name, strings.Join(OpcodeClasses(), ", "))
+		}
+		for _, op := range class {
+			ops[op] = true
+		}
+	}
+	return ops, nil
+}
+
+func (f TraceFilter) matches(pc uint16, ins Instruction) bool {
+	if (f.Start != 0 || f.End != 0) && (pc < f.Start || pc > f.End) {
+		return false
+	}
+	return len(f.Ops) == 0 || f.Ops[ins.Op]
+}
+
+//What an instruction changed. Registers are numbered with the Trace
+//register constants
+type TraceChange struct {
+	Mem   bool
+	Reg   int
+	Addr  uint16
+	Value uint16
+}
+
+//Registers in TraceChange.Reg besides V0-VF, which are 0-15
+const (
+	TraceI = 16 + iota
+	TraceSP
+	TraceDT
+	TraceST
+)
+
+func (c TraceChange) String() string {
+	switch {
+	case c.Mem:
+		return fmt.Sprintf("[%04X]=%02X", c.Addr, c.Value)
+	case c.Reg < 16:
+		return fmt.Sprintf("V%X=%02X", c.Reg, c.Value)
+	case c.Reg == TraceI:
+		return fmt.Sprintf("I=%04X", c.Value)
+	case c.Reg == TraceSP:
+		return fmt.Sprintf("SP=%X", c.Value)
+	case c.Reg == TraceDT:
+		return fmt.Sprintf("DT=%02X", c.Value)
+	}
+	return fmt.Sprintf("ST=%02X", c.Value)
+}
+
+//One executed instruction
+type TraceRecord struct {
+	Cycle       uint64
+	PC          uint16
+	Instruction Instruction
+	Changes     []TraceChange
+}
+
+//Formats the record as a line of the text format, without the newline
+func (r TraceRecord) String() string {
+	raw := fmt.Sprintf("%04X", r.Instruction.Raw)
+	if r.Instruction.Size == 4 {
+		raw += fmt.Sprintf("%04X", r.Instruction.NNN)
+	}
+	changes := make([]string, len(r.Changes))
+	for i, c := range r.Changes {
+		changes[i] = c.String()
+	}
+	line := fmt.Sprintf("%10d  %04X  %-8s  %-20s  %s", r.Cycle, r.PC, raw, r.Instruction, strings.Join(changes, " "))
+	return strings.TrimRight(line, " ")
+}
+
+//Magic number and version at the start of binary traces
+var traceMagic = []byte("CH8T")
+
+const traceVersion = 1
+
+//A Tracer writes a record of every instruction the machine executes. Add
+//one with WithTrace, and call Flush once the machine has stopped
+type Tracer struct {
+	w      *bufio.Writer
+	format TraceFormat
+	filter TraceFilter
+	err    error
+
+	//The record being built for the current instruction, if it's traced
+	active    bool
+	record    TraceRecord
+	regs      [20]uint16
+	lastCycle uint64
+}
+
+func NewTracer(w io.Writer, format TraceFormat, filter TraceFilter) *Tracer {
+	t := &Tracer{w: bufio.NewWriter(w), format: format, filter: filter}
+	if format == TraceBinary {
+		t.w.Write(traceMagic)
+		t.w.WriteByte(traceVersion)
+	}
+	return t
+}
+
+//Writes out anything buffered. Returns the first error the tracer had
+func (t *Tracer) Flush() error {
+	if err := t.w.Flush(); t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+func (vm *Machine) traceRegisters() (regs [20]uint16) {
+	for i, v := range vm.v {
+		regs[i] = uint16(v)
+	}
+	regs[TraceI] = vm.index
+	regs[TraceSP] = vm.sp
+	regs[TraceDT] = uint16(vm.delayTime)
+	regs[TraceST] = uint16(vm.soundTime)
+	return regs
+}
+
+//Called before FDE with the instruction about to run
+func (t *Tracer) before(vm *Machine) {
+	ins := decodeAt(vm.mem[:], vm.pc)
+	t.active = t.filter.matches(vm.pc, ins)
+	if !t.active {
+		return
+	}
+	t.record = TraceRecord{Cycle: vm.cycles, PC: vm.pc, Instruction: ins}
+	t.regs = vm.traceRegisters()
+}
+
+//Called from writeMem while an instruction runs
+func (t *Tracer) memWrite(addr uint16, val byte) {
+	if t.active {
+		t.record.Changes = append(t.record.Changes, TraceChange{Mem: true, Addr: addr, Value: uint16(val)})
+	}
+}
+
+//Called after FDE to write the record
+func (t *Tracer) after(vm *Machine) {
+	if !t.active {
+		return
+	}
+	t.active = false
+
+	//Registers first, then memory in the order it was written
+	mem := t.record.Changes
+	t.record.Changes = nil
+	for i, val := range vm.traceRegisters() {
+		if val != t.regs[i] {
+			t.record.Changes = append(t.record.Changes, TraceChange{Reg: i, Value: val})
+		}
+	}
+	t.record.Changes = append(t.record.Changes, mem...)
+
+	if t.format == TraceText {
+		_, err := fmt.Fprintln(t.w, t.record)
+		t.fail(err)
+		return
+	}
+	t.fail(t.writeBinary(t.record))
+}
+
+func (t *Tracer) fail(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+//A binary record is the cycle as a uvarint delta from the previous record,
+//PC and the opcode as big endian words (two words for F000 NNNN), then the
+//number of changes and each change. A change is a byte with the register
+//number, or 0xFF for memory followed by the address word, then the value as
+//a uvarint
+func (t *Tracer) writeBinary(r TraceRecord) error {
+	var buf []byte
+	buf = binary.AppendUvarint(buf, r.Cycle-t.lastCycle)
+	t.lastCycle = r.Cycle
+	buf = binary.BigEndian.AppendUint16(buf, r.PC)
+	buf = append(buf, r.Instruction.Encode()...)
+	buf = binary.AppendUvarint(buf, uint64(len(r.Changes)))
+	for _, c := range r.Changes {
+		if c.Mem {
+			buf = append(buf, 0xFF)
+			buf = binary.BigEndian.AppendUint16(buf, c.Addr)
+		} else {
+			buf = append(buf, byte(c.Reg))
+		}
+		buf = binary.AppendUvarint(buf, uint64(c.Value))
+	}
+	_, err := t.w.Write(buf)
+	return err
+}
+
+//Reads back the records of a binary trace
+type TraceReader struct {
+	r         *bufio.Reader
+	lastCycle uint64
+}
+
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(traceMagic)]) != string(traceMagic) {
+		return nil, errors.New("Not a binary CHIP-8 trace")
+	}
+	if header[len(traceMagic)] != traceVersion {
+		return nil, fmt.Errorf("Unsupported trace version %d", header[len(traceMagic)])
+	}
+	return &TraceReader{r: br}, nil
+}
+
+//Returns the next record, or io.EOF after the last one
+func (tr *TraceReader) Next() (TraceRecord, error) {
+	var r TraceRecord
+	delta, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return r, err
+	}
+
+	//Anything missing past the first byte means the trace was cut short
+	truncated := func(err error) (TraceRecord, error) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return TraceRecord{}, err
+	}
+
+	tr.lastCycle += delta
+	r.Cycle = tr.lastCycle
+	var words [2]uint16
+	if err := binary.Read(tr.r, binary.BigEndian, &words); err != nil {
+		return truncated(err)
+	}
+	r.PC = words[0]
+	var next uint16
+	if words[1] == 0xF000 {
+		if err := binary.Read(tr.r, binary.BigEndian, &next); err != nil {
+			return truncated(err)
+		}
+	}
+	r.Instruction = Decode(words[1], next)
+
+	count, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return truncated(err)
+	}
+	for i := uint64(0); i < count; i++ {
+		var c TraceChange
+		kind, err := tr.r.ReadByte()
+		if err != nil {
+			return truncated(err)
+		}
+		if kind == 0xFF {
+			c.Mem = true
+			if err := binary.Read(tr.r, binary.BigEndian, &c.Addr); err != nil {
+				return truncated(err)
+			}
+		} else {
+			c.Reg = int(kind)
+		}
+		value, err := binary.ReadUvarint(tr.r)
+		if err != nil {
+			return truncated(err)
+		}
+		c.Value = uint16(value)
+		r.Changes = append(r.Changes, c)
+	}
+	return r, nil
+}
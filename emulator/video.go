@@ -0,0 +1,246 @@
+package chip8
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//A VideoWriter records the display. It's handed the display once for every
+//60Hz frame the machine runs, so a clip plays back at the speed the game
+//ran at
+type VideoWriter interface {
+	WriteFrame(fb Framebuffer) error
+
+	//Finishes the video. Nothing can be written after
+	Close() error
+}
+
+//Creates a video file at path, as an animated GIF or a Y4M stream
+//depending on its extension
+func CreateVideo(path string, scale int, p Palette) (VideoWriter, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".gif" && ext != ".y4m" {
+		return nil, fmt.Errorf("Can't record %q, videos have to be .gif or .y4m", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext == ".gif" {
+		return &fileVideo{NewGIFWriter(f, scale, p), f}, nil
+	}
+	return &fileVideo{NewY4MWriter(f, scale, p), f}, nil
+}
+
+//Closes the file along with the video
+type fileVideo struct {
+	VideoWriter
+	f *os.File
+}
+
+func (v *fileVideo) Close() error {
+	err := v.VideoWriter.Close()
+	if cerr := v.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//Size of a video, which is fixed by the first frame. Frames in the other
+//resolution are scaled to fit
+func videoSize(fb Framebuffer, scale int) (int, int) {
+	if scale < 1 {
+		scale = 1
+	}
+	return fb.Width * scale, fb.Height * scale
+}
+
+//Draws the display into a w by h image, as big as it will fit
+func videoFrame(fb Framebuffer, w, h int, p Palette) *image.Paletted {
+	scale := w / fb.Width
+	if scale < 1 {
+		scale = 1
+	}
+	img := fb.Image(scale, p)
+	if img.Rect.Dx() == w && img.Rect.Dy() == h {
+		return img
+	}
+	fit := image.NewPaletted(image.Rect(0, 0, w, h), img.Palette)
+	for y := 0; y < h && y < img.Rect.Dy(); y++ {
+		copy(fit.Pix[y*fit.Stride:y*fit.Stride+w], img.Pix[y*img.Stride:])
+	}
+	return fit
+}
+
+//Writes an animated GIF. GIF frames are timed in hundredths of a second, so
+//each frame is given the delay that keeps it closest to 60Hz time, and
+//frames too short to show are merged into the next. The whole clip is kept
+//until Close, so GIFs are best for short clips
+type gifWriter struct {
+	w       io.Writer
+	scale   int
+	palette Palette
+	width   int
+	height  int
+
+	//Distinct displays in order, with how many 60Hz frames each was shown
+	frames []Framebuffer
+	counts []int
+}
+
+func NewGIFWriter(w io.Writer, scale int, p Palette) VideoWriter {
+	return &gifWriter{w: w, scale: scale, palette: p}
+}
+
+func (g *gifWriter) WriteFrame(fb Framebuffer) error {
+	if len(g.frames) == 0 {
+		g.width, g.height = videoSize(fb, g.scale)
+	}
+	if n := len(g.frames); n > 0 {
+		last := g.frames[n-1]
+		if last.Width == fb.Width && bytes.Equal(last.Pix, fb.Pix) {
+			g.counts[n-1]++
+			return nil
+		}
+	}
+	fb.Pix = append([]byte(nil), fb.Pix...)
+	g.frames = append(g.frames, fb)
+	g.counts = append(g.counts, 1)
+	return nil
+}
+
+func (g *gifWriter) Close() error {
+	if len(g.frames) == 0 {
+		return nil
+	}
+	anim := &gif.GIF{}
+	var frames int
+	start := 0
+	for i, fb := range g.frames {
+		frames += g.counts[i]
+		end := (frames*100 + 30) / 60
+
+		//Browsers slow down delays under 2, so a frame that short gives
+		//its time to the next one instead
+		if end-start < 2 && i < len(g.frames)-1 {
+			continue
+		}
+		anim.Image = append(anim.Image, videoFrame(fb, g.width, g.height, g.palette))
+		anim.Delay = append(anim.Delay, end-start)
+		start = end
+	}
+	return gif.EncodeAll(g.w, anim)
+}
+
+//Writes a YUV4MPEG2 stream at 60 frames per second, which ffmpeg and most
+//players read directly. Frames are written as they come, so it suits long
+//recordings
+type y4mWriter struct {
+	w       *bufio.Writer
+	scale   int
+	palette Palette
+	width   int
+	height  int
+
+	//Y, Cb and Cr of each palette colour
+	yuv [4][3]byte
+	err error
+}
+
+func NewY4MWriter(w io.Writer, scale int, p Palette) VideoWriter {
+	y := &y4mWriter{w: bufio.NewWriter(w), scale: scale, palette: p}
+	for i, c := range p {
+		cy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+		y.yuv[i] = [3]byte{cy, cb, cr}
+	}
+	return y
+}
+
+func (y *y4mWriter) WriteFrame(fb Framebuffer) error {
+	if y.err != nil {
+		return y.err
+	}
+	if y.width == 0 {
+		y.width, y.height = videoSize(fb, y.scale)
+		_, y.err = fmt.Fprintf(y.w, "YUV4MPEG2 W%d H%d F60:1 Ip A1:1 C444\n", y.width, y.height)
+	}
+
+	img := videoFrame(fb, y.width, y.height, y.palette)
+	y.w.WriteString("FRAME\n")
+	for plane := 0; plane < 3; plane++ {
+		for _, pix := range img.Pix {
+			y.w.WriteByte(y.yuv[pix&3][plane])
+		}
+	}
+	return y.err
+}
+
+func (y *y4mWriter) Close() error {
+	if err := y.w.Flush(); y.err == nil {
+		y.err = err
+	}
+	return y.err
+}
+
+//Starts recording the display to v, replacing any recording already going
+func (vm *Machine) StartVideo(v VideoWriter) error {
+	err := vm.StopVideo()
+	vm.video = v
+	vm.videoFrame = noCycle
+	return err
+}
+
+//Finishes the recording, if there is one
+func (vm *Machine) StopVideo() error {
+	if vm.video == nil {
+		return nil
+	}
+	err := vm.video.Close()
+	vm.video = nil
+	return err
+}
+
+//Hands the display to the recording once per 60Hz frame the machine runs
+func (vm *Machine) recordVideo() {
+	frame := vm.cycles / uint64(vm.InstructionsPerFrame())
+	if frame == vm.videoFrame {
+		return
+	}
+	vm.videoFrame = frame
+	if err := vm.video.WriteFrame(vm.Framebuffer()); err != nil {
+		fmt.Printf("\nError recording video: %v\n", err)
+		vm.StopVideo()
+	}
+}
+
+//Starts or stops recording for the record hotkey
+func (vm *Machine) toggleVideo() {
+	if vm.video != nil {
+		if err := vm.StopVideo(); err != nil {
+			fmt.Printf("\nError saving video: %v\n", err)
+		} else {
+			fmt.Println("\nStopped recording")
+		}
+		return
+	}
+	if vm.screenshotPrefix == "" {
+		fmt.Println("\nNo file to record to")
+		return
+	}
+	path := fmt.Sprintf("%s-%d.gif", vm.screenshotPrefix, vm.cycles)
+	v, err := CreateVideo(path, vm.screenshotScale, vm.palette)
+	if err != nil {
+		fmt.Printf("\nError recording video: %v\n", err)
+		return
+	}
+	vm.StartVideo(v)
+	fmt.Printf("\nRecording to %s\n", path)
+}
@@ -0,0 +1,125 @@
+package chip8
+
+import (
+	"image/color"
+	"math"
+	"sync"
+
+)
+
+//XO-CHIP additions: 64K of memory, long loads of I, register ranges, two
+//bitplanes and programmable audio patterns
+
+//A colour for each combination of the two bitplanes. Index 0 is the
+//background, 1 and 2 are pixels lit only in plane 1 or plane 2, and 3 is
+//pixels lit in both
+type Palette [4]color.RGBA
+
+//Black and white for plain CHIP-8, with two greys for XO-CHIP's extra planes
+var DefaultPalette = Palette{
+	{0x00, 0x00, 0x00, 0xFF},
+	{0xFF, 0xFF, 0xFF, 0xFF},
+	{0xAA, 0xAA, 0xAA, 0xFF},
+	{0x55, 0x55, 0x55, 0xFF},
+}
+
+//The sample pattern and pitch F002/FX3A set, shared with the audio
+//goroutine
+type soundState struct {
+	mu sync.Mutex
+
+	//Set once a program has loaded its own pattern with F002
+	custom bool
+
+	//128 one bit samples, played from the most significant bit down
+	pattern [16]byte
+
+	//Octo's pitch register, 64 being 4000 samples per second
+	pitch byte
+
+	//Mirrors soundTime > 0
+	playing bool
+}
+
+//Returns the number of pattern bits played per second for a pitch
+func patternRate(pitch byte) float64 {
+	return 4000 * math.Pow(2, (float64(pitch)-64)/48)
+}
+
+//Returns the number of bytes the instruction at addr takes up.
+//F000 NNNN is the only one that's longer than 2
+func (vm *Machine) instructionSize(addr uint16) uint16 {
+	if vm.mem[addr] == 0xF0 && vm.mem[addr+1] == 0x00 {
+		return 4
+	}
+	return 2
+}
+
+//Skips over the next instruction, however long it is
+func (vm *Machine) skipNext() {
+	vm.pc += 2
+	vm.pc += vm.instructionSize(vm.pc)
+}
+
+//5XY2 saves VX through VY to memory at I, counting down if X > Y.
+//I isn't changed
+func (vm *Machine) saveRange(x, y uint16) {
+	for n := uint16(0); n <= rangeLength(x, y); n++ {
+		vm.writeMem(vm.index+n, vm.v[rangeRegister(x, y, n)])
+	}
+}
+
+//5XY3 loads VX through VY from memory at I, counting down if X > Y.
+//I isn't changed
+func (vm *Machine) loadRange(x, y uint16) {
+	for n := uint16(0); n <= rangeLength(x, y); n++ {
+		vm.v[rangeRegister(x, y, n)] = vm.readMem(vm.index + n)
+	}
+}
+
+func rangeLength(x, y uint16) uint16 {
+	if x > y {
+		return x - y
+	}
+	return y - x
+}
+
+//Returns the nth register of the range VX to VY
+func rangeRegister(x, y, n uint16) uint16 {
+	if x > y {
+		return x - n
+	}
+	return x + n
+}
+
+//FN01 selects which bitplanes drawing, clearing and scrolling affect
+func (vm *Machine) selectPlanes(n byte) {
+	vm.plane = n & 0x3
+}
+
+//F002 copies the 16 bytes at I into the audio pattern buffer
+func (vm *Machine) loadPattern() {
+	var pattern [16]byte
+	for n := uint16(0); n < 16; n++ {
+		pattern[n] = vm.readMem(vm.index + n)
+	}
+
+	vm.sound.mu.Lock()
+	defer vm.sound.mu.Unlock()
+	vm.sound.pattern = pattern
+	vm.sound.custom = true
+}
+
+//FX3A sets the playback rate of the audio pattern
+func (vm *Machine) setPitch(pitch byte) {
+	vm.sound.mu.Lock()
+	defer vm.sound.mu.Unlock()
+	vm.sound.pitch = pitch
+}
+
+//Tells the audio goroutine whether the sound timer is running
+func (vm *Machine) updateSound() {
+	vm.sound.mu.Lock()
+	defer vm.sound.mu.Unlock()
+	vm.sound.playing = vm.soundTime > 0
+}
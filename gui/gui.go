@@ -1,11 +1,14 @@
 package gui
 
 import(
+	chip8 "alex/chip8/emulator"
+	"github.com/faiface/mainthread"
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/imdraw"
 	"github.com/faiface/pixel/pixelgl"
-	"golang.org/x/image/colornames"
+	"github.com/go-gl/glfw/v3.3/glfw"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -13,8 +16,6 @@ import(
 //decided to use another library called pixel
 
 const (
-	winX			float64 = 64 
-	winY			float64 = 32 
 	screenWidth		float64 = 1024
 	screenHeight	float64 = 768
 )
@@ -23,6 +24,7 @@ type Window struct {
 	*pixelgl.Window
 	KeyMap		map[uint16]pixelgl.Button
 	KeysDown	[16]*time.Ticker
+	Palette		chip8.Palette
 }
 
 func NewWindow() (*Window, error) {
@@ -36,37 +38,74 @@ func NewWindow() (*Window, error) {
 		return nil, fmt.Errorf("Error creating new window: %v", err)
 	}
 
-	km := map[uint16]pixelgl.Button{
-		0x1: pixelgl.Key1, 0x2: pixelgl.Key2,
-		0x3: pixelgl.Key3, 0xC: pixelgl.Key4,
-		0x4: pixelgl.KeyQ, 0x5: pixelgl.KeyW,
-		0x6: pixelgl.KeyE, 0xD: pixelgl.KeyR,
-		0x7: pixelgl.KeyA, 0x8: pixelgl.KeyS,
-		0x9: pixelgl.KeyD, 0xE: pixelgl.KeyF,
-		0xA: pixelgl.KeyZ, 0x0: pixelgl.KeyX,
-		0xB: pixelgl.KeyC, 0xF: pixelgl.KeyV,
-	}
-
-	return &Window{
+	w := &Window{
 		Window:		win,
-		KeyMap:		km,
 		KeysDown:	[16]*time.Ticker{},
-	}, nil
+		Palette:	chip8.DefaultPalette,
+	}
+	if err := w.SetKeymap(chip8.DefaultKeymap); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+//Finds the keys named in km on this keyboard. Keys are looked up by the
+//label the keyboard layout gives them first, then by their name on a US
+//keyboard, so "space" or "kp0" work too
+func (win *Window) SetKeymap(km chip8.Keymap) error {
+	labels := map[string]pixelgl.Button{}
+	usNames := map[string]pixelgl.Button{}
+	mainthread.Call(func() {
+		//Lowest first, so the main keys win over the number pad when
+		//both are labelled the same
+		for b := pixelgl.KeySpace; b <= pixelgl.KeyLast; b++ {
+			name := b.String()
+			if name == "Invalid" {
+				continue
+			}
+			usNames[strings.ToLower(name)] = b
+			label := strings.ToLower(glfw.GetKeyName(glfw.Key(b), 0))
+			if _, ok := labels[label]; label != "" && !ok {
+				labels[label] = b
+			}
+		}
+	})
+
+	keys := map[uint16]pixelgl.Button{}
+	for i, name := range km {
+		button, ok := labels[name]
+		if !ok {
+			button, ok = usNames[name]
+		}
+		if !ok {
+			return fmt.Errorf("There's no %q key on this keyboard", name)
+		}
+		keys[uint16(i)] = button
+	}
+	win.KeyMap = keys
+	return nil
 }
 
-func (win *Window) DrawGraphics(gfx ([64 * 32]uint8)) {
-	win.Clear(colornames.Black)
+//Runs f on the main thread with the windowing system initialised.
+//Must be called from the main goroutine before any window is created
+func Run(f func()) {
+	pixelgl.Run(f)
+}
+
+func (win *Window) DrawGraphics(fb chip8.Framebuffer) {
+	win.Clear(win.Palette[0])
 	imDraw := imdraw.New(nil)
-	imDraw.Color = pixel.RGB(1, 1, 1)
-	w, h := float64(screenWidth/winX), float64(screenHeight/winY)
+	w, h := float64(screenWidth/float64(fb.Width)), float64(screenHeight/float64(fb.Height))
 
-	for i := 0; i < 64; i++ {
-		for j := 0; j < 32; j++ {
+	for i := 0; i < fb.Width; i++ {
+		for j := 0; j < fb.Height; j++ {
 			// If the gfx byte in question is turned off,
 			// continue and skip drawing the rectangle
-			if gfx[(31-j)*64+i] == 0 {
+			pix := fb.At(i, fb.Height-1-j)
+			if pix == 0 {
 				continue
 			}
+			imDraw.Color = win.Palette[pix & 3]
 			imDraw.Push(pixel.V(w*float64(i), h*float64(j)))
 			imDraw.Push(pixel.V(w*float64(i)+w, h*float64(j)+h))
 			imDraw.Rectangle(0)
@@ -77,6 +116,65 @@ func (win *Window) DrawGraphics(gfx ([64 * 32]uint8)) {
 	win.Update()
 }
 
+//Keys for the emulator controls, kept off the keypad
+var hotkeyMap = map[chip8.Hotkey]pixelgl.Button{
+	chip8.HotkeySaveState:  pixelgl.KeyF5,
+	chip8.HotkeyLoadState:  pixelgl.KeyF9,
+	chip8.HotkeyScreenshot: pixelgl.KeyF12,
+	chip8.HotkeyRecord:     pixelgl.KeyF10,
+}
+
+//Emulator controls that act for as long as they're held
+var heldHotkeyMap = map[chip8.Hotkey]pixelgl.Button{
+	chip8.HotkeyRewind: pixelgl.KeyBackspace,
+}
+
+//Reports the emulator controls pressed since the last update, and the ones
+//still held down
+func (win *Window) Hotkeys() []chip8.Hotkey {
+	var hotkeys []chip8.Hotkey
+	for hotkey, button := range hotkeyMap {
+		if win.JustPressed(button) {
+			hotkeys = append(hotkeys, hotkey)
+		}
+	}
+	for hotkey, button := range heldHotkeyMap {
+		if win.Pressed(button) {
+			hotkeys = append(hotkeys, hotkey)
+		}
+	}
+	return hotkeys
+}
+
+//Turns window key presses into keypad events. A held key is re-sent as
+//pressed five times a second, since the keypad is cleared once it's read
+func (win *Window) KeyEvents() []chip8.KeyEvent {
+	var events []chip8.KeyEvent
+	for i, key := range win.KeyMap {
+		if win.JustReleased(key) && win.KeysDown[i] != nil {
+			win.KeysDown[i].Stop()
+			win.KeysDown[i] = nil
+			events = append(events, chip8.KeyEvent{Key: byte(i), Down: false})
+		} else if win.JustPressed(key) {
+			if win.KeysDown[i] == nil {
+				win.KeysDown[i] = time.NewTicker(time.Second / 5)
+			}
+			events = append(events, chip8.KeyEvent{Key: byte(i), Down: true})
+		}
+
+		if win.KeysDown[i] == nil {
+			continue
+		}
+
+		select {
+		case <-win.KeysDown[i].C:
+			events = append(events, chip8.KeyEvent{Key: byte(i), Down: true})
+		default:
+		}
+	}
+	return events
+}
+
 
 
 
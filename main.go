@@ -5,13 +5,10 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package main
 import ( 
 	"alex/chip8/cmd"
-	"github.com/faiface/pixel/pixelgl"
 )
 
+//The window is only opened by the commands that need one, so that
+//headless runs work on machines without a display
 func main() {
-	pixelgl.Run(runCHIP8)
-}
-
-func runCHIP8() {
 	cmd.Execute()
 }
\ No newline at end of file
@@ -0,0 +1,185 @@
+package octo
+
+import (
+	"math"
+	"strconv"
+)
+
+//Functions of one value in :calc
+var calcUnary = map[string]func(float64) float64{
+	"-":     func(a float64) float64 { return -a },
+	"~":     func(a float64) float64 { return float64(^int(a)) },
+	"!":     func(a float64) float64 { return calcBool(a == 0) },
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"sign":  func(a float64) float64 { return calcSign(a) },
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+}
+
+//Operators between two values in :calc
+var calcBinary = map[string]func(a, b float64) float64{
+	"-":   func(a, b float64) float64 { return a - b },
+	"+":   func(a, b float64) float64 { return a + b },
+	"*":   func(a, b float64) float64 { return a * b },
+	"/":   func(a, b float64) float64 { return a / b },
+	"%":   func(a, b float64) float64 { return float64(int(a) % calcNonZero(b)) },
+	"&":   func(a, b float64) float64 { return float64(int(a) & int(b)) },
+	"|":   func(a, b float64) float64 { return float64(int(a) | int(b)) },
+	"^":   func(a, b float64) float64 { return float64(int(a) ^ int(b)) },
+	"<<":  func(a, b float64) float64 { return float64(int(a) << uint(b)) },
+	">>":  func(a, b float64) float64 { return float64(int(a) >> uint(b)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"<":   func(a, b float64) float64 { return calcBool(a < b) },
+	">":   func(a, b float64) float64 { return calcBool(a > b) },
+	"<=":  func(a, b float64) float64 { return calcBool(a <= b) },
+	">=":  func(a, b float64) float64 { return calcBool(a >= b) },
+	"==":  func(a, b float64) float64 { return calcBool(a == b) },
+	"!=":  func(a, b float64) float64 { return calcBool(a != b) },
+}
+
+func calcBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func calcSign(a float64) float64 {
+	switch {
+	case a > 0:
+		return 1
+	case a < 0:
+		return -1
+	}
+	return 0
+}
+
+//Integer % by zero would panic, Octo gives NaN which turns into 0 anyway
+func calcNonZero(b float64) int {
+	if int(b) == 0 {
+		return 1
+	}
+	return int(b)
+}
+
+//Reads a { ... } block and works out its value. Like Octo, there's no
+//precedence: operators apply right to left, so 2 * 3 + 1 is 8. Names are
+//constants, labels defined so far and HERE, the address of the next byte
+func (c *compiler) calc() (float64, error) {
+	if err := c.expect("{"); err != nil {
+		return 0, err
+	}
+	open := c.tokens[c.pos-1]
+	body, err := c.braced()
+	if err != nil {
+		return 0, err
+	}
+	if len(body) == 0 {
+		return 0, c.errorf(open, "Empty expression")
+	}
+	e := &calcParser{c: c, tokens: body}
+	value, err := e.expr()
+	if err != nil {
+		return 0, err
+	}
+	if e.pos < len(body) {
+		return 0, c.errorf(body[e.pos], "Unexpected %q", body[e.pos].text)
+	}
+	return value, nil
+}
+
+type calcParser struct {
+	c      *compiler
+	tokens []token
+	pos    int
+}
+
+func (e *calcParser) next() (token, error) {
+	if e.pos >= len(e.tokens) {
+		return token{}, e.c.errorf(e.tokens[len(e.tokens)-1], "Expression ends too soon")
+	}
+	e.pos++
+	return e.tokens[e.pos-1], nil
+}
+
+func (e *calcParser) expr() (float64, error) {
+	lhs, err := e.term()
+	if err != nil {
+		return 0, err
+	}
+	if e.pos >= len(e.tokens) || e.tokens[e.pos].text == ")" {
+		return lhs, nil
+	}
+	opTok, err := e.next()
+	if err != nil {
+		return 0, err
+	}
+	op, ok := calcBinary[opTok.text]
+	if !ok {
+		return 0, e.c.errorf(opTok, "Unknown operator %q", opTok.text)
+	}
+	rhs, err := e.expr()
+	if err != nil {
+		return 0, err
+	}
+	return op(lhs, rhs), nil
+}
+
+func (e *calcParser) term() (float64, error) {
+	tok, err := e.next()
+	if err != nil {
+		return 0, err
+	}
+
+	if tok.text == "(" {
+		value, err := e.expr()
+		if err != nil {
+			return 0, err
+		}
+		closing, err := e.next()
+		if err != nil {
+			return 0, err
+		}
+		if closing.text != ")" {
+			return 0, e.c.errorf(closing, "Expected ), found %q", closing.text)
+		}
+		return value, nil
+	}
+	if f, ok := calcUnary[tok.text]; ok {
+		value, err := e.term()
+		if err != nil {
+			return 0, err
+		}
+		return f(value), nil
+	}
+
+	switch tok.text {
+	case "HERE":
+		return float64(e.c.here), nil
+	case "PI":
+		return math.Pi, nil
+	case "E":
+		return math.E, nil
+	}
+	if value, ok := e.c.consts[tok.text]; ok {
+		return value, nil
+	}
+	if addr, ok := e.c.labels[tok.text]; ok {
+		return float64(addr), nil
+	}
+	if value, ok, err := e.c.value(tok); ok || err != nil {
+		return float64(value), err
+	}
+	if f, err := strconv.ParseFloat(tok.text, 64); err == nil {
+		return f, nil
+	}
+	return 0, e.c.errorf(tok, "Undefined name %q", tok.text)
+}
@@ -0,0 +1,834 @@
+package octo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//Where ROMs are loaded
+const origin = 0x200
+
+//Most macro expansions in one program, to stop macros that expand to
+//themselves
+const maxExpansions = 10000
+
+type compiler struct {
+	file   string
+	tokens []token
+	pos    int
+
+	mem  [65536]byte
+	here int //Address of the next byte
+	high int //End of the highest byte written
+
+	labels  map[string]int
+	consts  map[string]float64
+	aliases map[string]byte
+	macros  map[string]*macro
+
+	fixups     []fixup
+	flow       []flow
+	expansions int
+}
+
+type macro struct {
+	args []string
+	body []token
+}
+
+//Which bits of the bytes at a fixup's address the label goes into
+type fixKind int
+
+const (
+	fixNNN  fixKind = iota //The low 12 bits of an instruction
+	fixLong                //A 16 bit word
+	fixHigh                //The low nibble of a byte, for the top of a 12 bit address
+	fixLow                 //A whole byte, for the bottom of an address
+)
+
+//A use of a label that's filled in once every label is known
+type fixup struct {
+	addr int
+	name token
+	kind fixKind
+}
+
+//An open begin, else or loop, waiting for its end or again
+type flow struct {
+	tok token
+
+	//For begin and else, the jump to be pointed at the matching else or
+	//end. For loop, where to jump back to
+	addr int
+
+	//Jumps out of the loop made by while
+	whiles []int
+}
+
+func newCompiler(file string, tokens []token) *compiler {
+	return &compiler{
+		file:    file,
+		tokens:  tokens,
+		here:    origin,
+		labels:  map[string]int{},
+		consts:  map[string]float64{},
+		aliases: map[string]byte{},
+		macros:  map[string]*macro{},
+	}
+}
+
+func (c *compiler) errorf(tok token, format string, args ...interface{}) *Error {
+	return &Error{File: c.file, Line: tok.line, Column: tok.column, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (c *compiler) done() bool {
+	return c.pos >= len(c.tokens)
+}
+
+func (c *compiler) next() (token, error) {
+	if c.done() {
+		end := token{line: 1, column: 1}
+		if len(c.tokens) > 0 {
+			end = c.tokens[len(c.tokens)-1]
+		}
+		return token{}, c.errorf(end, "Unexpected end of file after %q", end.text)
+	}
+	tok := c.tokens[c.pos]
+	c.pos++
+	return tok, nil
+}
+
+func (c *compiler) peek() string {
+	if c.done() {
+		return ""
+	}
+	return c.tokens[c.pos].text
+}
+
+//Reads a token that has to be text, like the := in delay := v0
+func (c *compiler) expect(text string) error {
+	tok, err := c.next()
+	if err != nil {
+		return err
+	}
+	if tok.text != text {
+		return c.errorf(tok, "Expected %s, found %q", text, tok.text)
+	}
+	return nil
+}
+
+func (c *compiler) compile() error {
+	//Programs start at main. If it isn't the first thing, jump to it
+	if len(c.tokens) < 2 || c.tokens[0].text != ":" || c.tokens[1].text != "main" {
+		c.fixups = append(c.fixups, fixup{addr: c.here, name: token{text: "main", line: 1, column: 1}, kind: fixNNN})
+		if err := c.emit(chip8.Instruction{Op: chip8.OpJP}); err != nil {
+			return err
+		}
+	}
+
+	for !c.done() {
+		if err := c.statement(); err != nil {
+			return err
+		}
+	}
+
+	if len(c.flow) > 0 {
+		open := c.flow[len(c.flow)-1].tok
+		return c.errorf(open, "This %s is never closed", open.text)
+	}
+	if _, ok := c.labels["main"]; !ok {
+		return c.errorf(token{line: 1, column: 1}, "This program has no main label")
+	}
+	for _, f := range c.fixups {
+		addr, ok := c.labels[f.name.text]
+		if !ok {
+			return c.errorf(f.name, "Undefined name %q", f.name.text)
+		}
+		if err := c.patch(f.addr, addr, f.kind, f.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//The compiled program, from 0x200 to the highest byte written
+func (c *compiler) rom() []byte {
+	if c.high <= origin {
+		return []byte{}
+	}
+	rom := make([]byte, c.high-origin)
+	copy(rom, c.mem[origin:c.high])
+	return rom
+}
+
+func (c *compiler) emitByte(tok token, b byte) error {
+	if c.here > 0xFFFF {
+		return c.errorf(tok, "Program doesn't fit in memory")
+	}
+	c.mem[c.here] = b
+	c.here++
+	if c.here > c.high {
+		c.high = c.here
+	}
+	return nil
+}
+
+func (c *compiler) emit(ins chip8.Instruction) error {
+	tok := token{line: 1, column: 1}
+	if c.pos > 0 {
+		tok = c.tokens[c.pos-1]
+	}
+	for _, b := range ins.Encode() {
+		if err := c.emitByte(tok, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Writes addr into the bytes at at. tok is blamed if it doesn't fit
+func (c *compiler) patch(at, addr int, kind fixKind, tok token) error {
+	switch kind {
+	case fixNNN:
+		if addr > 0xFFF {
+			return c.errorf(tok, "%q is at 0x%X, past the 12 bits this instruction can reach", tok.text, addr)
+		}
+		c.mem[at] = c.mem[at]&0xF0 | byte(addr>>8)
+		c.mem[at+1] = byte(addr)
+	case fixLong:
+		c.mem[at] = byte(addr >> 8)
+		c.mem[at+1] = byte(addr)
+	case fixHigh:
+		c.mem[at] = c.mem[at]&0xF0 | byte(addr>>8)&0x0F
+	case fixLow:
+		c.mem[at] = byte(addr)
+	}
+	return nil
+}
+
+func (c *compiler) statement() error {
+	tok, err := c.next()
+	if err != nil {
+		return err
+	}
+
+	if m, ok := c.macros[tok.text]; ok {
+		return c.expand(tok, m)
+	}
+	if _, ok := c.register(tok); ok {
+		return c.registerStatement(tok)
+	}
+
+	switch tok.text {
+	case ":":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		return c.defineLabel(name, c.here)
+	case ":alias":
+		return c.alias()
+	case ":const":
+		return c.constant()
+	case ":calc":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		value, err := c.calc()
+		if err != nil {
+			return err
+		}
+		return c.defineConst(name, value)
+	case ":macro":
+		return c.defineMacro()
+	case ":org":
+		addr, err := c.number(0xFFFF)
+		if err != nil {
+			return err
+		}
+		c.here = addr
+		return nil
+	case ":byte":
+		return c.byteStatement()
+	case ":pointer":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		if err := c.refer(name, c.here, fixLong); err != nil {
+			return err
+		}
+		if err := c.emitByte(tok, 0); err != nil {
+			return err
+		}
+		return c.emitByte(tok, 0)
+	case ":call":
+		return c.jumpStatement(chip8.OpCALL)
+	case ":unpack":
+		return c.unpack()
+	case ":breakpoint":
+		_, err := c.next()
+		return err
+	case ":monitor":
+		if _, err := c.next(); err != nil {
+			return err
+		}
+		_, err := c.next()
+		return err
+
+	case "return", ";":
+		return c.emit(chip8.Instruction{Op: chip8.OpRET})
+	case "clear":
+		return c.emit(chip8.Instruction{Op: chip8.OpCLS})
+	case "exit":
+		return c.emit(chip8.Instruction{Op: chip8.OpEXIT})
+	case "hires":
+		return c.emit(chip8.Instruction{Op: chip8.OpHIGH})
+	case "lores":
+		return c.emit(chip8.Instruction{Op: chip8.OpLOW})
+	case "scroll-left":
+		return c.emit(chip8.Instruction{Op: chip8.OpSCL})
+	case "scroll-right":
+		return c.emit(chip8.Instruction{Op: chip8.OpSCR})
+	case "audio":
+		return c.emit(chip8.Instruction{Op: chip8.OpAUDIO})
+	case "scroll-down", "scroll-up":
+		n, err := c.number(0xF)
+		if err != nil {
+			return err
+		}
+		op := chip8.OpSCD
+		if tok.text == "scroll-up" {
+			op = chip8.OpSCU
+		}
+		return c.emit(chip8.Instruction{Op: op, N: byte(n)})
+	case "plane":
+		n, err := c.number(0x3)
+		if err != nil {
+			return err
+		}
+		return c.emit(chip8.Instruction{Op: chip8.OpPLANE, X: byte(n)})
+	case "bcd", "saveflags", "loadflags":
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		op := map[string]chip8.Opcode{"bcd": chip8.OpLDB, "saveflags": chip8.OpSaveFlags, "loadflags": chip8.OpLoadFlags}[tok.text]
+		return c.emit(chip8.Instruction{Op: op, X: x})
+	case "save", "load":
+		return c.saveLoad(tok)
+	case "sprite":
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		y, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		n, err := c.number(0xF)
+		if err != nil {
+			return err
+		}
+		return c.emit(chip8.Instruction{Op: chip8.OpDRW, X: x, Y: y, N: byte(n)})
+	case "jump":
+		return c.jumpStatement(chip8.OpJP)
+	case "jump0":
+		return c.jumpStatement(chip8.OpJPV0)
+	case "native":
+		//0NNN calls machine code on a real VIP. It's only here so that
+		//old programs compile, the emulator treats it as invalid
+		nnn, err := c.number(0xFFF)
+		if err != nil {
+			return err
+		}
+		if err := c.emitByte(tok, byte(nnn>>8)); err != nil {
+			return err
+		}
+		return c.emitByte(tok, byte(nnn))
+	case "delay", "buzzer", "pitch":
+		if err := c.expect(":="); err != nil {
+			return err
+		}
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		op := map[string]chip8.Opcode{"delay": chip8.OpLDDTVx, "buzzer": chip8.OpLDSTVx, "pitch": chip8.OpPITCH}[tok.text]
+		return c.emit(chip8.Instruction{Op: op, X: x})
+	case "i":
+		return c.indexStatement()
+
+	case "if":
+		return c.ifStatement(tok)
+	case "else":
+		return c.elseStatement(tok)
+	case "end":
+		return c.endStatement(tok)
+	case "loop":
+		c.flow = append(c.flow, flow{tok: tok, addr: c.here})
+		return nil
+	case "while":
+		return c.whileStatement(tok)
+	case "again":
+		return c.againStatement(tok)
+	}
+
+	//A number or constant on its own is a byte of data
+	if value, ok, err := c.value(tok); ok || err != nil {
+		if err != nil {
+			return err
+		}
+		return c.dataByte(tok, value)
+	}
+
+	//Anything else names a subroutine, which may be further down
+	if !isName(tok.text) {
+		return c.errorf(tok, "Unexpected %q", tok.text)
+	}
+	if err := c.refer(tok, c.here, fixNNN); err != nil {
+		return err
+	}
+	return c.emit(chip8.Instruction{Op: chip8.OpCALL})
+}
+
+func isName(text string) bool {
+	for i, r := range text {
+		if !(r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return text != ""
+}
+
+func (c *compiler) dataByte(tok token, value int) error {
+	if value < -128 || value > 255 {
+		return c.errorf(tok, "%d doesn't fit in a byte", value)
+	}
+	return c.emitByte(tok, byte(value))
+}
+
+func (c *compiler) defineLabel(name token, addr int) error {
+	if err := c.checkName(name); err != nil {
+		return err
+	}
+	if _, ok := c.labels[name.text]; ok {
+		return c.errorf(name, "The label %q is already defined", name.text)
+	}
+	c.labels[name.text] = addr
+	return nil
+}
+
+func (c *compiler) defineConst(name token, value float64) error {
+	if err := c.checkName(name); err != nil {
+		return err
+	}
+	c.consts[name.text] = value
+	return nil
+}
+
+//Rejects names that would be read as something else
+func (c *compiler) checkName(name token) error {
+	if !isName(name.text) {
+		return c.errorf(name, "%q isn't a valid name", name.text)
+	}
+	if _, ok := c.register(name); ok {
+		return c.errorf(name, "%q is a register", name.text)
+	}
+	return nil
+}
+
+//Looks up a register by name or alias
+func (c *compiler) register(tok token) (byte, bool) {
+	if r, ok := c.aliases[tok.text]; ok {
+		return r, true
+	}
+	text := strings.ToLower(tok.text)
+	if len(text) != 2 || text[0] != 'v' {
+		return 0, false
+	}
+	r, err := strconv.ParseUint(text[1:], 16, 4)
+	return byte(r), err == nil
+}
+
+func (c *compiler) nextRegister() (byte, error) {
+	tok, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	r, ok := c.register(tok)
+	if !ok {
+		return 0, c.errorf(tok, "Expected a register, found %q", tok.text)
+	}
+	return r, nil
+}
+
+//Reads a number literal or constant. ok is false if tok is neither
+func (c *compiler) value(tok token) (value int, ok bool, err error) {
+	if v, ok := c.consts[tok.text]; ok {
+		return int(v), true, nil
+	}
+	text, negative := tok.text, false
+	if strings.HasPrefix(text, "-") && len(text) > 1 {
+		text, negative = text[1:], true
+	}
+	if text == "" || text[0] < '0' || text[0] > '9' {
+		return 0, false, nil
+	}
+
+	base := 10
+	switch {
+	case strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X"):
+		text, base = text[2:], 16
+	case strings.HasPrefix(text, "0b") || strings.HasPrefix(text, "0B"):
+		text, base = text[2:], 2
+	}
+	n, parseErr := strconv.ParseInt(text, base, 32)
+	if parseErr != nil {
+		return 0, true, c.errorf(tok, "Invalid number %q", tok.text)
+	}
+	if negative {
+		n = -n
+	}
+	return int(n), true, nil
+}
+
+//Reads a number or constant from 0 to max
+func (c *compiler) number(max int) (int, error) {
+	tok, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	return c.numberIn(tok, 0, max)
+}
+
+func (c *compiler) numberIn(tok token, min, max int) (int, error) {
+	value, ok, err := c.value(tok)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, c.errorf(tok, "Expected a number, found %q", tok.text)
+	}
+	if value < min || value > max {
+		return 0, c.errorf(tok, "%d is out of range, expected %d to %d", value, min, max)
+	}
+	return value, nil
+}
+
+//Arranges for the address of the label tok to be written at at, once every
+//label is known. The bits it goes in should be left as 0 until then
+func (c *compiler) refer(tok token, at int, kind fixKind) error {
+	if _, ok, _ := c.value(tok); ok || !isName(tok.text) {
+		return c.errorf(tok, "Expected a label, found %q", tok.text)
+	}
+	c.fixups = append(c.fixups, fixup{addr: at, name: tok, kind: kind})
+	return nil
+}
+
+//Reads a label or number and emits op with it as the address
+func (c *compiler) jumpStatement(op chip8.Opcode) error {
+	tok, err := c.next()
+	if err != nil {
+		return err
+	}
+	if _, ok, _ := c.value(tok); ok {
+		addr, err := c.numberIn(tok, 0, 0xFFF)
+		if err != nil {
+			return err
+		}
+		return c.emit(chip8.Instruction{Op: op, NNN: uint16(addr)})
+	}
+	if err := c.refer(tok, c.here, fixNNN); err != nil {
+		return err
+	}
+	return c.emit(chip8.Instruction{Op: op})
+}
+
+func (c *compiler) registerStatement(xTok token) error {
+	x, _ := c.register(xTok)
+	opTok, err := c.next()
+	if err != nil {
+		return err
+	}
+	rhs, err := c.next()
+	if err != nil {
+		return err
+	}
+	y, isReg := c.register(rhs)
+
+	regOps := map[string]chip8.Opcode{
+		":=": chip8.OpLDReg, "+=": chip8.OpADDReg, "-=": chip8.OpSUB, "=-": chip8.OpSUBN,
+		"|=": chip8.OpOR, "&=": chip8.OpAND, "^=": chip8.OpXOR, ">>=": chip8.OpSHR, "<<=": chip8.OpSHL,
+	}
+	if op, ok := regOps[opTok.text]; ok && isReg {
+		return c.emit(chip8.Instruction{Op: op, X: x, Y: y})
+	}
+
+	switch opTok.text {
+	case ":=":
+		switch rhs.text {
+		case "random":
+			kk, err := c.number(0xFF)
+			if err != nil {
+				return err
+			}
+			return c.emit(chip8.Instruction{Op: chip8.OpRND, X: x, KK: byte(kk)})
+		case "delay":
+			return c.emit(chip8.Instruction{Op: chip8.OpLDVxDT, X: x})
+		case "key":
+			return c.emit(chip8.Instruction{Op: chip8.OpLDVxK, X: x})
+		}
+		kk, err := c.numberIn(rhs, -128, 255)
+		if err != nil {
+			return err
+		}
+		return c.emit(chip8.Instruction{Op: chip8.OpLDByte, X: x, KK: byte(kk)})
+	case "+=", "-=":
+		kk, err := c.numberIn(rhs, -128, 255)
+		if err != nil {
+			return err
+		}
+		if opTok.text == "-=" {
+			kk = -kk
+		}
+		return c.emit(chip8.Instruction{Op: chip8.OpADDByte, X: x, KK: byte(kk)})
+	}
+	if _, ok := regOps[opTok.text]; ok {
+		return c.errorf(rhs, "%s needs a register, found %q", opTok.text, rhs.text)
+	}
+	return c.errorf(opTok, "Unknown operator %q", opTok.text)
+}
+
+func (c *compiler) indexStatement() error {
+	opTok, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch opTok.text {
+	case "+=":
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		return c.emit(chip8.Instruction{Op: chip8.OpADDI, X: x})
+	case ":=":
+	default:
+		return c.errorf(opTok, "Expected := or += after i, found %q", opTok.text)
+	}
+
+	switch c.peek() {
+	case "hex", "bighex":
+		kind, _ := c.next()
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		op := chip8.OpLDF
+		if kind.text == "bighex" {
+			op = chip8.OpLDHF
+		}
+		return c.emit(chip8.Instruction{Op: op, X: x})
+	case "long":
+		c.next()
+		tok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if _, ok, _ := c.value(tok); ok {
+			addr, err := c.numberIn(tok, 0, 0xFFFF)
+			if err != nil {
+				return err
+			}
+			return c.emit(chip8.Instruction{Op: chip8.OpLDILong, NNN: uint16(addr)})
+		}
+		//The address is the second word of F000 NNNN
+		if err := c.refer(tok, c.here+2, fixLong); err != nil {
+			return err
+		}
+		return c.emit(chip8.Instruction{Op: chip8.OpLDILong})
+	}
+	return c.jumpStatement(chip8.OpLDI)
+}
+
+//save vx and save vx - vy, and the same for load
+func (c *compiler) saveLoad(tok token) error {
+	x, err := c.nextRegister()
+	if err != nil {
+		return err
+	}
+	if c.peek() != "-" {
+		op := chip8.OpStore
+		if tok.text == "load" {
+			op = chip8.OpLoad
+		}
+		return c.emit(chip8.Instruction{Op: op, X: x})
+	}
+	c.next()
+	y, err := c.nextRegister()
+	if err != nil {
+		return err
+	}
+	op := chip8.OpSaveRange
+	if tok.text == "load" {
+		op = chip8.OpLoadRange
+	}
+	return c.emit(chip8.Instruction{Op: op, X: x, Y: y})
+}
+
+func (c *compiler) alias() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	if !isName(name.text) {
+		return c.errorf(name, "%q isn't a valid name", name.text)
+	}
+	r, err := c.nextRegister()
+	if err != nil {
+		return err
+	}
+	c.aliases[name.text] = r
+	return nil
+}
+
+func (c *compiler) constant() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	tok, err := c.next()
+	if err != nil {
+		return err
+	}
+	value, ok, err := c.value(tok)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return c.errorf(tok, "Expected a number, found %q", tok.text)
+	}
+	return c.defineConst(name, float64(value))
+}
+
+func (c *compiler) byteStatement() error {
+	if c.peek() == "{" {
+		tok := c.tokens[c.pos]
+		value, err := c.calc()
+		if err != nil {
+			return err
+		}
+		return c.emitByte(tok, byte(int(value)))
+	}
+	tok, err := c.next()
+	if err != nil {
+		return err
+	}
+	value, err := c.numberIn(tok, -128, 255)
+	if err != nil {
+		return err
+	}
+	return c.emitByte(tok, byte(value))
+}
+
+//:unpack n label sets v0 to n and the top nibble of the label's address,
+//and v1 to the bottom byte, ready for i := 0xNNN style self modification
+func (c *compiler) unpack() error {
+	n, err := c.number(0xF)
+	if err != nil {
+		return err
+	}
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	if err := c.refer(name, c.here+1, fixHigh); err != nil {
+		return err
+	}
+	c.refer(name, c.here+3, fixLow)
+	if err := c.emit(chip8.Instruction{Op: chip8.OpLDByte, X: 0, KK: byte(n << 4)}); err != nil {
+		return err
+	}
+	return c.emit(chip8.Instruction{Op: chip8.OpLDByte, X: 1})
+}
+
+func (c *compiler) defineMacro() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	if !isName(name.text) {
+		return c.errorf(name, "%q isn't a valid name", name.text)
+	}
+	m := &macro{}
+	for {
+		tok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if tok.text == "{" {
+			break
+		}
+		m.args = append(m.args, tok.text)
+	}
+	body, err := c.braced()
+	if err != nil {
+		return err
+	}
+	m.body = body
+	c.macros[name.text] = m
+	return nil
+}
+
+//Reads tokens up to the } matching a { that's just been read
+func (c *compiler) braced() ([]token, error) {
+	var body []token
+	depth := 1
+	for {
+		tok, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		switch tok.text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return body, nil
+			}
+		}
+		body = append(body, tok)
+	}
+}
+
+//Replaces a macro's name and arguments with its body
+func (c *compiler) expand(tok token, m *macro) error {
+	c.expansions++
+	if c.expansions > maxExpansions {
+		return c.errorf(tok, "Too many macro expansions, does %s expand to itself?", tok.text)
+	}
+	args := map[string]token{}
+	for _, name := range m.args {
+		arg, err := c.next()
+		if err != nil {
+			return err
+		}
+		args[name] = arg
+	}
+
+	body := make([]token, len(m.body))
+	for i, t := range m.body {
+		if arg, ok := args[t.text]; ok {
+			t.text = arg.text
+		}
+		body[i] = t
+	}
+	rest := append(body, c.tokens[c.pos:]...)
+	c.tokens = append(c.tokens[:c.pos:c.pos], rest...)
+	return nil
+}
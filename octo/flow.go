@@ -0,0 +1,191 @@
+package octo
+
+import (
+	chip8 "alex/chip8/emulator"
+)
+
+//Compiles if ... then and if ... begin
+func (c *compiler) ifStatement(tok token) error {
+	//The condition is a register, a comparison and, unless the comparison
+	//is key or -key, a right hand side
+	n := 3
+	if c.pos+1 < len(c.tokens) && (c.tokens[c.pos+1].text == "key" || c.tokens[c.pos+1].text == "-key") {
+		n = 2
+	}
+	if c.pos+n >= len(c.tokens) {
+		return c.errorf(tok, "if without then or begin")
+	}
+
+	//then guards one statement, so skip it when the condition is false.
+	//begin needs a jump to the else or end, so skip that when it's true
+	word := c.tokens[c.pos+n]
+	if word.text != "then" && word.text != "begin" {
+		return c.errorf(word, "Expected then or begin, found %q", word.text)
+	}
+	if err := c.condition(word.text == "begin"); err != nil {
+		return err
+	}
+	c.pos++
+	if word.text == "begin" {
+		return c.openJump(word)
+	}
+	return nil
+}
+
+//Emits a jump to be pointed somewhere later and pushes it on the flow stack
+func (c *compiler) openJump(tok token) error {
+	c.flow = append(c.flow, flow{tok: tok, addr: c.here})
+	return c.emit(chip8.Instruction{Op: chip8.OpJP})
+}
+
+func (c *compiler) top(kinds ...string) (*flow, bool) {
+	if len(c.flow) == 0 {
+		return nil, false
+	}
+	f := &c.flow[len(c.flow)-1]
+	for _, kind := range kinds {
+		if f.tok.text == kind {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+func (c *compiler) elseStatement(tok token) error {
+	f, ok := c.top("begin")
+	if !ok {
+		return c.errorf(tok, "else without a matching begin")
+	}
+	begin := f.addr
+	c.flow = c.flow[:len(c.flow)-1]
+	if err := c.openJump(tok); err != nil {
+		return err
+	}
+	return c.patch(begin, c.here, fixNNN, tok)
+}
+
+func (c *compiler) endStatement(tok token) error {
+	f, ok := c.top("begin", "else")
+	if !ok {
+		return c.errorf(tok, "end without a matching begin")
+	}
+	c.flow = c.flow[:len(c.flow)-1]
+	return c.patch(f.addr, c.here, fixNNN, tok)
+}
+
+//while cond leaves the loop it's in when cond is false
+func (c *compiler) whileStatement(tok token) error {
+	f, ok := c.top("loop")
+	if !ok {
+		return c.errorf(tok, "while outside of a loop")
+	}
+	if err := c.condition(true); err != nil {
+		return err
+	}
+	f.whiles = append(f.whiles, c.here)
+	return c.emit(chip8.Instruction{Op: chip8.OpJP})
+}
+
+func (c *compiler) againStatement(tok token) error {
+	f, ok := c.top("loop")
+	if !ok {
+		return c.errorf(tok, "again without a matching loop")
+	}
+	c.flow = c.flow[:len(c.flow)-1]
+	if f.addr > 0xFFF {
+		return c.errorf(tok, "This loop starts at 0x%X, past the 12 bits a jump can reach", f.addr)
+	}
+	if err := c.emit(chip8.Instruction{Op: chip8.OpJP, NNN: uint16(f.addr)}); err != nil {
+		return err
+	}
+	for _, addr := range f.whiles {
+		if err := c.patch(addr, c.here, fixNNN, tok); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Compiles a condition like v0 == 3 or v1 key into instructions that skip
+//the next one when the condition's truth is skipWhen
+func (c *compiler) condition(skipWhen bool) error {
+	x, err := c.nextRegister()
+	if err != nil {
+		return err
+	}
+	opTok, err := c.next()
+	if err != nil {
+		return err
+	}
+
+	switch opTok.text {
+	case "key", "-key":
+		//EX9E skips if the key is down, EXA1 if it's up
+		skipIfDown := (opTok.text == "key") == skipWhen
+		op := chip8.OpSKNP
+		if skipIfDown {
+			op = chip8.OpSKP
+		}
+		return c.emit(chip8.Instruction{Op: op, X: x})
+	}
+
+	rhs, err := c.next()
+	if err != nil {
+		return err
+	}
+	y, isReg := c.register(rhs)
+	kk := 0
+	if !isReg {
+		if kk, err = c.numberIn(rhs, -128, 255); err != nil {
+			return err
+		}
+	}
+
+	switch opTok.text {
+	case "==", "!=":
+		skipIfEqual := (opTok.text == "==") == skipWhen
+		switch {
+		case isReg && skipIfEqual:
+			return c.emit(chip8.Instruction{Op: chip8.OpSEReg, X: x, Y: y})
+		case isReg:
+			return c.emit(chip8.Instruction{Op: chip8.OpSNEReg, X: x, Y: y})
+		case skipIfEqual:
+			return c.emit(chip8.Instruction{Op: chip8.OpSEByte, X: x, KK: byte(kk)})
+		default:
+			return c.emit(chip8.Instruction{Op: chip8.OpSNEByte, X: x, KK: byte(kk)})
+		}
+
+	//The others put the right hand side in VF and subtract, leaving VF as
+	//the no borrow flag. vf -= vx gives 1 if rhs >= vx, vf =- vx gives 1 if
+	//vx >= rhs
+	case "<", ">", "<=", ">=":
+		if isReg {
+			err = c.emit(chip8.Instruction{Op: chip8.OpLDReg, X: 0xF, Y: y})
+		} else {
+			err = c.emit(chip8.Instruction{Op: chip8.OpLDByte, X: 0xF, KK: byte(kk)})
+		}
+		if err != nil {
+			return err
+		}
+
+		sub := chip8.OpSUB
+		if opTok.text == "<" || opTok.text == ">=" {
+			sub = chip8.OpSUBN
+		}
+		if err := c.emit(chip8.Instruction{Op: sub, X: 0xF, Y: x}); err != nil {
+			return err
+		}
+
+		//VF is 1 when the condition is true for >= and <=, and 0 for < and >
+		flagWhenTrue := byte(0)
+		if opTok.text == "<=" || opTok.text == ">=" {
+			flagWhenTrue = 1
+		}
+		skipOn := flagWhenTrue
+		if !skipWhen {
+			skipOn ^= 1
+		}
+		return c.emit(chip8.Instruction{Op: chip8.OpSEByte, X: 0xF, KK: skipOn})
+	}
+	return c.errorf(opTok, "Unknown comparison %q", opTok.text)
+}
@@ -0,0 +1,79 @@
+//Package octo compiles programs written in Octo, the high level CHIP-8
+//assembly language of John Earnest's Octo IDE, into ROMs.
+//
+//It covers the statements of the core language, labels, loop/again/while,
+//if with then or begin/else/end, comparisons, :alias, :const, :macro,
+//:calc, :org, :byte, :pointer, :call and :unpack. Stepping through a
+//program works on the compiled ROM with `chip8 debug`, so :breakpoint and
+//:monitor are accepted and ignored.
+package octo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+//A compile error, at the line and column of the token that caused it
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+//Compiles the Octo source file at path into a ROM
+func CompileFile(path string) ([]byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(path, src)
+}
+
+//Compiles Octo source into a ROM. name is only used in errors
+func Compile(name string, src []byte) ([]byte, error) {
+	c := newCompiler(name, tokenize(string(src)))
+	if err := c.compile(); err != nil {
+		return nil, err
+	}
+	return c.rom(), nil
+}
+
+//One whitespace separated word of the source. Octo has no other
+//punctuation, so even operators like := are tokens of their own
+type token struct {
+	text   string
+	line   int
+	column int
+}
+
+func tokenize(src string) []token {
+	var tokens []token
+	for i, line := range strings.Split(src, "\n") {
+		if hash := strings.IndexByte(line, '#'); hash >= 0 {
+			line = line[:hash]
+		}
+		col := 0
+		for col < len(line) {
+			if isSpace(line[col]) {
+				col++
+				continue
+			}
+			start := col
+			for col < len(line) && !isSpace(line[col]) {
+				col++
+			}
+			tokens = append(tokens, token{text: line[start:col], line: i + 1, column: start + 1})
+		}
+	}
+	return tokens
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
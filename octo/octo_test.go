@@ -0,0 +1,112 @@
+package octo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//Reads the bytes a fixture should compile to. Each line of a .hex file
+//holds hex bytes, then a # comment saying what they are
+func readHex(t *testing.T, path string) []byte {
+	t.Helper()
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var digits strings.Builder
+	for _, line := range strings.Split(string(src), "\n") {
+		if hash := strings.IndexByte(line, '#'); hash >= 0 {
+			line = line[:hash]
+		}
+		digits.WriteString(strings.Join(strings.Fields(line), ""))
+	}
+	data, err := hex.DecodeString(digits.String())
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return data
+}
+
+//Compiles each testdata/*.8o and compares it with the .hex file of the
+//same name, worked out by hand
+func TestCompileFixtures(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/*.8o")
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("No fixtures found: %v", err)
+	}
+	for _, path := range fixtures {
+		got, err := CompileFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		want := readHex(t, strings.TrimSuffix(path, ".8o")+".hex")
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s:\ngot  % X\nwant % X", path, got, want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"clear", "test.8o:1:1: This program has no main label"},
+		{": main jump nowhere", `test.8o:1:13: Undefined name "nowhere"`},
+		{": main v0 := 300", "test.8o:1:14: 300 is out of range, expected -128 to 255"},
+		{": main sprite v0 v1 16", "test.8o:1:21: 16 is out of range, expected 0 to 15"},
+		{": main loop", "test.8o:1:8: This loop is never closed"},
+		{": main again", "test.8o:1:8: again without a matching loop"},
+		{": main else", "test.8o:1:8: else without a matching begin"},
+		{": main end", "test.8o:1:8: end without a matching begin"},
+		{": main while v0 == 1", "test.8o:1:8: while outside of a loop"},
+		{": main if v0 == 1 clear", `test.8o:1:19: Expected then or begin, found "clear"`},
+		{": main if v0 ~ 1 then clear", `test.8o:1:14: Unknown comparison "~"`},
+		{": main v0 ?= 1", `test.8o:1:11: Unknown operator "?="`},
+		{": main i -= v0", `test.8o:1:10: Expected := or += after i, found "-="`},
+		{": main : main", `test.8o:1:10: The label "main" is already defined`},
+		{":macro m { m } : main m", "test.8o:1:12: Too many macro expansions, does m expand to itself?"},
+		{": main\n\tv0 :=", `test.8o:2:5: Unexpected end of file after ":="`},
+	}
+	for _, test := range tests {
+		_, err := Compile("test.8o", []byte(test.src))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Compile(%q) = %v, want %q", test.src, err, test.err)
+		}
+	}
+}
+
+//Disassembling the bundled ROMs as Octo and compiling the result gives
+//back the same bytes
+func TestDisassemblyRoundTrip(t *testing.T) {
+	roms, err := filepath.Glob("../TestPrograms/*.ch8")
+	if err != nil || len(roms) == 0 {
+		t.Fatalf("No test ROMs found: %v", err)
+	}
+	for _, path := range roms {
+		rom, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var src bytes.Buffer
+		if err := chip8.Disassemble(&src, rom, chip8.SyntaxOcto); err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		got, err := Compile(filepath.Base(path)+".8o", src.Bytes())
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if !bytes.Equal(got, rom) {
+			t.Errorf("%s: recompiled ROM differs", path)
+		}
+	}
+}
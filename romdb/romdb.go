@@ -0,0 +1,228 @@
+//Package romdb reads the community CHIP-8 database
+//(https://github.com/chip-8/chip-8-database), which knows the title,
+//authors, platform, quirks, speed, colours and keys of thousands of ROMs by
+//their SHA-1. Only its programs.json is needed.
+package romdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//Name of the file the database keeps its programs in
+const ProgramsFile = "programs.json"
+
+//A program, which can have several ROMs: versions, ports and fixes
+type Program struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Release     string         `json:"release"`
+	Authors     []string       `json:"authors"`
+	ROMs        map[string]ROM `json:"roms"`
+}
+
+//One ROM file of a program
+type ROM struct {
+	File          string   `json:"file"`
+	EmbeddedTitle string   `json:"embeddedTitle"`
+	Description   string   `json:"description"`
+	Release       string   `json:"release"`
+	Authors       []string `json:"authors"`
+
+	//Platforms the ROM runs on, best first, by the database's IDs like
+	//originalChip8 or superchip
+	Platforms []string `json:"platforms"`
+
+	//Quirks that differ from a platform's usual ones, by platform ID
+	QuirkyPlatforms map[string]map[string]bool `json:"quirkyPlatforms"`
+
+	//Instructions per frame
+	Tickrate int `json:"tickrate"`
+
+	Colors *Colors `json:"colors"`
+
+	//Which keypad key does what, like "up": 5
+	Keys map[string]int `json:"keys"`
+}
+
+type Colors struct {
+	//Background first, as #rrggbb
+	Pixels []string `json:"pixels"`
+}
+
+type Database struct {
+	Programs []Program
+
+	//Index into Programs by SHA-1
+	hashes map[string]int
+}
+
+//A ROM found in the database
+type Entry struct {
+	Hash    string
+	Program *Program
+	ROM     ROM
+}
+
+//Reads the database's programs.json, or the one in the folder at path
+func Load(path string) (*Database, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, ProgramsFile)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db := &Database{hashes: map[string]int{}}
+	if err := json.Unmarshal(data, &db.Programs); err != nil {
+		return nil, fmt.Errorf("Error reading the CHIP-8 database %s: %v", path, err)
+	}
+	for i, p := range db.Programs {
+		for hash := range p.ROMs {
+			db.hashes[strings.ToLower(hash)] = i
+		}
+	}
+	return db, nil
+}
+
+//Finds a ROM by the hex SHA-1 of its file
+func (db *Database) Lookup(hash string) (Entry, bool) {
+	hash = strings.ToLower(hash)
+	i, ok := db.hashes[hash]
+	if !ok {
+		return Entry{}, false
+	}
+	p := &db.Programs[i]
+	for h, rom := range p.ROMs {
+		if strings.ToLower(h) == hash {
+			return Entry{Hash: hash, Program: p, ROM: rom}, true
+		}
+	}
+	return Entry{}, false
+}
+
+//The platforms the database knows, by ID, with their usual quirks by the
+//database's names and the closest preset here
+type dbPlatform struct {
+	preset string
+	quirks map[string]bool
+}
+
+var dbPlatforms = map[string]dbPlatform{
+	"originalChip8": {"vip", map[string]bool{"vblank": true, "logic": true}},
+	"hybridVIP":     {"vip", map[string]bool{"vblank": true, "logic": true}},
+	"modernChip8":   {"vip", map[string]bool{}},
+	"chip8x":        {"vip", map[string]bool{"vblank": true, "logic": true}},
+	"chip48":        {"chip48", map[string]bool{"shift": true, "memoryIncrementByX": true, "jump": true}},
+	"superchip1":    {"schip", map[string]bool{"shift": true, "memoryIncrementByX": true, "jump": true}},
+	"superchip":     {"schip", map[string]bool{"shift": true, "memoryLeaveIUnchanged": true, "jump": true}},
+	"xochip":        {"xochip", map[string]bool{"wrap": true}},
+}
+
+//The first of the ROM's platforms that can be run here, by database ID
+func (e Entry) platformID() (string, bool) {
+	for _, id := range e.ROM.Platforms {
+		if _, ok := dbPlatforms[id]; ok {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+//The preset for the platform the ROM is meant for, like "schip"
+func (e Entry) Platform() (string, bool) {
+	id, ok := e.platformID()
+	if !ok {
+		return "", false
+	}
+	return dbPlatforms[id].preset, true
+}
+
+//The quirks the ROM needs: its platform's, with the ROM's own changes
+func (e Entry) Quirks() (chip8.Quirks, bool) {
+	id, ok := e.platformID()
+	if !ok {
+		return chip8.Quirks{}, false
+	}
+	set := map[string]bool{}
+	for name, on := range dbPlatforms[id].quirks {
+		set[name] = on
+	}
+	for name, on := range e.ROM.QuirkyPlatforms[id] {
+		set[name] = on
+	}
+	//vblank (waiting for the display before drawing) isn't emulated
+	return chip8.Quirks{
+		ShiftVX:                set["shift"],
+		JumpVX:                 set["jump"],
+		LoadStoreIncrementsI:   !set["memoryLeaveIUnchanged"],
+		LoadStoreIncrementsByX: set["memoryIncrementByX"],
+		LogicResetsVF:          set["logic"],
+		Clip:                   !set["wrap"],
+		Memory64K:              id == "xochip",
+	}, true
+}
+
+//The ROM's colours, if it has any
+func (e Entry) Palette() (chip8.Palette, bool) {
+	if e.ROM.Colors == nil || len(e.ROM.Colors.Pixels) < 2 {
+		return chip8.Palette{}, false
+	}
+	colours := make([]string, 0, 4)
+	for _, c := range e.ROM.Colors.Pixels {
+		colours = append(colours, strings.TrimPrefix(c, "#"))
+		if len(colours) == 4 {
+			break
+		}
+	}
+	//ParsePalette takes 2 or 4
+	if len(colours) == 3 {
+		colours = colours[:2]
+	}
+	p, err := chip8.ParsePalette(strings.Join(colours, ","))
+	return p, err == nil
+}
+
+//The program's title, falling back to the title embedded in the ROM
+func (e Entry) Title() string {
+	if e.Program.Title != "" {
+		return e.Program.Title
+	}
+	return e.ROM.EmbeddedTitle
+}
+
+//The ROM's authors, falling back to the program's
+func (e Entry) Authors() []string {
+	if len(e.ROM.Authors) > 0 {
+		return e.ROM.Authors
+	}
+	return e.Program.Authors
+}
+
+//The ROM's release date, falling back to the program's
+func (e Entry) Release() string {
+	if e.ROM.Release != "" {
+		return e.ROM.Release
+	}
+	return e.Program.Release
+}
+
+//Describes what the ROM's keys do with the keyboard keys in km, like
+//"up: w (5)", sorted by what they do
+func (e Entry) KeyHints(km chip8.Keymap) []string {
+	hints := make([]string, 0, len(e.ROM.Keys))
+	for action, key := range e.ROM.Keys {
+		if key < 0 || key > 0xF {
+			continue
+		}
+		hints = append(hints, fmt.Sprintf("%s: %s (%X)", action, km[key], key))
+	}
+	sort.Strings(hints)
+	return hints
+}
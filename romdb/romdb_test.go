@@ -0,0 +1,101 @@
+package romdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	chip8 "alex/chip8/emulator"
+)
+
+const testPrograms = `[
+	{
+		"title": "Tetris",
+		"release": "1991",
+		"authors": ["Fran Dachille"],
+		"roms": {
+			"ABCDEF": {
+				"platforms": ["superchip", "originalChip8"],
+				"quirkyPlatforms": {"superchip": {"wrap": true}},
+				"tickrate": 30,
+				"colors": {"pixels": ["#112233", "#ddeeff"]},
+				"keys": {"left": 5, "right": 6, "bad": 16}
+			}
+		}
+	},
+	{
+		"roms": {
+			"123456": {"embeddedTitle": "Mega", "platforms": ["megachip8"], "authors": ["Someone"], "release": "2008"}
+		}
+	}
+]`
+
+func loadTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ProgramsFile), []byte(testPrograms), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestLookup(t *testing.T) {
+	db := loadTestDatabase(t)
+	if _, ok := db.Lookup("000000"); ok {
+		t.Error("found a ROM that isn't in the database")
+	}
+
+	e, ok := db.Lookup("abcdef")
+	if !ok {
+		t.Fatal("hashes should be looked up in any case")
+	}
+	if e.Title() != "Tetris" || e.Release() != "1991" || len(e.Authors()) != 1 {
+		t.Errorf("got %q %q %v", e.Title(), e.Release(), e.Authors())
+	}
+	if platform, ok := e.Platform(); !ok || platform != "schip" {
+		t.Errorf("platform %q, want schip", platform)
+	}
+	want := chip8.QuirksSuperChip
+	want.Clip = false
+	if q, ok := e.Quirks(); !ok || q != want {
+		t.Errorf("quirks %+v, want %+v", q, want)
+	}
+	if p, ok := e.Palette(); !ok || p.String() != "112233,ddeeff,aaaaaa,555555" {
+		t.Errorf("palette %v", p)
+	}
+	hints := e.KeyHints(chip8.DefaultKeymap)
+	if len(hints) != 2 || hints[0] != "left: w (5)" || hints[1] != "right: e (6)" {
+		t.Errorf("key hints %q", hints)
+	}
+
+	//Falls back to the ROM's own details, and has no platform that runs
+	e, _ = db.Lookup("123456")
+	if e.Title() != "Mega" || e.Release() != "2008" || e.Authors()[0] != "Someone" {
+		t.Errorf("got %q %q %v", e.Title(), e.Release(), e.Authors())
+	}
+	if _, ok := e.Platform(); ok {
+		t.Error("megachip8 isn't supported")
+	}
+}
+
+func TestPlatformQuirks(t *testing.T) {
+	tests := []struct {
+		id   string
+		want chip8.Quirks
+	}{
+		{"originalChip8", chip8.QuirksCOSMACVIP},
+		{"chip48", chip8.QuirksCHIP48},
+		{"superchip", chip8.QuirksSuperChip},
+		{"xochip", chip8.QuirksXOChip},
+	}
+	for _, tt := range tests {
+		e := Entry{Program: &Program{}, ROM: ROM{Platforms: []string{tt.id}}}
+		if q, _ := e.Quirks(); q != tt.want {
+			t.Errorf("%s quirks %+v, want %+v", tt.id, q, tt.want)
+		}
+	}
+}
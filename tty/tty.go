@@ -0,0 +1,357 @@
+//Package tty is a frontend that draws the display in a terminal and reads
+//the keypad from it, for running ROMs over SSH where no window can open.
+//
+//Terminals only send key presses, repeated while a key is held, so a key
+//counts as released once it hasn't been seen for ReleaseAfter.
+package tty
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//How the display is drawn
+type Mode int
+
+const (
+	//Two pixels per character with ▀, in colour
+	HalfBlock Mode = iota
+
+	//Eight pixels per character with braille dots, in one colour. Fits
+	//hires in a small terminal
+	Braille
+)
+
+//Looks up a mode by name, "half" or "braille"
+func ParseMode(name string) (Mode, error) {
+	switch strings.ToLower(name) {
+	case "half", "halfblock":
+		return HalfBlock, nil
+	case "braille":
+		return Braille, nil
+	}
+	return 0, fmt.Errorf("Unknown terminal mode %q, expected half or braille", name)
+}
+
+//Keys with names rather than labels that a terminal can send
+var namedKeys = map[string]rune{
+	"space": ' ',
+	"tab":   '\t',
+	"enter": '\r',
+}
+
+//Escape sequences of the keys used for hotkeys, like the window's
+var hotkeySequences = map[string]chip8.Hotkey{
+	"\x1b[15~": chip8.HotkeySaveState,
+	"\x1b[20~": chip8.HotkeyLoadState,
+	"\x1b[21~": chip8.HotkeyRecord,
+	"\x1b[24~": chip8.HotkeyScreenshot,
+}
+
+//Backspace, held to rewind
+const rewindKey = 0x7F
+
+//Ctrl-C, since raw mode stops it sending a signal
+const quitKey = 0x03
+
+type Terminal struct {
+	Mode    Mode
+	Palette chip8.Palette
+	KeyMap  map[rune]byte
+
+	//How long after the last repeat of a key it counts as released. It
+	//needs to be longer than the terminal's key repeat delay for held keys
+	//to stay down
+	ReleaseAfter time.Duration
+
+	out *bufio.Writer
+
+	//What's on the screen, a line per row of characters
+	lines []string
+
+	//stty settings to put back on Close
+	saved string
+
+	mu      sync.Mutex
+	events  []chip8.KeyEvent
+	hotkeys []chip8.Hotkey
+	seen    map[byte]time.Time
+	rewind  time.Time
+	closed  bool
+}
+
+//Puts the terminal in raw mode and switches to its alternate screen. Call
+//Close to put it back
+func NewTerminal(mode Mode) (*Terminal, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("Error reading terminal settings, is stdin a terminal? %v", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("Error putting the terminal in raw mode: %v", err)
+	}
+
+	t := &Terminal{
+		Mode:         mode,
+		Palette:      chip8.DefaultPalette,
+		ReleaseAfter: 200 * time.Millisecond,
+		out:          bufio.NewWriter(os.Stdout),
+		saved:        strings.TrimSpace(saved),
+		seen:         map[byte]time.Time{},
+	}
+	t.SetKeymap(chip8.DefaultKeymap)
+
+	//Alternate screen, hidden cursor, cleared
+	t.out.WriteString("\x1b[?1049h\x1b[?25l\x1b[2J")
+	t.out.Flush()
+
+	go t.readKeys()
+	return t, nil
+}
+
+//Sets KeyMap from km. Only keys that type a single character, or the
+//named ones a terminal sends, can be used
+func (t *Terminal) SetKeymap(km chip8.Keymap) error {
+	keys := map[rune]byte{}
+	for i, name := range km {
+		r, ok := namedKeys[name]
+		if !ok {
+			if utf8.RuneCountInString(name) != 1 {
+				return fmt.Errorf("The %q key can't be read from a terminal", name)
+			}
+			r, _ = utf8.DecodeRuneInString(name)
+		}
+		keys[r] = byte(i)
+	}
+	t.mu.Lock()
+	t.KeyMap = keys
+	t.mu.Unlock()
+	return nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+//Puts the terminal back the way it was
+func (t *Terminal) Close() error {
+	t.out.WriteString("\x1b[0m\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	_, err := stty(t.saved)
+	return err
+}
+
+func (t *Terminal) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			t.mu.Lock()
+			t.closed = true
+			t.mu.Unlock()
+			return
+		}
+		t.handleInput(buf[:n])
+	}
+}
+
+func (t *Terminal) handleInput(in []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for len(in) > 0 {
+		if in[0] == 0x1B {
+			end := escapeLength(in)
+			if hotkey, ok := hotkeySequences[string(in[:end])]; ok {
+				t.hotkeys = append(t.hotkeys, hotkey)
+			}
+			in = in[end:]
+			continue
+		}
+
+		c, size := utf8.DecodeRune(in)
+		in = in[size:]
+		switch {
+		case c == quitKey:
+			t.closed = true
+		case c == rewindKey:
+			t.rewind = now
+		default:
+			key, ok := t.KeyMap[unicode.ToLower(c)]
+			if !ok {
+				continue
+			}
+			//Every repeat is a press, as the keypad only holds a key
+			//until a skip instruction reads it
+			t.events = append(t.events, chip8.KeyEvent{Key: key, Down: true})
+			t.seen[key] = now
+		}
+	}
+}
+
+//Length of the escape sequence at the start of in. CSI sequences (ESC [)
+//end with a letter or ~. SS3 sequences (ESC O), which F1-F4 and the arrows
+//in application mode send, are always one byte more. Anything else is ESC
+//and one byte, like Alt and a key
+func escapeLength(in []byte) int {
+	if len(in) < 2 {
+		return len(in)
+	}
+	switch in[1] {
+	case '[':
+		end := 2
+		for end < len(in) && !isFinal(in[end]) {
+			end++
+		}
+		if end < len(in) {
+			end++
+		}
+		return end
+	case 'O':
+		if len(in) < 3 {
+			return len(in)
+		}
+		return 3
+	}
+	return 2
+}
+
+func isFinal(c byte) bool {
+	return c == '~' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+//Returns the key presses since the last call, and releases for keys that
+//have stopped repeating. A held key sends a press every time the terminal
+//repeats it, like the window does
+func (t *Terminal) KeyEvents() []chip8.KeyEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	events := t.events
+	t.events = nil
+	for key, last := range t.seen {
+		if now.Sub(last) > t.ReleaseAfter {
+			delete(t.seen, key)
+			events = append(events, chip8.KeyEvent{Key: key, Down: false})
+		}
+	}
+	return events
+}
+
+func (t *Terminal) Hotkeys() []chip8.Hotkey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hotkeys := t.hotkeys
+	t.hotkeys = nil
+	if time.Since(t.rewind) < t.ReleaseAfter {
+		hotkeys = append(hotkeys, chip8.HotkeyRewind)
+	}
+	return hotkeys
+}
+
+func (t *Terminal) UpdateInput() {}
+
+func (t *Terminal) Closed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+//Draws the display, rewriting only the lines that changed since last time
+func (t *Terminal) DrawGraphics(fb chip8.Framebuffer) {
+	var lines []string
+	if t.Mode == Braille {
+		lines = t.braille(fb)
+	} else {
+		lines = t.halfBlock(fb)
+	}
+	if len(lines) != len(t.lines) {
+		t.out.WriteString("\x1b[0m\x1b[2J")
+		t.lines = make([]string, len(lines))
+	}
+	for i, line := range lines {
+		if line == t.lines[i] {
+			continue
+		}
+		fmt.Fprintf(t.out, "\x1b[%d;1H%s\x1b[0m", i+1, line)
+		t.lines[i] = line
+	}
+	t.out.Flush()
+}
+
+//The escape sequence that sets the foreground (38) or background (48) to
+//a pixel's colour
+func colour(p chip8.Palette, pix byte, ground int) string {
+	rgb := p[pix&3]
+	return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", ground, rgb.R, rgb.G, rgb.B)
+}
+
+//Each character is the pixel above in the foreground colour and the one
+//below in the background colour. Colours are only sent when they change
+func (t *Terminal) halfBlock(fb chip8.Framebuffer) []string {
+	lines := make([]string, 0, (fb.Height+1)/2)
+	for y := 0; y < fb.Height; y += 2 {
+		var b strings.Builder
+		fg, bg := -1, -1
+		for x := 0; x < fb.Width; x++ {
+			top, bottom := fb.At(x, y), byte(0)
+			if y+1 < fb.Height {
+				bottom = fb.At(x, y+1)
+			}
+			if int(top) != fg {
+				b.WriteString(colour(t.Palette, top, 38))
+				fg = int(top)
+			}
+			if int(bottom) != bg {
+				b.WriteString(colour(t.Palette, bottom, 48))
+				bg = int(bottom)
+			}
+			b.WriteString("▀")
+		}
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+//Braille cells are 2 pixels wide and 4 tall. Bits of the character are
+//the dots in this order
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+func (t *Terminal) braille(fb chip8.Framebuffer) []string {
+	lines := make([]string, 0, (fb.Height+3)/4)
+	prefix := colour(t.Palette, 1, 38) + colour(t.Palette, 0, 48)
+	for y := 0; y < fb.Height; y += 4 {
+		var b strings.Builder
+		b.WriteString(prefix)
+		for x := 0; x < fb.Width; x += 2 {
+			r := rune(0x2800)
+			for dy := 0; dy < 4 && y+dy < fb.Height; dy++ {
+				for dx := 0; dx < 2 && x+dx < fb.Width; dx++ {
+					if fb.At(x+dx, y+dy) != 0 {
+						r |= brailleDots[dy][dx]
+					}
+				}
+			}
+			b.WriteRune(r)
+		}
+		lines = append(lines, b.String())
+	}
+	return lines
+}
@@ -0,0 +1,112 @@
+package tty
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	chip8 "alex/chip8/emulator"
+)
+
+func newTestTerminal(t *testing.T, keymap string) *Terminal {
+	t.Helper()
+	term := &Terminal{seen: map[byte]time.Time{}, ReleaseAfter: time.Hour}
+	km, err := chip8.ParseKeymap(keymap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := term.SetKeymap(km); err != nil {
+		t.Fatal(err)
+	}
+	return term
+}
+
+func TestHandleInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		keymap  string
+		in      string
+		keys    []chip8.KeyEvent
+		hotkeys []chip8.Hotkey
+		closed  bool
+	}{
+		{"keys", "qwerty", "1wV", []chip8.KeyEvent{{Key: 0x1, Down: true}, {Key: 0x5, Down: true}, {Key: 0xF, Down: true}}, nil, false},
+		{"repeats", "qwerty", "www", []chip8.KeyEvent{{Key: 0x5, Down: true}, {Key: 0x5, Down: true}, {Key: 0x5, Down: true}}, nil, false},
+		{"multibyte", "azerty", "é&", []chip8.KeyEvent{{Key: 0x2, Down: true}, {Key: 0x1, Down: true}}, nil, false},
+		{"csi hotkey", "qwerty", "\x1b[15~q", []chip8.KeyEvent{{Key: 0x4, Down: true}}, []chip8.Hotkey{chip8.HotkeySaveState}, false},
+		{"csi arrow", "qwerty", "\x1b[Aq", []chip8.KeyEvent{{Key: 0x4, Down: true}}, nil, false},
+		{"ss3 f1", "hex", "\x1bOPa", []chip8.KeyEvent{{Key: 0xA, Down: true}}, nil, false},
+		{"ss3 arrow", "hex", "\x1bOA\x1bOB", nil, nil, false},
+		{"alt key", "qwerty", "\x1bqw", []chip8.KeyEvent{{Key: 0x5, Down: true}}, nil, false},
+		{"quit", "qwerty", "\x03", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := newTestTerminal(t, tt.keymap)
+			term.handleInput([]byte(tt.in))
+			if keys := term.KeyEvents(); !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("key events %v, want %v", keys, tt.keys)
+			}
+			if hotkeys := term.Hotkeys(); !reflect.DeepEqual(hotkeys, tt.hotkeys) {
+				t.Errorf("hotkeys %v, want %v", hotkeys, tt.hotkeys)
+			}
+			if term.Closed() != tt.closed {
+				t.Errorf("closed %v, want %v", term.Closed(), tt.closed)
+			}
+		})
+	}
+}
+
+func TestKeyRelease(t *testing.T) {
+	term := newTestTerminal(t, "qwerty")
+	term.ReleaseAfter = 20 * time.Millisecond
+	term.handleInput([]byte("q"))
+	if keys := term.KeyEvents(); len(keys) != 1 || !keys[0].Down {
+		t.Fatalf("key events %v, want a press", keys)
+	}
+	time.Sleep(2 * term.ReleaseAfter)
+	want := []chip8.KeyEvent{{Key: 0x4, Down: false}}
+	if keys := term.KeyEvents(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("key events %v, want %v", keys, want)
+	}
+}
+
+//A held key is pressed again each time the terminal repeats it, so the
+//keypad sees it down on every read until it's let go
+func TestKeyHeld(t *testing.T) {
+	term := newTestTerminal(t, "qwerty")
+	term.ReleaseAfter = 50 * time.Millisecond
+	press := []chip8.KeyEvent{{Key: 0x4, Down: true}}
+	for i := 0; i < 3; i++ {
+		term.handleInput([]byte("q"))
+		if keys := term.KeyEvents(); !reflect.DeepEqual(keys, press) {
+			t.Fatalf("repeat %d: key events %v, want %v", i, keys, press)
+		}
+		time.Sleep(term.ReleaseAfter / 5)
+	}
+	time.Sleep(2 * term.ReleaseAfter)
+	want := []chip8.KeyEvent{{Key: 0x4, Down: false}}
+	if keys := term.KeyEvents(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("key events %v after letting go, want %v", keys, want)
+	}
+}
+
+func TestDrawGraphics(t *testing.T) {
+	fb := chip8.Framebuffer{Width: 4, Height: 4, Pix: make([]byte, 16)}
+	fb.Pix[0] = 1
+	fb.Pix[5] = 1
+
+	half := (&Terminal{Palette: chip8.DefaultPalette}).halfBlock(fb)
+	if len(half) != 2 {
+		t.Fatalf("half block drew %d lines, want 2", len(half))
+	}
+	braille := (&Terminal{Palette: chip8.DefaultPalette}).braille(fb)
+	if len(braille) != 1 {
+		t.Fatalf("braille drew %d lines, want 1", len(braille))
+	}
+	//Dots 1 and 5 (0x01|0x10) in the first cell, nothing in the second
+	want := string([]rune{0x2811, 0x2800})
+	if got := braille[0][len(braille[0])-len(want):]; got != want {
+		t.Errorf("braille cells %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,292 @@
+//Package web is a frontend that serves the display to browsers. The page
+//it serves draws frames streamed to it over a WebSocket and sends the
+//keypad back, so a ROM can be shown off with nothing installed.
+//
+//Any number of tabs can watch the same machine. The first one to connect
+//plays, the rest spectate, and the next in line takes over when the player
+//leaves.
+package web
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+//Frames a client can fall behind by before frames get dropped for it, so a
+//slow tab can't hold up the machine
+const sendQueue = 4
+
+type Server struct {
+	Palette chip8.Palette
+
+	//Keys the page listens for, by the label the browser reports
+	Keymap chip8.Keymap
+
+	//Hosts besides the server's own, like example.com:8000, whose pages may
+	//connect. Browsers let any page open a WebSocket to localhost, so
+	//without this check any site could watch and press keys. "*" allows
+	//every origin
+	AllowedOrigins []string
+
+	mux *http.ServeMux
+
+	mu sync.Mutex
+
+	//Connected tabs, in the order they joined. The first one plays
+	clients []*client
+
+	//Last frame sent, for tabs that join between draws
+	frame []byte
+
+	events []chip8.KeyEvent
+
+	//Keys the player is holding, released if they leave
+	held   [16]bool
+	closed bool
+}
+
+type client struct {
+	ws *wsConn
+
+	//Frames waiting to go out
+	frames chan []byte
+
+	//The latest status, if it hasn't gone out yet. Only the latest matters
+	status chan []byte
+
+	//Closed when the tab has gone
+	done chan struct{}
+}
+
+//What the server tells a tab besides frames
+type status struct {
+	//Colours as CSS hex, background first
+	Palette []string `json:"palette"`
+
+	//Keyboard key for each keypad key, by keypad key
+	Keymap []string `json:"keymap"`
+
+	//"player" or "spectator"
+	Role string `json:"role"`
+
+	//Tabs connected, including this one
+	Viewers int `json:"viewers"`
+}
+
+//What a tab sends when a key goes down or up
+type keyMessage struct {
+	Key  byte `json:"key"`
+	Down bool `json:"down"`
+}
+
+func NewServer() *Server {
+	s := &Server{Palette: chip8.DefaultPalette, Keymap: chip8.DefaultKeymap, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/", s.serveIndex)
+	s.mux.HandleFunc("/ws", s.serveWebSocket)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(indexHTML)
+}
+
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !s.allowOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	ws, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	c := &client{
+		ws:     ws,
+		frames: make(chan []byte, sendQueue),
+		status: make(chan []byte, 1),
+		done:   make(chan struct{}),
+	}
+	go c.writeLoop()
+
+	s.mu.Lock()
+	s.clients = append(s.clients, c)
+	if s.frame != nil {
+		c.frames <- s.frame
+	}
+	s.sendStatus()
+	s.mu.Unlock()
+
+	for {
+		op, data, err := ws.read()
+		if err != nil {
+			break
+		}
+		var key keyMessage
+		if op != opText || json.Unmarshal(data, &key) != nil || key.Key > 0xF {
+			continue
+		}
+		s.mu.Lock()
+		if s.clients[0] == c {
+			s.press(key.Key, key.Down)
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	s.remove(c)
+	s.mu.Unlock()
+}
+
+//Reports whether the page asking for a WebSocket is the server's own or
+//one from AllowedOrigins. Requests without an Origin don't come from a
+//browser page, so they're let through
+func (s *Server) allowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, u.Host) || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+//Sends the tab its frames and status until it goes
+func (c *client) writeLoop() {
+	defer c.ws.close()
+	for {
+		var err error
+		select {
+		case data := <-c.status:
+			err = c.ws.write(opText, data)
+		case frame := <-c.frames:
+			err = c.ws.write(opBinary, frame)
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			//Closing makes the read in serveWebSocket fail, which removes
+			//the tab
+			return
+		}
+	}
+}
+
+//Queues a key event from the player. The page presses a held key again
+//every 200ms, like the window, so presses always go through but a key is
+//only let go once. The caller holds s.mu
+func (s *Server) press(key byte, down bool) {
+	if !down && !s.held[key] {
+		return
+	}
+	s.held[key] = down
+	s.events = append(s.events, chip8.KeyEvent{Key: key, Down: down})
+}
+
+//Drops a tab that has gone. If it was playing, its keys are let go and the
+//next tab plays. The caller holds s.mu
+func (s *Server) remove(c *client) {
+	for i, other := range s.clients {
+		if other != c {
+			continue
+		}
+		if i == 0 {
+			for key := range s.held {
+				s.press(byte(key), false)
+			}
+		}
+		s.clients = append(s.clients[:i], s.clients[i+1:]...)
+		close(c.done)
+		s.sendStatus()
+		return
+	}
+}
+
+//Tells every tab its role and the palette. The caller holds s.mu
+func (s *Server) sendStatus() {
+	palette := make([]string, len(s.Palette))
+	for i, c := range s.Palette {
+		palette[i] = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	for i, c := range s.clients {
+		st := status{Palette: palette, Keymap: s.Keymap[:], Role: "spectator", Viewers: len(s.clients)}
+		if i == 0 {
+			st.Role = "player"
+		}
+		data, _ := json.Marshal(st)
+		select {
+		case <-c.status:
+		default:
+		}
+		c.status <- data
+	}
+}
+
+//Sends the frame to every tab, as a byte each for the width and height and
+//then a byte per pixel. Tabs that are behind miss it
+func (s *Server) DrawGraphics(fb chip8.Framebuffer) {
+	frame := make([]byte, 2+len(fb.Pix))
+	frame[0], frame[1] = byte(fb.Width), byte(fb.Height)
+	copy(frame[2:], fb.Pix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frame = frame
+	for _, c := range s.clients {
+		select {
+		case c.frames <- frame:
+		default:
+		}
+	}
+}
+
+func (s *Server) UpdateInput() {}
+
+func (s *Server) KeyEvents() []chip8.KeyEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := s.events
+	s.events = nil
+	return events
+}
+
+func (s *Server) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+//Makes Closed report true, which stops Run. Tabs stay connected with the
+//last frame until the process exits
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
@@ -0,0 +1,169 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	chip8 "alex/chip8/emulator"
+)
+
+//A browser tab, as far as the server can tell
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, ts *httptest.Server, origin string) (*testClient, int) {
+	t.Helper()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	req := fmt.Sprintf("GET /ws HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n", addr)
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	fmt.Fprint(conn, req+"\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		//The example key and accept value from RFC 6455
+		if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf("Sec-WebSocket-Accept is %q", accept)
+		}
+	}
+	return &testClient{conn: conn, r: r}, resp.StatusCode
+}
+
+//Reads a message the server sent, which are never masked or fragmented
+func (c *testClient) read(t *testing.T) (byte, []byte) {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, head); err != nil {
+		t.Fatal(err)
+	}
+	n := int(head[1])
+	if n == 126 {
+		ext := make([]byte, 2)
+		io.ReadFull(c.r, ext)
+		n = int(ext[0])<<8 | int(ext[1])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0xF, payload
+}
+
+func (c *testClient) readStatus(t *testing.T) status {
+	t.Helper()
+	for {
+		op, data := c.read(t)
+		if op != opText {
+			continue
+		}
+		var st status
+		if err := json.Unmarshal(data, &st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+}
+
+//Sends a masked text message, like a browser
+func (c *testClient) send(msg string) {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame := append([]byte{0x80 | opText, 0x80 | byte(len(msg))}, mask...)
+	for i := range msg {
+		frame = append(frame, msg[i]^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func TestOrigin(t *testing.T) {
+	s := NewServer()
+	s.AllowedOrigins = []string{"friend.example:8000"}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{ts.URL, http.StatusSwitchingProtocols},
+		{"http://friend.example:8000", http.StatusSwitchingProtocols},
+		{"http://evil.example", http.StatusForbidden},
+		{"http://localhost.evil.example", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if _, code := dial(t, ts, tt.origin); code != tt.want {
+			t.Errorf("origin %q got status %d, want %d", tt.origin, code, tt.want)
+		}
+	}
+}
+
+func TestPlayersAndSpectators(t *testing.T) {
+	s := NewServer()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	player, _ := dial(t, ts, "")
+	if st := player.readStatus(t); st.Role != "player" || st.Viewers != 1 {
+		t.Fatalf("first tab got %+v", st)
+	}
+
+	fb := chip8.Framebuffer{Width: 64, Height: 32, Pix: make([]byte, 64*32)}
+	fb.Pix[3] = 1
+	s.DrawGraphics(fb)
+	op, frame := player.read(t)
+	if op != opBinary || len(frame) != 2+64*32 || frame[0] != 64 || frame[1] != 32 || frame[2+3] != 1 {
+		t.Fatalf("frame was op %d, %d bytes", op, len(frame))
+	}
+
+	spectator, _ := dial(t, ts, "")
+	if st := spectator.readStatus(t); st.Role != "spectator" || st.Viewers != 2 {
+		t.Fatalf("second tab got %+v", st)
+	}
+
+	spectator.send(`{"key":5,"down":true}`)
+	player.send(`{"key":7,"down":true}`)
+	time.Sleep(50 * time.Millisecond)
+	want := []chip8.KeyEvent{{Key: 7, Down: true}}
+	if got := s.KeyEvents(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("key events %v, want only the player's %v", got, want)
+	}
+
+	//The page presses a held key again while it's held, and every press
+	//reaches the keypad
+	player.send(`{"key":7,"down":true}`)
+	time.Sleep(50 * time.Millisecond)
+	if got := s.KeyEvents(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("key events %v for a held key, want %v", got, want)
+	}
+
+	//The player's keys are let go when they leave, and the spectator plays
+	player.conn.Close()
+	if st := spectator.readStatus(t); st.Role != "player" || st.Viewers != 1 {
+		t.Fatalf("remaining tab got %+v", st)
+	}
+	want = []chip8.KeyEvent{{Key: 7, Down: false}}
+	if got := s.KeyEvents(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("key events %v, want %v", got, want)
+	}
+}
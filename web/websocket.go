@@ -0,0 +1,167 @@
+package web
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//Just enough of RFC 6455 for the page: the handshake, and unfragmented
+//text and binary messages with pings and closes handled underneath
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+//Biggest message a client may send. Key events are tiny
+const maxMessage = 1 << 16
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+//Answers the WebSocket handshake and takes over the connection
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "Expected a WebSocket", http.StatusBadRequest)
+		return nil, errors.New("Not a WebSocket request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("Missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Can't take over the connection", http.StatusInternalServerError)
+		return nil, errors.New("ResponseWriter can't be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+//Reads the next text or binary message, answering pings on the way.
+//Returns io.EOF once the client closes
+func (c *wsConn) read() (op byte, msg []byte, err error) {
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case opClose:
+			c.write(opClose, nil)
+			return 0, nil, io.EOF
+		case opPing:
+			if err := c.write(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opText, opBinary:
+			op, msg = frameOp, payload
+		case opContinuation:
+			if op == 0 {
+				return 0, nil, errors.New("Continuation frame without a message")
+			}
+			msg = append(msg, payload...)
+			if len(msg) > maxMessage {
+				return 0, nil, errors.New("Message too big")
+			}
+		default:
+			return 0, nil, fmt.Errorf("Unknown WebSocket opcode %d", frameOp)
+		}
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0xF
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if !masked {
+		return false, 0, nil, errors.New("Client frames have to be masked")
+	}
+	if length > maxMessage {
+		return false, 0, nil, errors.New("Message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+//Sends a whole message in one frame. Servers don't mask
+func (c *wsConn) write(op byte, payload []byte) error {
+	head := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xFFFF:
+		head = append(head, 126)
+		head = binary.BigEndian.AppendUint16(head, uint16(n))
+	default:
+		head = append(head, 127)
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+	_, err := c.conn.Write(append(head, payload...))
+	return err
+}
+
+func (c *wsConn) close() error {
+	return c.conn.Close()
+}